@@ -0,0 +1,188 @@
+package redis
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"hash/fnv"
+	"math"
+
+	"github.com/gomodule/redigo/redis"
+	"github.com/thesky9531/lareina/log"
+)
+
+// 批量写入bloom过滤器时每批的数量
+const bloomSeedBatch = 1000
+
+// redis bitmap最大为512MB,即2^32个bit
+const bloomMaxBits = 1 << 32
+
+// 可执行查询的数据库对象,*sql.DB、*sql.Tx、*sqlx.DB、*sqlx.Tx 均满足
+type Queryer interface {
+	QueryContext(ctx context.Context, query string, args ...interface{}) (*sql.Rows, error)
+}
+
+// 基于redis bitmap的bloom过滤器
+type BloomFilter struct {
+	cache *Cache
+	key   string
+	m     uint64 // bit数
+	k     uint64 // hash次数
+}
+
+// 根据预期元素数量和误判率计算bit数和hash次数
+func bloomParams(n uint64, p float64) (m, k uint64) {
+	if n == 0 {
+		n = 1
+	}
+	if p <= 0 || p >= 1 {
+		p = 0.01
+	}
+	m = uint64(math.Ceil(-float64(n) * math.Log(p) / (math.Ln2 * math.Ln2)))
+	if m > bloomMaxBits {
+		m = bloomMaxBits
+	}
+	k = uint64(math.Round(float64(m) / float64(n) * math.Ln2))
+	if k == 0 {
+		k = 1
+	}
+	return
+}
+
+// NewBloomFilter 创建bloom过滤器,expectedItems为预期元素数量,fpRate为可接受的误判率
+func (c *Cache) NewBloomFilter(key string, expectedItems uint64, fpRate float64) *BloomFilter {
+	m, k := bloomParams(expectedItems, fpRate)
+	return &BloomFilter{
+		cache: c,
+		key:   c.nsKey(key),
+		m:     m,
+		k:     k,
+	}
+}
+
+// 使用双重hash计算元素对应的k个bit位置
+func (b *BloomFilter) locations(item string) []uint64 {
+	h := fnv.New64a()
+	h.Write([]byte(item))
+	sum := h.Sum64()
+	// h2取奇数,避免h2为0时k个位置全部相同
+	h1, h2 := sum&0xffffffff, sum>>32|1
+	locs := make([]uint64, b.k)
+	for i := uint64(0); i < b.k; i++ {
+		locs[i] = (h1 + i*h2) % b.m
+	}
+	return locs
+}
+
+func (b *BloomFilter) add(conn redis.Conn, items []string) error {
+	for _, item := range items {
+		for _, loc := range b.locations(item) {
+			if err := conn.Send("SETBIT", b.key, loc, 1); err != nil {
+				err = fmt.Errorf("BloomFilter conn.Send(SETBIT, %s) error(%v)", b.key, err)
+				log.ErrLog("", err)
+				return err
+			}
+		}
+	}
+	if _, err := conn.Do(""); err != nil {
+		err = fmt.Errorf("BloomFilter add(%s) error(%v)", b.key, err)
+		log.ErrLog("", err)
+		return err
+	}
+	return nil
+}
+
+// 添加元素
+func (b *BloomFilter) Add(ctx context.Context, items ...string) error {
+	if len(items) == 0 {
+		return nil
+	}
+	conn, err := b.cache.getConn(ctx)
+	if err != nil {
+		log.ErrLog("", fmt.Errorf("获取redis conn失败 key(%s),error(%v)", b.key, err))
+		return err
+	}
+	defer conn.Close()
+	return b.add(conn, items)
+}
+
+// 判断元素是否可能存在,返回false时一定不存在
+func (b *BloomFilter) Exists(ctx context.Context, item string) (bool, error) {
+	conn, err := b.cache.getConn(ctx)
+	if err != nil {
+		log.ErrLog("", fmt.Errorf("获取redis conn失败 key(%s),error(%v)", b.key, err))
+		return false, err
+	}
+	defer conn.Close()
+	for _, loc := range b.locations(item) {
+		if err = conn.Send("GETBIT", b.key, loc); err != nil {
+			err = fmt.Errorf("BloomFilter conn.Send(GETBIT, %s) error(%v)", b.key, err)
+			log.ErrLog("", err)
+			return false, err
+		}
+	}
+	bits, err := redis.Ints(conn.Do(""))
+	if err != nil {
+		err = fmt.Errorf("BloomFilter Exists(%s) error(%v)", b.key, err)
+		log.ErrLog("", err)
+		return false, err
+	}
+	for _, bit := range bits {
+		if bit == 0 {
+			return false, nil
+		}
+	}
+	return true, nil
+}
+
+// 清空过滤器
+func (b *BloomFilter) Reset(ctx context.Context) {
+	conn, err := b.cache.getConn(ctx)
+	if err != nil {
+		log.ErrLog("", fmt.Errorf("获取redis conn失败 key(%s),error(%v)", b.key, err))
+		return
+	}
+	defer conn.Close()
+	delKey(conn, b.key)
+}
+
+// 使用数据库查询结果批量写入,query只需返回一列,如 SELECT id FROM "user"
+func (b *BloomFilter) Seed(ctx context.Context, db Queryer, query string, args ...interface{}) (int64, error) {
+	rows, err := db.QueryContext(ctx, query, args...)
+	if err != nil {
+		log.ErrLog("", fmt.Errorf("BloomFilter Seed query(%s) error(%v)", query, err))
+		return 0, err
+	}
+	defer rows.Close()
+	var (
+		total int64
+		batch = make([]string, 0, bloomSeedBatch)
+	)
+	for rows.Next() {
+		var item sql.NullString
+		if err = rows.Scan(&item); err != nil {
+			log.ErrLog("", fmt.Errorf("BloomFilter Seed scan error(%v)", err))
+			return total, err
+		}
+		if !item.Valid {
+			continue
+		}
+		batch = append(batch, item.String)
+		if len(batch) >= bloomSeedBatch {
+			if err = b.Add(ctx, batch...); err != nil {
+				return total, err
+			}
+			total += int64(len(batch))
+			batch = batch[:0]
+		}
+	}
+	if err = rows.Err(); err != nil {
+		log.ErrLog("", fmt.Errorf("BloomFilter Seed rows error(%v)", err))
+		return total, err
+	}
+	if err = b.Add(ctx, batch...); err != nil {
+		return total, err
+	}
+	total += int64(len(batch))
+	return total, nil
+}
@@ -0,0 +1,42 @@
+package redis
+
+import (
+	"testing"
+)
+
+func TestBloomParams(t *testing.T) {
+	m, k := bloomParams(1000000, 0.01)
+	if m != 9585059 || k != 7 {
+		t.Errorf("bloomParams(1000000, 0.01) = %d, %d", m, k)
+	}
+	m, k = bloomParams(0, 0)
+	if m == 0 || k == 0 {
+		t.Errorf("bloomParams(0, 0) = %d, %d", m, k)
+	}
+	// 超过redis bitmap上限时截断
+	if m, k = bloomParams(1<<40, 0.0001); m != bloomMaxBits || k == 0 {
+		t.Errorf("bloomParams(1<<40, 0.0001) = %d, %d", m, k)
+	}
+}
+
+func TestBloomLocations(t *testing.T) {
+	b := New(&Config{})
+	f := b.NewBloomFilter("test", 1000, 0.001)
+	locs := f.locations("user_42")
+	if uint64(len(locs)) != f.k {
+		t.Fatalf("locations len = %d, want %d", len(locs), f.k)
+	}
+	seen := make(map[uint64]bool)
+	for i, loc := range locs {
+		if seen[loc] {
+			t.Errorf("location %d repeated", loc)
+		}
+		seen[loc] = true
+		if loc >= f.m {
+			t.Errorf("location %d out of range %d", loc, f.m)
+		}
+		if again := f.locations("user_42")[i]; again != loc {
+			t.Errorf("location not stable %d != %d", again, loc)
+		}
+	}
+}
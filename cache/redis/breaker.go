@@ -0,0 +1,130 @@
+package redis
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/gomodule/redigo/redis"
+	"github.com/thesky9531/lareina/log"
+)
+
+// 熔断打开时所有redis操作直接返回该错误
+var ErrCircuitOpen = errors.New("redis: circuit breaker is open")
+
+const defaultBreakerProbe = 5 // 秒
+
+// redis熔断器,连续失败达到阈值后打开,打开期间定时PING探测,成功后关闭
+type breaker struct {
+	mu       sync.Mutex
+	open     bool
+	failures int
+}
+
+// 熔断是否打开
+func (c *Cache) CircuitOpen() bool {
+	c.breaker.mu.Lock()
+	defer c.breaker.mu.Unlock()
+	return c.breaker.open
+}
+
+// 网络错误、超时等连接层面的错误计入失败,redis返回的错误和调用方取消不计入
+func isConnError(err error) bool {
+	if err == nil || err == redis.ErrNil || err == redis.ErrPoolExhausted {
+		return false
+	}
+	if err == context.Canceled || err == context.DeadlineExceeded {
+		return false
+	}
+	if _, ok := err.(redis.Error); ok {
+		return false
+	}
+	return true
+}
+
+func (c *Cache) recordResult(err error) {
+	if c.conf.BreakerThreshold <= 0 {
+		return
+	}
+	failed := isConnError(err)
+	c.breaker.mu.Lock()
+	defer c.breaker.mu.Unlock()
+	if !failed {
+		c.breaker.failures = 0
+		return
+	}
+	c.breaker.failures++
+	if c.breaker.open || c.breaker.failures < c.conf.BreakerThreshold {
+		return
+	}
+	c.breaker.open = true
+	log.ErrLog("redis circuit breaker open", fmt.Errorf("%d consecutive failures, last error(%v)", c.breaker.failures, err))
+	go c.probe()
+}
+
+// 熔断打开后定时探测,PING成功后关闭熔断
+func (c *Cache) probe() {
+	interval := time.Duration(c.conf.BreakerProbeInterval) * time.Second
+	if interval <= 0 {
+		interval = defaultBreakerProbe * time.Second
+	}
+	t := time.NewTicker(interval)
+	defer t.Stop()
+	for {
+		select {
+		case <-c.closed:
+			return
+		case <-t.C:
+		}
+		ctx, cancel := context.WithTimeout(context.Background(), interval)
+		err := c.ping(ctx)
+		cancel()
+		if err != nil {
+			log.ErrLog("redis circuit breaker probe", err)
+			continue
+		}
+		c.breaker.mu.Lock()
+		c.breaker.open = false
+		c.breaker.failures = 0
+		c.breaker.mu.Unlock()
+		log.ErrLog("redis circuit breaker closed", nil)
+		return
+	}
+}
+
+func (c *Cache) ping(ctx context.Context) error {
+	conn, err := c.pool.GetContext(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	_, err = conn.Do("PING")
+	return err
+}
+
+// 记录命令执行结果的连接
+type breakerConn struct {
+	redis.Conn
+	c *Cache
+}
+
+func (b *breakerConn) Do(cmd string, args ...interface{}) (interface{}, error) {
+	reply, err := b.Conn.Do(cmd, args...)
+	b.c.recordResult(err)
+	return reply, err
+}
+
+// 获取redis连接,熔断打开时直接返回 ErrCircuitOpen
+func (c *Cache) getConn(ctx context.Context) (redis.Conn, error) {
+	if c.CircuitOpen() {
+		return nil, ErrCircuitOpen
+	}
+	conn, err := c.pool.GetContext(ctx)
+	if err != nil {
+		c.recordResult(err)
+		return nil, err
+	}
+	return &breakerConn{Conn: conn, c: c}, nil
+}
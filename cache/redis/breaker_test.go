@@ -0,0 +1,59 @@
+package redis
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+	"time"
+)
+
+func TestQueryWithRedisDown(t *testing.T) {
+	c := New(&Config{
+		Network:           "tcp",
+		Addr:              "127.0.0.1:1",
+		ExpireTime:        60,
+		BreakerThreshold:  2,
+		LocalFallbackSize: 10,
+	})
+	defer c.Close()
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	if lock := c.Lock(ctx, "user_1"); lock != nil || ctx.Err() != nil {
+		t.Fatalf("Lock with redis down = %v, ctx %v", lock, ctx.Err())
+	}
+	loads := 0
+	update := func() (json.RawMessage, error) {
+		loads++
+		return json.RawMessage(`{"id":1}`), nil
+	}
+	for i := 0; i < 3; i++ {
+		rsp, err := c.QueryRawMessage(ctx, "user_1", update)
+		if err != nil || string(rsp) != `{"id":1}` {
+			t.Fatalf("QueryRawMessage = %s, %v", rsp, err)
+		}
+	}
+	if !c.CircuitOpen() {
+		t.Fatal("circuit breaker should be open")
+	}
+	// 熔断后使用本地缓存,不再重复调用update
+	if loads != 2 {
+		t.Errorf("update called %d times, want 2", loads)
+	}
+	if _, err := c.GetRawMessage(ctx, "user_1"); err != ErrCircuitOpen {
+		t.Errorf("GetRawMessage error = %v, want ErrCircuitOpen", err)
+	}
+}
+
+func TestBreakerDisabledByDefault(t *testing.T) {
+	c := New(&Config{Network: "tcp", Addr: "127.0.0.1:1", ExpireTime: 60})
+	defer c.Close()
+	for i := 0; i < 10; i++ {
+		if _, err := c.GetRawMessage(context.Background(), "user_1"); err == ErrCircuitOpen {
+			t.Fatal("circuit breaker opened without BreakerThreshold")
+		}
+	}
+	if c.CircuitOpen() {
+		t.Fatal("circuit breaker should be disabled")
+	}
+}
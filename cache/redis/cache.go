@@ -3,6 +3,7 @@ package redis
 import (
 	"context"
 	"github.com/gomodule/redigo/redis"
+	"sync"
 	"time"
 )
 
@@ -15,6 +16,42 @@ type Config struct {
 	Wait        bool
 	ExpireTime  int
 	PassWord    string
+	// 数据库编号,默认0
+	DB int
+	// 命名空间,不为空时所有key加上 "Namespace:" 前缀
+	Namespace string
+	// 开启命名空间版本,缓存key加上版本号,BumpNamespaceVersion 后旧版本的缓存全部失效
+	NamespaceVersion bool
+	// 字符串值超过该字节数时压缩存储,0为不压缩
+	CompressThreshold int
+	// 压缩算法 gzip/zstd/snappy,默认gzip
+	CompressType string
+	// 连续失败多少次后打开熔断,0为不启用
+	BreakerThreshold int
+	// 熔断打开后的探测间隔(秒),默认5
+	BreakerProbeInterval int
+	// 熔断期间Query*使用的本地缓存条数,0为不使用本地缓存
+	LocalFallbackSize int
+	// 本地缓存过期时间(秒),默认60
+	LocalFallbackTTL int
+	// Transaction冲突时的最大重试次数,默认5
+	TxMaxRetry int
+	// 开启热点key探测,统计GetRawMessage/GetObject的访问
+	HotKeyDetect bool
+	// 统计窗口(秒),默认10
+	HotKeyWindow int
+	// 窗口内访问次数超过该值视为热点,默认1000
+	HotKeyThreshold int
+	// 每个窗口上报的热点key数量,默认10
+	HotKeyTopK int
+	// 每N次访问采样一次,默认100,为1时全部采样
+	HotKeySampleRate int
+	// 热点key提升到本地缓存的过期时间(秒),0为只探测不提升
+	HotKeyLocalTTL int
+	// 本地缓存的热点key数量,默认1000
+	HotKeyLocalSize int
+	// 延迟双删第二次删除的延迟(毫秒),应大于一次读db并回写缓存的耗时,默认1000
+	DoubleDeleteDelay int
 }
 
 type KeyValue struct {
@@ -23,12 +60,19 @@ type KeyValue struct {
 }
 
 type Cache struct {
-	pool *redis.Pool
-	conf *Config
+	pool    *redis.Pool
+	conf    *Config
+	ns      namespace
+	crypt   keyring
+	breaker breaker
+	local   *localCache
+	hot     *hotKeys
+	closed  chan struct{}
+	once    sync.Once
 }
 
 func New(c *Config) *Cache {
-	return &Cache{
+	cache := &Cache{
 		pool: &redis.Pool{
 			DialContext: func(ctx context.Context) (conn redis.Conn, e error) {
 				conn, e = redis.DialContext(
@@ -36,6 +80,7 @@ func New(c *Config) *Cache {
 					c.Network,
 					c.Addr,
 					redis.DialPassword(c.PassWord),
+					redis.DialDatabase(c.DB),
 				)
 				return
 			},
@@ -44,10 +89,26 @@ func New(c *Config) *Cache {
 			IdleTimeout: time.Second * time.Duration(c.IdleTimeout),
 			Wait:        c.Wait,
 		},
-		conf: c,
+		conf:   c,
+		local:  newLocalCache(c.LocalFallbackSize, time.Duration(c.LocalFallbackTTL)*time.Second),
+		hot:    newHotKeys(c),
+		closed: make(chan struct{}),
 	}
+	if cache.hot != nil {
+		go cache.runHotKeys()
+	}
+	if c.Namespace != "" && c.NamespaceVersion {
+		// 先同步加载一次,避免启动后使用旧版本的key
+		cache.loadNamespaceVersion()
+		go cache.runNamespaceVersion()
+	}
+	return cache
 }
 
+// 关闭连接池并停止后台任务,可以重复调用
 func (c *Cache) Close() {
-	c.pool.Close()
+	c.once.Do(func() {
+		close(c.closed)
+		c.pool.Close()
+	})
 }
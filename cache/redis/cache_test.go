@@ -0,0 +1,34 @@
+package redis
+
+import (
+	"testing"
+
+	"github.com/alicebob/miniredis/v2"
+)
+
+// 使用miniredis创建测试用的Cache,conf为nil时使用默认配置
+func newTestCache(t *testing.T, conf *Config) (*Cache, *miniredis.Miniredis) {
+	mr, err := miniredis.Run()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if conf == nil {
+		conf = &Config{}
+	}
+	conf.Network, conf.Addr = "tcp", mr.Addr()
+	if conf.ExpireTime == 0 {
+		conf.ExpireTime = 60
+	}
+	c := New(conf)
+	t.Cleanup(func() {
+		c.Close()
+		mr.Close()
+	})
+	return c, mr
+}
+
+func TestCacheCloseTwice(t *testing.T) {
+	c := New(&Config{})
+	c.Close()
+	c.Close()
+}
@@ -0,0 +1,342 @@
+package redis
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/thesky9531/lareina/log"
+)
+
+// 结构体在hash中对应的字段
+//
+// 字段名取自tag `redis:"name,omitempty"`,没有tag时使用结构体字段名,`redis:"-"` 忽略该字段。
+// 没有tag的匿名结构体(或结构体指针)字段展开到外层。
+type hashField struct {
+	name      string
+	index     []int
+	omitEmpty bool
+}
+
+var (
+	hashFieldCache sync.Map // map[reflect.Type][]hashField
+	timeType       = reflect.TypeOf(time.Time{})
+)
+
+func cachedHashFields(t reflect.Type) []hashField {
+	if f, ok := hashFieldCache.Load(t); ok {
+		return f.([]hashField)
+	}
+	f, _ := hashFieldCache.LoadOrStore(t, typeHashFields(t, nil))
+	return f.([]hashField)
+}
+
+// 解析结构体字段,外层字段与展开的匿名结构体字段同名时外层优先
+func typeHashFields(t reflect.Type, index []int) []hashField {
+	fields := make([]hashField, 0, t.NumField())
+	embedded := make([]hashField, 0)
+	for i := 0; i < t.NumField(); i++ {
+		sf := t.Field(i)
+		tag := sf.Tag.Get("redis")
+		if tag == "-" {
+			continue
+		}
+		if sf.PkgPath != "" && !sf.Anonymous {
+			continue
+		}
+		name, opts := tag, ""
+		if idx := strings.Index(tag, ","); idx != -1 {
+			name, opts = tag[:idx], tag[idx+1:]
+		}
+		fieldIndex := make([]int, len(index)+1)
+		copy(fieldIndex, index)
+		fieldIndex[len(index)] = i
+		ft := sf.Type
+		if ft.Kind() == reflect.Ptr {
+			ft = ft.Elem()
+		}
+		if sf.Anonymous && name == "" && ft.Kind() == reflect.Struct && ft != timeType {
+			embedded = append(embedded, typeHashFields(ft, fieldIndex)...)
+			continue
+		}
+		if sf.PkgPath != "" {
+			continue
+		}
+		if name == "" {
+			name = sf.Name
+		}
+		fields = append(fields, hashField{
+			name:      name,
+			index:     fieldIndex,
+			omitEmpty: strings.Contains(","+opts+",", ",omitempty,"),
+		})
+	}
+	names := make(map[string]bool, len(fields))
+	for _, f := range fields {
+		names[f.name] = true
+	}
+	for _, f := range embedded {
+		if !names[f.name] {
+			names[f.name] = true
+			fields = append(fields, f)
+		}
+	}
+	return fields
+}
+
+// 按字段路径取值,alloc为true时为空的匿名结构体指针分配内存,否则返回无效值
+func fieldByIndex(v reflect.Value, index []int, alloc bool) reflect.Value {
+	for i, x := range index {
+		if i > 0 && v.Kind() == reflect.Ptr {
+			if v.IsNil() {
+				if !alloc {
+					return reflect.Value{}
+				}
+				v.Set(reflect.New(v.Type().Elem()))
+			}
+			v = v.Elem()
+		}
+		v = v.Field(x)
+	}
+	return v
+}
+
+func isEmptyValue(v reflect.Value) bool {
+	switch v.Kind() {
+	case reflect.Array, reflect.Map, reflect.Slice, reflect.String:
+		return v.Len() == 0
+	case reflect.Bool:
+		return !v.Bool()
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return v.Int() == 0
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		return v.Uint() == 0
+	case reflect.Float32, reflect.Float64:
+		return v.Float() == 0
+	case reflect.Interface, reflect.Ptr:
+		return v.IsNil()
+	case reflect.Struct:
+		if v.Type() == timeType {
+			return v.Interface().(time.Time).IsZero()
+		}
+	}
+	return false
+}
+
+// 数字、字符串、布尔和时间使用原生格式编码,数字字段可以直接使用 HINCRBY,其他类型使用json
+func encodeHashValue(v reflect.Value) ([]byte, error) {
+	switch v.Kind() {
+	case reflect.String:
+		return []byte(v.String()), nil
+	case reflect.Bool:
+		if v.Bool() {
+			return []byte("1"), nil
+		}
+		return []byte("0"), nil
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return strconv.AppendInt(nil, v.Int(), 10), nil
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		return strconv.AppendUint(nil, v.Uint(), 10), nil
+	case reflect.Float32:
+		return strconv.AppendFloat(nil, v.Float(), 'f', -1, 32), nil
+	case reflect.Float64:
+		return strconv.AppendFloat(nil, v.Float(), 'f', -1, 64), nil
+	case reflect.Ptr:
+		if v.IsNil() {
+			return []byte{}, nil
+		}
+		return encodeHashValue(v.Elem())
+	case reflect.Slice:
+		if v.Type().Elem().Kind() == reflect.Uint8 {
+			return v.Bytes(), nil
+		}
+	case reflect.Struct:
+		if v.Type() == timeType {
+			return []byte(v.Interface().(time.Time).Format(time.RFC3339Nano)), nil
+		}
+	}
+	return json.Marshal(v.Interface())
+}
+
+// 字符串和[]byte原样存储,值为null或带引号时也是原始内容
+func hashRawType(t reflect.Type) bool {
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	return t.Kind() == reflect.String || t.Kind() == reflect.Slice && t.Elem().Kind() == reflect.Uint8
+}
+
+func decodeHashValue(data []byte, v reflect.Value) error {
+	// 兼容旧版本json编码的数字、布尔等值,null解码为零值
+	if string(data) == "null" && !hashRawType(v.Type()) {
+		v.Set(reflect.Zero(v.Type()))
+		return nil
+	}
+	switch v.Kind() {
+	case reflect.String:
+		v.SetString(string(data))
+		return nil
+	case reflect.Bool:
+		b, err := strconv.ParseBool(string(data))
+		if err != nil {
+			return err
+		}
+		v.SetBool(b)
+		return nil
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		n, err := strconv.ParseInt(string(data), 10, v.Type().Bits())
+		if err != nil {
+			return err
+		}
+		v.SetInt(n)
+		return nil
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		n, err := strconv.ParseUint(string(data), 10, v.Type().Bits())
+		if err != nil {
+			return err
+		}
+		v.SetUint(n)
+		return nil
+	case reflect.Float32, reflect.Float64:
+		n, err := strconv.ParseFloat(string(data), v.Type().Bits())
+		if err != nil {
+			return err
+		}
+		v.SetFloat(n)
+		return nil
+	case reflect.Ptr:
+		if v.IsNil() {
+			v.Set(reflect.New(v.Type().Elem()))
+		}
+		return decodeHashValue(data, v.Elem())
+	case reflect.Slice:
+		if v.Type().Elem().Kind() == reflect.Uint8 {
+			v.SetBytes(append([]byte(nil), data...))
+			return nil
+		}
+	case reflect.Struct:
+		if v.Type() == timeType {
+			// 兼容旧版本json编码的时间
+			t, err := time.Parse(time.RFC3339Nano, strings.Trim(string(data), `"`))
+			if err != nil {
+				return err
+			}
+			v.Set(reflect.ValueOf(t))
+			return nil
+		}
+	}
+	return json.Unmarshal(data, v.Addr().Interface())
+}
+
+func structValue(v reflect.Value) (reflect.Value, error) {
+	for v.Kind() == reflect.Ptr {
+		if v.IsNil() {
+			return v, errors.New("redis hash codec: nil struct pointer")
+		}
+		v = v.Elem()
+	}
+	if v.Kind() != reflect.Struct {
+		return v, fmt.Errorf("redis hash codec: data not struct or struct pointer (%s)", v.Kind())
+	}
+	return v, nil
+}
+
+// 编码结构体,返回需要写入的字段以及因omitempty被省略的字段名
+func encodeHash(dataValue reflect.Value) (data map[string][]byte, omitted []string, err error) {
+	dataValue, err = structValue(dataValue)
+	if err != nil {
+		return nil, nil, err
+	}
+	fields := cachedHashFields(dataValue.Type())
+	data = make(map[string][]byte, len(fields))
+	for _, f := range fields {
+		fv := fieldByIndex(dataValue, f.index, false)
+		if !fv.IsValid() || (f.omitEmpty && isEmptyValue(fv)) {
+			omitted = append(omitted, f.name)
+			continue
+		}
+		v, err := encodeHashValue(fv)
+		if err != nil {
+			return nil, nil, fmt.Errorf("redis hash codec: encode field %s error(%v)", f.name, err)
+		}
+		data[f.name] = v
+	}
+	return data, omitted, nil
+}
+
+// 因omitempty省略的字段值为nil,写入时从hash中删除
+func marshalRedisObj(dataValue reflect.Value) (map[string][]byte, error) {
+	data, omitted, err := encodeHash(dataValue)
+	if err != nil {
+		log.ErrLog("", err)
+		return data, err
+	}
+	for _, name := range omitted {
+		data[name] = nil
+	}
+	return data, nil
+}
+
+// 解码hash数据到结构体指针,值为空的字段保持原值
+func unmarshalRedisObj(data map[string][]byte, baseValue reflect.Value) (err error) {
+	if baseValue.Kind() != reflect.Ptr {
+		err = fmt.Errorf("unmarshal fail %s", baseValue.Kind().String())
+		log.ErrLog("", err)
+		return
+	}
+	if baseValue, err = structValue(baseValue); err != nil {
+		log.ErrLog("", err)
+		return
+	}
+	fields := cachedHashFields(baseValue.Type())
+	byName := make(map[string]*hashField, len(fields))
+	for i := range fields {
+		byName[fields[i].name] = &fields[i]
+	}
+	for name, v := range data {
+		f, ok := byName[name]
+		if !ok {
+			err = fmt.Errorf("cant find field %s", name)
+			log.ErrLog("", err)
+			return
+		}
+		if len(v) == 0 {
+			continue
+		}
+		if err = decodeHashValue(v, fieldByIndex(baseValue, f.index, true)); err != nil {
+			err = fmt.Errorf("redis hash codec: decode field %s error(%v)", name, err)
+			log.ErrLog("", err)
+			return
+		}
+	}
+	return nil
+}
+
+// 比较新旧对象,返回值发生变化的字段和变为空需要删除的字段
+func diffHash(prev, cur reflect.Value) (changed map[string][]byte, removed []string, err error) {
+	prevData, _, err := encodeHash(prev)
+	if err != nil {
+		return nil, nil, err
+	}
+	curData, omitted, err := encodeHash(cur)
+	if err != nil {
+		return nil, nil, err
+	}
+	changed = make(map[string][]byte)
+	for name, v := range curData {
+		if old, ok := prevData[name]; !ok || string(old) != string(v) {
+			changed[name] = v
+		}
+	}
+	for _, name := range omitted {
+		if _, ok := prevData[name]; ok {
+			removed = append(removed, name)
+		}
+	}
+	return changed, removed, nil
+}
@@ -0,0 +1,141 @@
+package redis
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+type codecBase struct {
+	ID      int64 `redis:"id"`
+	Created time.Time
+}
+
+type codecUser struct {
+	codecBase
+	Name    string            `redis:"name"`
+	Nick    string            `redis:"nick,omitempty"`
+	Score   float64           `redis:"score"`
+	Active  bool              `redis:"active"`
+	Age     *int              `redis:"age,omitempty"`
+	Tags    map[string]string `redis:"tags"`
+	Secret  string            `redis:"-"`
+	private int
+}
+
+func TestHashCodecRoundTrip(t *testing.T) {
+	age := 18
+	in := codecUser{
+		codecBase: codecBase{ID: 42, Created: time.Date(2021, 3, 4, 5, 6, 7, 8, time.UTC)},
+		Name:      "null user",
+		Score:     1.5,
+		Active:    true,
+		Age:       &age,
+		Tags:      map[string]string{"a": "b"},
+		Secret:    "x",
+	}
+	data, err := marshalRedisObj(reflect.ValueOf(&in))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]string{
+		"id":      "42",
+		"Created": "2021-03-04T05:06:07.000000008Z",
+		"name":    "null user",
+		"score":   "1.5",
+		"active":  "1",
+		"age":     "18",
+		"tags":    `{"a":"b"}`,
+	}
+	// omitempty省略的字段值为nil
+	if v, ok := data["nick"]; !ok || v != nil || len(data) != len(want)+1 {
+		t.Fatalf("marshal fields = %v", data)
+	}
+	for k, v := range want {
+		if string(data[k]) != v {
+			t.Errorf("field %s = %q, want %q", k, data[k], v)
+		}
+	}
+	var out codecUser
+	if err = unmarshalRedisObj(data, reflect.ValueOf(&out)); err != nil {
+		t.Fatal(err)
+	}
+	in.Secret = ""
+	if !reflect.DeepEqual(in, out) {
+		t.Errorf("unmarshal = %+v, want %+v", out, in)
+	}
+}
+
+func TestHashCodecEmptyValue(t *testing.T) {
+	var out codecUser
+	err := unmarshalRedisObj(map[string][]byte{"name": nil, "nick": []byte("")}, reflect.ValueOf(&out))
+	if err != nil || out.Name != "" || out.Nick != "" {
+		t.Errorf("unmarshal empty = %+v, %v", out, err)
+	}
+	if err = unmarshalRedisObj(map[string][]byte{"unknown": []byte("1")}, reflect.ValueOf(&out)); err == nil {
+		t.Error("unmarshal unknown field should fail")
+	}
+}
+
+func TestHashCodecLegacyJSON(t *testing.T) {
+	var out codecUser
+	data := map[string][]byte{
+		"id":      []byte(`7`),
+		"active":  []byte(`true`),
+		"score":   []byte(`null`),
+		"age":     []byte(`null`),
+		"Created": []byte(`"2021-03-04T05:06:07Z"`),
+	}
+	out.Score, out.Age = 1, new(int)
+	if err := unmarshalRedisObj(data, reflect.ValueOf(&out)); err != nil {
+		t.Fatal(err)
+	}
+	if out.Score != 0 || out.Age != nil || out.ID != 7 || !out.Active ||
+		!out.Created.Equal(time.Date(2021, 3, 4, 5, 6, 7, 0, time.UTC)) {
+		t.Errorf("unmarshal legacy = %+v", out)
+	}
+}
+
+func TestHashCodecRawString(t *testing.T) {
+	for _, s := range []string{"null", `"x"`, `"Bob"`, `""`} {
+		in := codecUser{Name: s, Nick: s}
+		data, err := marshalRedisObj(reflect.ValueOf(&in))
+		if err != nil {
+			t.Fatal(err)
+		}
+		var out codecUser
+		if err = unmarshalRedisObj(data, reflect.ValueOf(&out)); err != nil {
+			t.Fatal(err)
+		}
+		if out.Name != s || out.Nick != s {
+			t.Errorf("round trip %q = %q, %q", s, out.Name, out.Nick)
+		}
+	}
+}
+
+func TestSetKeyHashUnknownField(t *testing.T) {
+	c, _ := newTestCache(t, nil)
+	conn := c.pool.Get()
+	defer conn.Close()
+	data := map[string][]byte{"name": []byte("a")}
+	if err := setKeyHash(conn, "k", []string{"name", "missing"}, data, 60); err == nil {
+		t.Error("setKeyHash unknown field should fail")
+	}
+}
+
+func TestDiffHash(t *testing.T) {
+	prev := codecUser{Name: "a", Nick: "n", Score: 1}
+	cur := prev
+	cur.Score = 2
+	cur.Nick = ""
+	changed, removed, err := diffHash(reflect.ValueOf(prev), reflect.ValueOf(&cur))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(changed) != 1 || string(changed["score"]) != "2" {
+		t.Errorf("changed = %v", changed)
+	}
+	if !reflect.DeepEqual(removed, []string{"nick"}) {
+		t.Errorf("removed = %v", removed)
+	}
+}
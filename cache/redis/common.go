@@ -9,86 +9,116 @@ import (
 )
 
 func (c *Cache) DelKey(ctx context.Context, key string) {
-	conn, err := c.pool.GetContext(ctx)
+	defer c.hotDel(key)
+	conn, err := c.getConn(ctx)
 	if err != nil {
 		log.ErrLog("", fmt.Errorf("获取redis conn失败 key(%s),error(%v)", key, err))
 		return
 	}
 	defer conn.Close()
-	delKey(conn, key)
+	delKey(conn, c.key(key))
 }
 
 func (c *Cache) DelMultiKey(ctx context.Context, keys ...string) {
-	conn, err := c.pool.GetContext(ctx)
+	defer c.hotDel(keys...)
+	conn, err := c.getConn(ctx)
 	if err != nil {
 		log.ErrLog("", fmt.Errorf("获取redis conn失败 key(%v),error(%v)", keys, err))
 		return
 	}
 	defer conn.Close()
 	for _, key := range keys {
-		delKey(conn, key)
+		delKey(conn, c.key(key))
 	}
 }
 
 func (c *Cache) RegexpDelKey(ctx context.Context, key string) {
-	conn, err := c.pool.GetContext(ctx)
+	defer c.hotDelPattern(key)
+	conn, err := c.getConn(ctx)
 	if err != nil {
 		log.ErrLog("", fmt.Errorf("获取redis conn失败 key(%s),error(%v)", key, err))
 		return
 	}
 	defer conn.Close()
-	regexpDelKey(conn, key)
+	regexpDelKey(conn, c.key(key))
 }
 
 func (c *Cache) RegexpDelMultiKey(ctx context.Context, keys ...string) {
-	conn, err := c.pool.GetContext(ctx)
+	defer c.hotDelPattern(keys...)
+	conn, err := c.getConn(ctx)
 	if err != nil {
 		log.ErrLog("", fmt.Errorf("获取redis conn失败 keys(%v),error(%v)", keys, err))
 		return
 	}
 	defer conn.Close()
 	for _, key := range keys {
-		regexpDelKey(conn, key)
+		regexpDelKey(conn, c.key(key))
 	}
 }
 
 func (c *Cache) GetRawMessage(ctx context.Context, key string) (json.RawMessage, error) {
-	conn, err := c.pool.GetContext(ctx)
+	if data, ok := c.hotGet(key); ok {
+		return data, nil
+	}
+	conn, err := c.getConn(ctx)
 	if err != nil {
 		log.ErrLog("", fmt.Errorf("获取redis conn失败 key(%s),error(%v)", key, err))
 		return nil, err
 	}
 	defer conn.Close()
-	return getKeyBytes(conn, key, c.conf.ExpireTime)
+	data, err := getKeyBytes(conn, c.key(key), c.conf.ExpireTime)
+	if err != nil {
+		return data, err
+	}
+	if data, err = c.decodeValue(data); err != nil {
+		log.ErrLog("", fmt.Errorf("GetRawMessage key(%s) %v", key, err))
+		return data, err
+	}
+	c.hotSet(key, data)
+	return data, nil
 }
 
-func (c *Cache) SetRawMessage(ctx context.Context, key string, data json.RawMessage) error {
-	conn, err := c.pool.GetContext(ctx)
+func (c *Cache) SetRawMessage(ctx context.Context, key string, data json.RawMessage, tags ...string) error {
+	defer c.hotDel(key)
+	conn, err := c.getConn(ctx)
 	if err != nil {
 		log.ErrLog("", fmt.Errorf("获取redis conn失败 key(%s),error(%v)", key, err))
 		return err
 	}
 	defer conn.Close()
-	return setKeyBytes(conn, key, data, c.conf.ExpireTime)
+	if data, err = c.encodeValue(data); err != nil {
+		log.ErrLog("", fmt.Errorf("SetRawMessage key(%s) %v", key, err))
+		return err
+	}
+	return setKeyBytes(conn, c.key(key), data, c.conf.ExpireTime, c.tagKeys(tags)...)
 }
 
 //使用string 整体存储对象
 func (c *Cache) GetObject(ctx context.Context, key string, obj interface{}) error {
-	conn, err := c.pool.GetContext(ctx)
+	if data, ok := c.hotGet(key); ok {
+		return json.Unmarshal(data, &obj)
+	}
+	conn, err := c.getConn(ctx)
 	if err != nil {
 		log.ErrLog("", fmt.Errorf("获取redis conn失败 key(%s),error(%v)", key, err))
 		return err
 	}
 	defer conn.Close()
-	data, err := getKeyBytes(conn, key, c.conf.ExpireTime)
+	data, err := getKeyBytes(conn, c.key(key), c.conf.ExpireTime)
 	if err != nil {
 		return err
 	}
+	if data, err = c.decodeValue(data); err != nil {
+		log.ErrLog("", fmt.Errorf("GetObject key(%s) %v", key, err))
+		return err
+	}
+	c.hotSet(key, data)
 	return json.Unmarshal(data, &obj)
 }
 
-func (c *Cache) SetObject(ctx context.Context, key string, obj interface{}) error {
-	conn, err := c.pool.GetContext(ctx)
+func (c *Cache) SetObject(ctx context.Context, key string, obj interface{}, tags ...string) error {
+	defer c.hotDel(key)
+	conn, err := c.getConn(ctx)
 	if err != nil {
 		log.ErrLog("", fmt.Errorf("获取redis conn失败 key(%s),error(%v)", key, err))
 		return err
@@ -99,26 +129,34 @@ func (c *Cache) SetObject(ctx context.Context, key string, obj interface{}) erro
 		log.ErrLog("", fmt.Errorf("mashal obj fail, error(%v)", err))
 		return err
 	}
-	return setKeyBytes(conn, key, data, c.conf.ExpireTime)
+	if data, err = c.encodeValue(data); err != nil {
+		log.ErrLog("", fmt.Errorf("SetObject key(%s) %v", key, err))
+		return err
+	}
+	return setKeyBytes(conn, c.key(key), data, c.conf.ExpireTime, c.tagKeys(tags)...)
 }
 
 //使用hash 分字段存储对象
 func (c *Cache) GetHashObject(ctx context.Context, key string, fields []string, obj interface{}) error {
-	conn, err := c.pool.GetContext(ctx)
+	conn, err := c.getConn(ctx)
 	if err != nil {
 		log.ErrLog("", fmt.Errorf("获取redis conn失败 key(%s),error(%v)", key, err))
 		return err
 	}
 	defer conn.Close()
-	data, err := getKeyHash(conn, key, fields, c.conf.ExpireTime)
+	data, err := getKeyHash(conn, c.key(key), fields, c.conf.ExpireTime)
 	if err != nil {
 		return err
 	}
+	if data, err = c.decryptHash(data); err != nil {
+		log.ErrLog("", fmt.Errorf("GetHashObject key(%s) %v", key, err))
+		return err
+	}
 	return unmarshalRedisObj(data, reflect.ValueOf(obj))
 }
 
-func (c *Cache) SetHashObject(ctx context.Context, key string, fields []string, obj interface{}) error {
-	conn, err := c.pool.GetContext(ctx)
+func (c *Cache) SetHashObject(ctx context.Context, key string, fields []string, obj interface{}, tags ...string) error {
+	conn, err := c.getConn(ctx)
 	if err != nil {
 		log.ErrLog("", fmt.Errorf("获取redis conn失败 key(%s),error(%v)", key, err))
 		return err
@@ -129,97 +167,128 @@ func (c *Cache) SetHashObject(ctx context.Context, key string, fields []string,
 		log.ErrLog("", fmt.Errorf("mashal obj fail, error(%v)", err))
 		return err
 	}
-	return setKeyHash(conn, key, fields, data, c.conf.ExpireTime)
+	if data, err = c.encryptHash(data); err != nil {
+		log.ErrLog("", fmt.Errorf("SetHashObject key(%s) %v", key, err))
+		return err
+	}
+	return setKeyHash(conn, c.key(key), fields, data, c.conf.ExpireTime, c.tagKeys(tags)...)
+}
+
+// 只更新prev到cur之间发生变化的字段,hash未缓存时不做处理,返回是否已更新
+func (c *Cache) UpdateHashObject(ctx context.Context, key string, prev, cur interface{}) (bool, error) {
+	changed, removed, err := diffHash(reflect.ValueOf(prev), reflect.ValueOf(cur))
+	if err != nil {
+		log.ErrLog("", fmt.Errorf("mashal obj fail, error(%v)", err))
+		return false, err
+	}
+	if len(changed) == 0 && len(removed) == 0 {
+		return false, nil
+	}
+	if changed, err = c.encryptHash(changed); err != nil {
+		log.ErrLog("", fmt.Errorf("UpdateHashObject key(%s) %v", key, err))
+		return false, err
+	}
+	conn, err := c.getConn(ctx)
+	if err != nil {
+		log.ErrLog("", fmt.Errorf("获取redis conn失败 key(%s),error(%v)", key, err))
+		return false, err
+	}
+	defer conn.Close()
+	return updateKeyHash(conn, c.key(key), changed, removed, c.conf.ExpireTime)
 }
 
 //使用zset存储id列表
 func (c *Cache) GetIdSet(ctx context.Context, key string) ([]int64, error) {
-	conn, err := c.pool.GetContext(ctx)
+	conn, err := c.getConn(ctx)
 	if err != nil {
 		log.ErrLog("", fmt.Errorf("获取redis conn失败 key(%s),error(%v)", key, err))
 		return nil, err
 	}
 	defer conn.Close()
-	return getIdSet(conn, key, c.conf.ExpireTime)
+	return getIdSet(conn, c.key(key), c.conf.ExpireTime)
 }
 
-func (c *Cache) SetIdSet(ctx context.Context, key string, list []int64) error {
-	conn, err := c.pool.GetContext(ctx)
+func (c *Cache) SetIdSet(ctx context.Context, key string, list []int64, tags ...string) error {
+	conn, err := c.getConn(ctx)
 	if err != nil {
 		log.ErrLog("", fmt.Errorf("获取redis conn失败 key(%s),error(%v)", key, err))
 		return err
 	}
 	defer conn.Close()
-	return setIdSet(conn, key, list, c.conf.ExpireTime)
+	return setIdSet(conn, c.key(key), list, c.conf.ExpireTime, c.tagKeys(tags)...)
 }
 
 //使用Hash存储Name,value List列表
 func (c *Cache) GetNameList(ctx context.Context, listKey string) ([]KeyValue, error) {
-	conn, err := c.pool.GetContext(ctx)
+	conn, err := c.getConn(ctx)
 	if err != nil {
 		log.ErrLog("", fmt.Errorf("获取redis conn失败 key(%s),error(%v)", listKey, err))
 		return nil, err
 	}
 	defer conn.Close()
-	return getNameList(conn, listKey, c.conf.ExpireTime)
+	return getNameList(conn, c.key(listKey), c.conf.ExpireTime)
 }
 
-func (c *Cache) SetNameList(ctx context.Context, listKey string, list []KeyValue) error {
-	conn, err := c.pool.GetContext(ctx)
+func (c *Cache) SetNameList(ctx context.Context, listKey string, list []KeyValue, tags ...string) error {
+	conn, err := c.getConn(ctx)
 	if err != nil {
 		log.ErrLog("", fmt.Errorf("获取redis conn失败 key(%s),error(%v)", listKey, err))
 		return err
 	}
 	defer conn.Close()
-	return setNameList(conn, listKey, list, c.conf.ExpireTime)
+	return setNameList(conn, c.key(listKey), list, c.conf.ExpireTime, c.tagKeys(tags)...)
 }
 
 func (c *Cache) SetKeyInt64List(ctx context.Context, listKey string, list int64) error {
-	conn, err := c.pool.GetContext(ctx)
+	conn, err := c.getConn(ctx)
 	if err != nil {
 		log.ErrLog("", fmt.Errorf("获取redis conn失败 key(%s),error(%v)", listKey, err))
 		return err
 	}
 	defer conn.Close()
-	return setKeyInt64(conn, listKey, list, c.conf.ExpireTime)
+	return setKeyInt64(conn, c.key(listKey), list, c.conf.ExpireTime)
 }
 
 func (c *Cache) GetKeyInt64List(ctx context.Context, key string) (int64, error) {
-	conn, err := c.pool.GetContext(ctx)
+	conn, err := c.getConn(ctx)
 	if err != nil {
 		log.ErrLog("", fmt.Errorf("获取redis conn失败 key(%s),error(%v)", key, err))
 		return 0, err
 	}
 	defer conn.Close()
-	return getKeyInt64(conn, key, c.conf.ExpireTime)
+	return getKeyInt64(conn, c.key(key), c.conf.ExpireTime)
 }
 
 //在线人数push
+//
+// Deprecated: 使用 AddSample 按条数或时间保留数据点
 func (c *Cache) RPushOnlineCount(ctx context.Context, key string, count int64) (err error) {
-	conn, err := c.pool.GetContext(ctx)
+	conn, err := c.getConn(ctx)
 	if err != nil {
 		log.ErrLog("", fmt.Errorf("获取redis conn失败 key(%s),error(%v)", key, err))
 		return err
 	}
 	defer conn.Close()
 
-	return rpushOnlineCount(conn, key, count)
+	return rpushOnlineCount(conn, c.key(key), count)
 }
 
 //在线人数数量
+//
+// Deprecated: 使用 LatestSamples/RangeSamples 查询数据点
 func (c *Cache) GetLenOnlineCount(ctx context.Context, key string) (data []int64, err error) {
-	conn, err := c.pool.GetContext(ctx)
+	conn, err := c.getConn(ctx)
 	if err != nil {
 		log.ErrLog("", fmt.Errorf("获取redis conn失败 key(%s),error(%v)", key, err))
 		return data, err
 	}
 	defer conn.Close()
-	return getLenOnlineCount(conn, key)
+	return getLenOnlineCount(conn, c.key(key))
 }
 
 //获取当前时间的数量
 //func (c *Cache) GetCurrentOnlineCount(ctx context.Context,key string )(data []string, err error){
-//	conn, err := c.pool.GetContext(ctx)
+//	conn, err := c.getConn(ctx)
 //	if err != nil {
 //		log.ErrLog("", fmt.Errorf("获取redis conn失败 key(%s),error(%v)", key, err))
 //		return data,err
@@ -230,30 +299,30 @@ func (c *Cache) GetLenOnlineCount(ctx context.Context, key string) (data []int64
 
 //list的rpush
 func (c *Cache) RPushList(ctx context.Context, key string, id int64) (err error) {
-	conn, err := c.pool.GetContext(ctx)
+	conn, err := c.getConn(ctx)
 	if err != nil {
 		log.ErrLog("", fmt.Errorf("获取redis conn失败 key(%s),error(%v)", key, err))
 		return err
 	}
 	defer conn.Close()
 
-	return rpushList(conn, key, id)
+	return rpushList(conn, c.key(key), id)
 }
 
 //将当前用户的id加入到set中
 func (c *Cache) SetSetID(ctx context.Context, key string, id int64) (err error) {
-	conn, err := c.pool.GetContext(ctx)
+	conn, err := c.getConn(ctx)
 	if err != nil {
 		log.ErrLog("", fmt.Errorf("获取redis conn失败 key(%s),error(%v)", key, err))
 		return err
 	}
 	defer conn.Close()
 
-	return setSetID(conn, key, id)
+	return setSetID(conn, c.key(key), id)
 }
 
 func (c *Cache) Ping(ctx context.Context) error {
-	conn, err := c.pool.GetContext(ctx)
+	conn, err := c.getConn(ctx)
 	if err != nil {
 		log.ErrLog("", fmt.Errorf("获取redis conn失败 error(%v)", err))
 		return err
@@ -264,101 +333,146 @@ func (c *Cache) Ping(ctx context.Context) error {
 
 //获取当前时间的在线数量
 func (c *Cache) GetSetCount(ctx context.Context, key string) (count int64, err error) {
-	conn, err := c.pool.GetContext(ctx)
+	conn, err := c.getConn(ctx)
 	if err != nil {
 		log.ErrLog("", fmt.Errorf("获取redis conn失败 key(%s),error(%v)", key, err))
 		return 0, err
 	}
 	defer conn.Close()
-	return getSetCount(conn, key)
+	return getSetCount(conn, c.key(key))
 }
 
 func (c *Cache) GetRegexpKeys(ctx context.Context, key string) ([]string, error) {
-	conn, err := c.pool.GetContext(ctx)
+	conn, err := c.getConn(ctx)
 	if err != nil {
 		log.ErrLog("", fmt.Errorf("获取redis conn失败 key(%s),error(%v)", key, err))
 		return nil, err
 	}
 	defer conn.Close()
-	return getRegexpKey(conn, key)
+	keys, err := getRegexpKey(conn, c.key(key))
+	return c.trimKeys(keys), err
 }
 
 func (c *Cache) SetExpireTimeKey(ctx context.Context, key string, value string, expireTime int) error {
-	conn, err := c.pool.GetContext(ctx)
+	conn, err := c.getConn(ctx)
 	if err != nil {
 		log.ErrLog("", fmt.Errorf("获取redis conn失败 key(%s),error(%v)", key, err))
 		return err
 	}
 	defer conn.Close()
-	return setKeyString(conn, key, value, expireTime)
+	return setKeyString(conn, c.key(key), value, expireTime)
 }
 
 func (c *Cache) GetExpireTimeKey(ctx context.Context, key string, expireTime int) (string, error) {
-	conn, err := c.pool.GetContext(ctx)
+	conn, err := c.getConn(ctx)
 	if err != nil {
 		log.ErrLog("", fmt.Errorf("获取redis conn失败 key(%s),error(%v)", key, err))
 		return "", err
 	}
 	defer conn.Close()
-	return getKeyString(conn, key, expireTime)
+	return getKeyString(conn, c.key(key), expireTime)
 }
 
 func (c *Cache) HReset(ctx context.Context, key string, field string) error {
-	conn, err := c.pool.GetContext(ctx)
+	conn, err := c.getConn(ctx)
 	if err != nil {
 		log.ErrLog("", fmt.Errorf("获取redis conn失败 key(%s),error(%v)", key, err))
 		return err
 	}
 	defer conn.Close()
-	return hReset(conn, key, field, c.conf.ExpireTime)
+	return hReset(conn, c.key(key), field, c.conf.ExpireTime)
 }
 
 func (c *Cache) HIncrBy(ctx context.Context, key string, field string, num int64) error {
-	conn, err := c.pool.GetContext(ctx)
+	conn, err := c.getConn(ctx)
 	if err != nil {
 		log.ErrLog("", fmt.Errorf("获取redis conn失败 key(%s),error(%v)", key, err))
 		return err
 	}
 	defer conn.Close()
-	return hIncrBy(conn, key, field, num, c.conf.ExpireTime)
+	return hIncrBy(conn, c.key(key), field, num, c.conf.ExpireTime)
 }
 
 func (c *Cache) HGetNum(ctx context.Context, key string, field string) (int64, error) {
-	conn, err := c.pool.GetContext(ctx)
+	conn, err := c.getConn(ctx)
 	if err != nil {
 		log.ErrLog("", fmt.Errorf("获取redis conn失败 key(%s),error(%v)", key, err))
 		return 0, err
 	}
 	defer conn.Close()
-	return hGetNum(conn, key, field, c.conf.ExpireTime)
+	return hGetNum(conn, c.key(key), field, c.conf.ExpireTime)
 }
 
 func (c *Cache) GetInt64(ctx context.Context, key string) (int64, error) {
-	conn, err := c.pool.GetContext(ctx)
+	conn, err := c.getConn(ctx)
 	if err != nil {
 		log.ErrLog("", fmt.Errorf("获取redis conn失败 key(%s),error(%v)", key, err))
 		return 0, err
 	}
 	defer conn.Close()
-	return getKeyInt64(conn, key, c.conf.ExpireTime)
+	return getKeyInt64(conn, c.key(key), c.conf.ExpireTime)
 }
 
-func (c *Cache) SetInt64(ctx context.Context, key string, data int64) error {
-	conn, err := c.pool.GetContext(ctx)
+func (c *Cache) SetInt64(ctx context.Context, key string, data int64, tags ...string) error {
+	conn, err := c.getConn(ctx)
 	if err != nil {
 		log.ErrLog("", fmt.Errorf("获取redis conn失败 key(%s),error(%v)", key, err))
 		return err
 	}
 	defer conn.Close()
-	return setKeyInt64(conn, key, data, c.conf.ExpireTime)
+	return setKeyInt64(conn, c.key(key), data, c.conf.ExpireTime, c.tagKeys(tags)...)
 }
 
 func (c *Cache) HDel(ctx context.Context, key string, fields ...string) error {
-	conn, err := c.pool.GetContext(ctx)
+	conn, err := c.getConn(ctx)
+	if err != nil {
+		log.ErrLog("", fmt.Errorf("获取redis conn失败 key(%s),error(%v)", key, err))
+		return err
+	}
+	defer conn.Close()
+	return hDel(conn, c.key(key), fields)
+}
+
+// 计数器自增并返回自增后的值,不设置过期时间,key只加命名空间前缀,不随命名空间版本失效
+func (c *Cache) IncrBy(ctx context.Context, key string, num int64) (int64, error) {
+	conn, err := c.getConn(ctx)
+	if err != nil {
+		log.ErrLog("", fmt.Errorf("获取redis conn失败 key(%s),error(%v)", key, err))
+		return 0, err
+	}
+	defer conn.Close()
+	return incrBy(conn, c.nsKey(key), num)
+}
+
+// 读取不过期的hash,用于配置、开关等持久数据,key只加命名空间前缀,不随命名空间版本失效
+func (c *Cache) GetPersistentHash(ctx context.Context, key string) (map[string]string, error) {
+	conn, err := c.getConn(ctx)
+	if err != nil {
+		log.ErrLog("", fmt.Errorf("获取redis conn失败 key(%s),error(%v)", key, err))
+		return nil, err
+	}
+	defer conn.Close()
+	return hGetAll(conn, c.nsKey(key))
+}
+
+// 写入不过期hash的字段
+func (c *Cache) SetPersistentHash(ctx context.Context, key string, field string, value string) error {
+	conn, err := c.getConn(ctx)
+	if err != nil {
+		log.ErrLog("", fmt.Errorf("获取redis conn失败 key(%s),error(%v)", key, err))
+		return err
+	}
+	defer conn.Close()
+	return hSet(conn, c.nsKey(key), field, value)
+}
+
+// 删除不过期hash的字段
+func (c *Cache) DelPersistentHash(ctx context.Context, key string, fields ...string) error {
+	conn, err := c.getConn(ctx)
 	if err != nil {
 		log.ErrLog("", fmt.Errorf("获取redis conn失败 key(%s),error(%v)", key, err))
 		return err
 	}
 	defer conn.Close()
-	return hDel(conn, key, fields)
+	return hDel(conn, c.nsKey(key), fields)
 }
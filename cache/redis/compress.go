@@ -0,0 +1,124 @@
+package redis
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"sync"
+
+	"github.com/klauspost/compress/gzip"
+	"github.com/klauspost/compress/s2"
+	"github.com/klauspost/compress/zstd"
+)
+
+// 压缩算法
+const (
+	CompressGzip   = "gzip"
+	CompressZstd   = "zstd"
+	CompressSnappy = "snappy"
+)
+
+// 值头部标记,json和普通文本不会以0x00开头,没有标记的旧数据按原样返回
+const (
+	valueMagic      byte = 0x00
+	valueCompressed byte = 'z'
+)
+
+var compressAlgos = map[string]byte{
+	CompressGzip:   1,
+	CompressZstd:   2,
+	CompressSnappy: 3,
+}
+
+var (
+	zstdOnce    sync.Once
+	zstdEncoder *zstd.Encoder
+	zstdDecoder *zstd.Decoder
+)
+
+func initZstd() {
+	zstdOnce.Do(func() {
+		zstdEncoder, _ = zstd.NewWriter(nil)
+		zstdDecoder, _ = zstd.NewReader(nil)
+	})
+}
+
+func compress(algo byte, data []byte) ([]byte, error) {
+	header := []byte{valueMagic, valueCompressed, algo}
+	switch algo {
+	case 1:
+		var buf bytes.Buffer
+		buf.Write(header)
+		w := gzip.NewWriter(&buf)
+		if _, err := w.Write(data); err != nil {
+			return nil, err
+		}
+		if err := w.Close(); err != nil {
+			return nil, err
+		}
+		return buf.Bytes(), nil
+	case 2:
+		initZstd()
+		return zstdEncoder.EncodeAll(data, header), nil
+	case 3:
+		return append(header, s2.EncodeSnappy(nil, data)...), nil
+	}
+	return nil, fmt.Errorf("unsupported compress algo(%d)", algo)
+}
+
+func decompress(algo byte, data []byte) ([]byte, error) {
+	switch algo {
+	case 1:
+		r, err := gzip.NewReader(bytes.NewReader(data))
+		if err != nil {
+			return nil, err
+		}
+		defer r.Close()
+		return ioutil.ReadAll(r)
+	case 2:
+		initZstd()
+		return zstdDecoder.DecodeAll(data, nil)
+	case 3:
+		return s2.Decode(nil, data)
+	}
+	return nil, fmt.Errorf("unsupported compress algo(%d)", algo)
+}
+
+func isCompressed(data []byte) bool {
+	return len(data) >= 3 && data[0] == valueMagic && data[1] == valueCompressed
+}
+
+// 超过阈值的值按配置的算法压缩,压缩后没有变小时保存原值
+func (c *Cache) compressValue(data []byte) ([]byte, error) {
+	if c.conf.CompressThreshold <= 0 || len(data) < c.conf.CompressThreshold {
+		return data, nil
+	}
+	typ := c.conf.CompressType
+	if typ == "" {
+		typ = CompressGzip
+	}
+	algo, ok := compressAlgos[typ]
+	if !ok {
+		return nil, fmt.Errorf("unsupported compress type(%s)", typ)
+	}
+	out, err := compress(algo, data)
+	if err != nil {
+		return nil, fmt.Errorf("compress %s error(%v)", typ, err)
+	}
+	if len(out) >= len(data) {
+		return data, nil
+	}
+	return out, nil
+}
+
+// 根据头部标记解压,未压缩的值原样返回
+func (c *Cache) decompressValue(data []byte) ([]byte, error) {
+	if !isCompressed(data) {
+		return data, nil
+	}
+	out, err := decompress(data[2], data[3:])
+	if err != nil {
+		return nil, fmt.Errorf("decompress error(%v)", err)
+	}
+	return out, nil
+}
@@ -0,0 +1,41 @@
+package redis
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestCompressValue(t *testing.T) {
+	data := bytes.Repeat([]byte(`{"name":"lareina","score":100},`), 100)
+	for _, typ := range []string{CompressGzip, CompressZstd, CompressSnappy} {
+		c := New(&Config{CompressThreshold: 1024, CompressType: typ})
+		out, err := c.compressValue(data)
+		if err != nil {
+			t.Fatalf("%s compress error(%v)", typ, err)
+		}
+		if !isCompressed(out) || len(out) >= len(data) {
+			t.Fatalf("%s value not compressed, len %d", typ, len(out))
+		}
+		in, err := c.decompressValue(out)
+		if err != nil {
+			t.Fatalf("%s decompress error(%v)", typ, err)
+		}
+		if !bytes.Equal(in, data) {
+			t.Errorf("%s round trip mismatch", typ)
+		}
+	}
+}
+
+func TestCompressValueBelowThreshold(t *testing.T) {
+	c := New(&Config{CompressThreshold: 1024})
+	data := []byte(`{"id":1}`)
+	out, err := c.compressValue(data)
+	if err != nil || !bytes.Equal(out, data) {
+		t.Errorf("compressValue small = %q, %v", out, err)
+	}
+	// 未压缩的旧数据原样返回
+	out, err = c.decompressValue(data)
+	if err != nil || !bytes.Equal(out, data) {
+		t.Errorf("decompressValue plain = %q, %v", out, err)
+	}
+}
@@ -0,0 +1,106 @@
+package redis
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/thesky9531/lareina/log"
+)
+
+const (
+	defaultDoubleDeleteDelay = 1000 // 毫秒
+	invalidateRetries        = 3
+	invalidateBackoff        = 100 * time.Millisecond
+)
+
+// 删除缓存key,出错时返回错误
+func (c *Cache) invalidate(ctx context.Context, keys []string) error {
+	if len(keys) == 0 {
+		return nil
+	}
+	defer c.hotDel(keys...)
+	full := make([]string, 0, len(keys))
+	for _, k := range keys {
+		full = append(full, c.key(k))
+	}
+	conn, err := c.getConn(ctx)
+	if err != nil {
+		log.ErrLog("", fmt.Errorf("获取redis conn失败 keys(%v),error(%v)", keys, err))
+		return err
+	}
+	defer conn.Close()
+	return delKeys(conn, full)
+}
+
+// 删除失败时按退避时间重试
+func (c *Cache) invalidateRetry(ctx context.Context, keys []string) (err error) {
+	backoff := invalidateBackoff
+	for i := 0; i < invalidateRetries; i++ {
+		if i > 0 {
+			select {
+			case <-ctx.Done():
+				return ctx.Err()
+			case <-c.closed:
+				return err
+			case <-time.After(backoff):
+			}
+			backoff *= 2
+		}
+		if err = c.invalidate(ctx, keys); err == nil {
+			return nil
+		}
+	}
+	log.ErrLog(fmt.Sprintf("invalidate keys(%v) failed after %d retries", keys, invalidateRetries), err)
+	return err
+}
+
+// 延迟后在后台再删除一次,清除并发读请求在写db期间回填的旧数据
+func (c *Cache) scheduleDelete(keys []string) {
+	if len(keys) == 0 {
+		return
+	}
+	delay := time.Duration(c.conf.DoubleDeleteDelay) * time.Millisecond
+	if delay <= 0 {
+		delay = defaultDoubleDeleteDelay * time.Millisecond
+	}
+	go func() {
+		select {
+		case <-c.closed:
+			return
+		case <-time.After(delay):
+		}
+		c.invalidateRetry(context.Background(), keys)
+	}()
+}
+
+// 延迟双删,写db后调用:立即删除缓存,并在 Config.DoubleDeleteDelay 后再删除一次,
+// 第二次删除在后台执行,失败时重试。返回第一次删除的结果
+func (c *Cache) DoubleDelete(ctx context.Context, keys ...string) error {
+	err := c.invalidate(ctx, keys)
+	c.scheduleDelete(keys)
+	return err
+}
+
+// 在sqlx事务中执行fn,提交成功后才删除keys对应的缓存(失败时重试)并延迟双删。
+// fn出错或提交失败时回滚且不删除缓存。db已提交后缓存删除失败只记录日志,不返回错误
+func (c *Cache) WriteThenInvalidate(ctx context.Context, db *sqlx.DB, keys []string, fn func(tx *sqlx.Tx) error) error {
+	tx, err := db.BeginTxx(ctx, nil)
+	if err != nil {
+		log.ErrLog("", fmt.Errorf("WriteThenInvalidate BeginTxx error(%v)", err))
+		return err
+	}
+	// 提交后Rollback不做任何操作,fn panic时也能回滚
+	defer tx.Rollback()
+	if err = fn(tx); err != nil {
+		return err
+	}
+	if err = tx.Commit(); err != nil {
+		log.ErrLog("", fmt.Errorf("WriteThenInvalidate Commit error(%v)", err))
+		return err
+	}
+	c.invalidateRetry(context.Background(), keys)
+	c.scheduleDelete(keys)
+	return nil
+}
@@ -0,0 +1,140 @@
+package redis
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/jmoiron/sqlx"
+)
+
+func TestDoubleDelete(t *testing.T) {
+	c, mr := newTestCache(t, &Config{DoubleDeleteDelay: 20})
+	ctx := context.Background()
+	mr.Set(c.key("user_1"), "old")
+	if err := c.DoubleDelete(ctx, "user_1"); err != nil {
+		t.Fatal(err)
+	}
+	if mr.Exists(c.key("user_1")) {
+		t.Fatal("not deleted immediately")
+	}
+	// 模拟并发读在写db期间回填旧数据
+	mr.Set(c.key("user_1"), "old")
+	time.Sleep(100 * time.Millisecond)
+	if mr.Exists(c.key("user_1")) {
+		t.Fatal("not deleted after delay")
+	}
+}
+
+func TestScheduleDeleteClosed(t *testing.T) {
+	c, mr := newTestCache(t, &Config{DoubleDeleteDelay: 20})
+	mr.Set(c.key("user_1"), "v")
+	c.scheduleDelete([]string{"user_1"})
+	c.Close()
+	time.Sleep(50 * time.Millisecond)
+	if !mr.Exists(c.key("user_1")) {
+		t.Fatal("deleted after Close")
+	}
+}
+
+func TestInvalidateRetry(t *testing.T) {
+	c, mr := newTestCache(t, nil)
+	ctx := context.Background()
+	mr.Set(c.key("a"), "1")
+	mr.Set(c.key("b"), "1")
+	if err := c.invalidateRetry(ctx, []string{"a", "b"}); err != nil {
+		t.Fatal(err)
+	}
+	if mr.Exists(c.key("a")) || mr.Exists(c.key("b")) {
+		t.Fatal("keys not deleted")
+	}
+
+	// redis不可用时重试后返回错误
+	mr.Close()
+	start := time.Now()
+	if err := c.invalidateRetry(ctx, []string{"a"}); err == nil {
+		t.Fatal("invalidateRetry succeeded with redis down")
+	}
+	if d := time.Since(start); d < invalidateBackoff*3 {
+		t.Fatalf("returned after %v, want retries with backoff", d)
+	}
+	// ctx结束时停止重试
+	cctx, cancel := context.WithCancel(ctx)
+	cancel()
+	if err := c.invalidateRetry(cctx, []string{"a"}); err == nil {
+		t.Fatal("invalidateRetry succeeded with canceled ctx")
+	}
+}
+
+func newTestDB(t *testing.T) (*sqlx.DB, sqlmock.Sqlmock) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+	return sqlx.NewDb(db, "sqlmock"), mock
+}
+
+func TestWriteThenInvalidate(t *testing.T) {
+	c, mr := newTestCache(t, &Config{DoubleDeleteDelay: 20})
+	ctx := context.Background()
+	dbErr := errors.New("db error")
+	cases := []struct {
+		name    string
+		expect  func(mock sqlmock.Sqlmock)
+		fn      func(tx *sqlx.Tx) error
+		wantErr bool
+		deleted bool
+	}{
+		{"commit", func(mock sqlmock.Sqlmock) {
+			mock.ExpectBegin()
+			mock.ExpectExec("UPDATE user").WillReturnResult(sqlmock.NewResult(0, 1))
+			mock.ExpectCommit()
+		}, func(tx *sqlx.Tx) error {
+			// 提交前不删除缓存
+			if !mr.Exists(c.key("user_1")) {
+				t.Error("invalidated before commit")
+			}
+			_, err := tx.Exec("UPDATE user SET name = ?", "a")
+			return err
+		}, false, true},
+		{"fn error", func(mock sqlmock.Sqlmock) {
+			mock.ExpectBegin()
+			mock.ExpectRollback()
+		}, func(tx *sqlx.Tx) error { return dbErr }, true, false},
+		{"commit error", func(mock sqlmock.Sqlmock) {
+			mock.ExpectBegin()
+			mock.ExpectCommit().WillReturnError(dbErr)
+		}, func(tx *sqlx.Tx) error { return nil }, true, false},
+		{"panic", func(mock sqlmock.Sqlmock) {
+			mock.ExpectBegin()
+			mock.ExpectRollback()
+		}, func(tx *sqlx.Tx) error { panic("boom") }, false, false},
+	}
+	for _, tc := range cases {
+		db, mock := newTestDB(t)
+		tc.expect(mock)
+		mr.Set(c.key("user_1"), "old")
+		err := func() (err error) {
+			defer func() {
+				if r := recover(); r != nil && tc.name != "panic" {
+					t.Errorf("%s: panic %v", tc.name, r)
+				}
+			}()
+			return c.WriteThenInvalidate(ctx, db, []string{"user_1"}, tc.fn)
+		}()
+		if (err != nil) != tc.wantErr {
+			t.Errorf("%s: err = %v, wantErr %v", tc.name, err, tc.wantErr)
+		}
+		if deleted := !mr.Exists(c.key("user_1")); deleted != tc.deleted {
+			t.Errorf("%s: deleted = %v, want %v", tc.name, deleted, tc.deleted)
+		}
+		if err = mock.ExpectationsWereMet(); err != nil {
+			t.Errorf("%s: %v", tc.name, err)
+		}
+		// 等待延迟双删完成,避免影响下一个用例
+		time.Sleep(50 * time.Millisecond)
+	}
+}
@@ -0,0 +1,156 @@
+package redis
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+)
+
+// 加密值的头部标记: 0x00 'e' 密钥id长度 密钥id nonce 密文
+const valueEncrypted byte = 'e'
+
+// 加密密钥,写入使用当前密钥,读取时按值头部的密钥id选择,支持密钥轮换
+type keyring struct {
+	mu      sync.RWMutex
+	current string
+	aeads   map[string]cipher.AEAD
+}
+
+// EnableEncryption 开启AES-GCM加密,keys为密钥id到密钥(16/24/32字节)的映射,
+// currentID为写入使用的密钥,其他密钥只用于解密旧数据。可以重复调用以轮换密钥。
+func (c *Cache) EnableEncryption(currentID string, keys map[string][]byte) error {
+	if len(currentID) == 0 || len(currentID) > 255 {
+		return fmt.Errorf("EnableEncryption invalid key id(%s)", currentID)
+	}
+	if _, ok := keys[currentID]; !ok {
+		return fmt.Errorf("EnableEncryption current key(%s) not found", currentID)
+	}
+	aeads := make(map[string]cipher.AEAD, len(keys))
+	for id, key := range keys {
+		if len(id) == 0 || len(id) > 255 {
+			return fmt.Errorf("EnableEncryption invalid key id(%s)", id)
+		}
+		block, err := aes.NewCipher(key)
+		if err != nil {
+			return fmt.Errorf("EnableEncryption key(%s) error(%v)", id, err)
+		}
+		aead, err := cipher.NewGCM(block)
+		if err != nil {
+			return fmt.Errorf("EnableEncryption key(%s) error(%v)", id, err)
+		}
+		aeads[id] = aead
+	}
+	c.crypt.mu.Lock()
+	c.crypt.current = currentID
+	c.crypt.aeads = aeads
+	c.crypt.mu.Unlock()
+	return nil
+}
+
+func (c *Cache) encryptionEnabled() bool {
+	c.crypt.mu.RLock()
+	defer c.crypt.mu.RUnlock()
+	return c.crypt.current != ""
+}
+
+// 使用当前密钥加密,未开启加密时原样返回
+func (c *Cache) encryptValue(data []byte) ([]byte, error) {
+	c.crypt.mu.RLock()
+	id, aead := c.crypt.current, c.crypt.aeads[c.crypt.current]
+	c.crypt.mu.RUnlock()
+	if aead == nil {
+		return data, nil
+	}
+	out := make([]byte, 0, 3+len(id)+aead.NonceSize()+len(data)+aead.Overhead())
+	out = append(out, valueMagic, valueEncrypted, byte(len(id)))
+	out = append(out, id...)
+	nonce := out[len(out) : len(out)+aead.NonceSize()]
+	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+		return nil, fmt.Errorf("encrypt nonce error(%v)", err)
+	}
+	out = out[:len(out)+aead.NonceSize()]
+	return aead.Seal(out, nonce, data, nil), nil
+}
+
+func isEncrypted(data []byte) bool {
+	return len(data) >= 3 && data[0] == valueMagic && data[1] == valueEncrypted
+}
+
+// 按头部的密钥id解密,未加密的值原样返回
+func (c *Cache) decryptValue(data []byte) ([]byte, error) {
+	if !isEncrypted(data) {
+		return data, nil
+	}
+	n := int(data[2])
+	if len(data) < 3+n {
+		return nil, errors.New("decrypt value too short")
+	}
+	id := string(data[3 : 3+n])
+	c.crypt.mu.RLock()
+	aead := c.crypt.aeads[id]
+	c.crypt.mu.RUnlock()
+	if aead == nil {
+		return nil, fmt.Errorf("decrypt key(%s) not found", id)
+	}
+	data = data[3+n:]
+	if len(data) < aead.NonceSize() {
+		return nil, errors.New("decrypt value too short")
+	}
+	out, err := aead.Open(nil, data[:aead.NonceSize()], data[aead.NonceSize():], nil)
+	if err != nil {
+		return nil, fmt.Errorf("decrypt key(%s) error(%v)", id, err)
+	}
+	return out, nil
+}
+
+// 字符串值先压缩再加密
+func (c *Cache) encodeValue(data []byte) ([]byte, error) {
+	data, err := c.compressValue(data)
+	if err != nil {
+		return nil, err
+	}
+	return c.encryptValue(data)
+}
+
+func (c *Cache) decodeValue(data []byte) ([]byte, error) {
+	data, err := c.decryptValue(data)
+	if err != nil {
+		return nil, err
+	}
+	return c.decompressValue(data)
+}
+
+// 开启加密时逐个加密hash字段,加密后的字段不能再使用 HINCRBY
+func (c *Cache) encryptHash(data map[string][]byte) (map[string][]byte, error) {
+	if !c.encryptionEnabled() {
+		return data, nil
+	}
+	out := make(map[string][]byte, len(data))
+	for f, v := range data {
+		if len(v) == 0 {
+			out[f] = v
+			continue
+		}
+		e, err := c.encryptValue(v)
+		if err != nil {
+			return nil, err
+		}
+		out[f] = e
+	}
+	return out, nil
+}
+
+func (c *Cache) decryptHash(data map[string][]byte) (map[string][]byte, error) {
+	for f, v := range data {
+		d, err := c.decryptValue(v)
+		if err != nil {
+			return nil, fmt.Errorf("field %s %v", f, err)
+		}
+		data[f] = d
+	}
+	return data, nil
+}
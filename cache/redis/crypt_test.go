@@ -0,0 +1,67 @@
+package redis
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestEncodeValueRotation(t *testing.T) {
+	k1 := bytes.Repeat([]byte{1}, 32)
+	k2 := bytes.Repeat([]byte{2}, 16)
+	c := New(&Config{CompressThreshold: 16})
+	if err := c.EnableEncryption("k1", map[string][]byte{"k1": k1}); err != nil {
+		t.Fatal(err)
+	}
+	data := bytes.Repeat([]byte(`{"phone":"13800000000"}`), 10)
+	old, err := c.encodeValue(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !isEncrypted(old) || bytes.Contains(old, []byte("phone")) {
+		t.Fatalf("value not encrypted: %q", old)
+	}
+	// 轮换密钥后仍能读取旧密钥加密的数据
+	if err = c.EnableEncryption("k2", map[string][]byte{"k1": k1, "k2": k2}); err != nil {
+		t.Fatal(err)
+	}
+	cur, err := c.encodeValue(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(cur[3:5]) != "k2" {
+		t.Errorf("write key id = %q, want k2", cur[3:5])
+	}
+	for _, v := range [][]byte{old, cur} {
+		out, err := c.decodeValue(v)
+		if err != nil || !bytes.Equal(out, data) {
+			t.Errorf("decodeValue = %q, %v", out, err)
+		}
+	}
+	old[len(old)-1] ^= 0xff
+	if _, err = c.decodeValue(old); err == nil {
+		t.Error("decodeValue tampered value should fail")
+	}
+	if err = c.EnableEncryption("k3", map[string][]byte{"k1": k1}); err == nil {
+		t.Error("EnableEncryption without current key should fail")
+	}
+}
+
+func TestEncryptHash(t *testing.T) {
+	c := New(&Config{})
+	plain := map[string][]byte{"name": []byte("lareina"), "nick": nil}
+	out, err := c.encryptHash(plain)
+	if err != nil || string(out["name"]) != "lareina" {
+		t.Fatalf("encryptHash disabled = %v, %v", out, err)
+	}
+	if err = c.EnableEncryption("k1", map[string][]byte{"k1": bytes.Repeat([]byte{1}, 32)}); err != nil {
+		t.Fatal(err)
+	}
+	out, err = c.encryptHash(plain)
+	if err != nil || !isEncrypted(out["name"]) || len(out["nick"]) != 0 {
+		t.Fatalf("encryptHash = %v, %v", out, err)
+	}
+	out, err = c.decryptHash(out)
+	if err != nil || string(out["name"]) != "lareina" {
+		t.Errorf("decryptHash = %v, %v", out, err)
+	}
+}
@@ -0,0 +1,465 @@
+package redis
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/gomodule/redigo/redis"
+	"github.com/satori/go.uuid"
+	"github.com/thesky9531/lareina/log"
+)
+
+// 取出一个到期任务移入处理中集合,score为可见性超时时间,ARGV[3]为本次领取的token
+var claimJobScript = RegisterScript("claimJob", 5, `
+local ids = redis.call("ZRANGEBYSCORE", KEYS[1], "-inf", ARGV[1], "LIMIT", 0, 1)
+if #ids == 0 then
+	return false
+end
+local id = ids[1]
+redis.call("ZREM", KEYS[1], id)
+local data = redis.call("HGET", KEYS[3], id)
+if not data then
+	redis.call("HDEL", KEYS[4], id)
+	return false
+end
+redis.call("ZADD", KEYS[2], ARGV[2], id)
+redis.call("HSET", KEYS[5], id, ARGV[3])
+local attempts = redis.call("HINCRBY", KEYS[4], id, 1)
+return {data, attempts}`)
+
+// 处理超时的任务重新投递,原领取的token失效
+var requeueJobScript = RegisterScript("requeueJob", 3, `
+local ids = redis.call("ZRANGEBYSCORE", KEYS[1], "-inf", ARGV[1], "LIMIT", 0, ARGV[2])
+for _, id in ipairs(ids) do
+	redis.call("ZREM", KEYS[1], id)
+	redis.call("ZADD", KEYS[2], ARGV[1], id)
+	redis.call("HDEL", KEYS[3], id)
+end
+return #ids`)
+
+// 任务仍由本次领取持有(token一致且在处理中集合)时才移动到目标集合,避免超时重投后被旧的处理结果覆盖
+var moveJobScript = RegisterScript("moveJob", 3, `
+if redis.call("HGET", KEYS[3], ARGV[2]) ~= ARGV[3] or redis.call("ZREM", KEYS[1], ARGV[2]) == 0 then
+	return 0
+end
+redis.call("HDEL", KEYS[3], ARGV[2])
+redis.call("ZADD", KEYS[2], ARGV[1], ARGV[2])
+return 1`)
+
+// 任务仍由本次领取持有时确认完成并删除任务数据
+var ackJobScript = RegisterScript("ackJob", 4, `
+if redis.call("HGET", KEYS[4], ARGV[1]) ~= ARGV[2] or redis.call("ZREM", KEYS[1], ARGV[1]) == 0 then
+	return 0
+end
+redis.call("HDEL", KEYS[2], ARGV[1])
+redis.call("HDEL", KEYS[3], ARGV[1])
+redis.call("HDEL", KEYS[4], ARGV[1])
+return 1`)
+
+// 任务仍由本次领取持有时延长可见性超时时间
+var extendJobScript = RegisterScript("extendJob", 2, `
+if redis.call("HGET", KEYS[2], ARGV[2]) ~= ARGV[3] or not redis.call("ZSCORE", KEYS[1], ARGV[2]) then
+	return 0
+end
+redis.call("ZADD", KEYS[1], ARGV[1], ARGV[2])
+return 1`)
+
+// 死信任务重新投递,投递次数清零
+var retryDeadJobScript = RegisterScript("retryDeadJob", 3, `
+if redis.call("ZREM", KEYS[1], ARGV[2]) == 0 then
+	return 0
+end
+redis.call("HDEL", KEYS[3], ARGV[2])
+redis.call("ZADD", KEYS[2], ARGV[1], ARGV[2])
+return 1`)
+
+// 延迟任务
+type Job struct {
+	ID       string          `json:"id"`
+	Payload  json.RawMessage `json:"payload"`
+	RunAt    time.Time       `json:"run_at"`
+	Attempts int             `json:"-"` // 已投递次数,包含本次
+
+	// 本次领取的token,超时重投后旧的token不能再确认或失败该任务
+	token string
+	// 处理超时时取消handler的ctx,Extend成功后重置
+	timer *time.Timer
+}
+
+type DelayQueueConfig struct {
+	Workers           int           // 并发处理数,默认1
+	PollInterval      time.Duration // 没有到期任务时的轮询间隔,默认1秒
+	VisibilityTimeout time.Duration // 任务处理超时时间,超时未确认会重新投递,默认30秒
+	MaxRetry          int           // 最大投递次数,超过后进入死信集合,默认3
+	Backoff           time.Duration // 重试退避基数,第n次重试延迟 Backoff*2^(n-1),默认1秒
+	MaxBackoff        time.Duration // 重试最大延迟,默认10分钟
+}
+
+// 基于zset的延迟队列
+type DelayQueue struct {
+	cache *Cache
+	name  string
+	conf  DelayQueueConfig
+}
+
+// NewDelayQueue 创建延迟队列,conf为nil时使用默认配置
+func (c *Cache) NewDelayQueue(name string, conf *DelayQueueConfig) *DelayQueue {
+	q := &DelayQueue{
+		cache: c,
+		name:  name,
+	}
+	if conf != nil {
+		q.conf = *conf
+	}
+	if q.conf.Workers <= 0 {
+		q.conf.Workers = 1
+	}
+	if q.conf.PollInterval <= 0 {
+		q.conf.PollInterval = time.Second
+	}
+	if q.conf.VisibilityTimeout <= 0 {
+		q.conf.VisibilityTimeout = 30 * time.Second
+	}
+	if q.conf.MaxRetry <= 0 {
+		q.conf.MaxRetry = 3
+	}
+	if q.conf.Backoff <= 0 {
+		q.conf.Backoff = time.Second
+	}
+	if q.conf.MaxBackoff <= 0 {
+		q.conf.MaxBackoff = 10 * time.Minute
+	}
+	return q
+}
+
+func (q *DelayQueue) readyKey() string {
+	return q.cache.nsKey(fmt.Sprintf("delayqueue_%s_ready", q.name))
+}
+
+func (q *DelayQueue) runningKey() string {
+	return q.cache.nsKey(fmt.Sprintf("delayqueue_%s_running", q.name))
+}
+
+func (q *DelayQueue) deadKey() string {
+	return q.cache.nsKey(fmt.Sprintf("delayqueue_%s_dead", q.name))
+}
+
+func (q *DelayQueue) jobsKey() string {
+	return q.cache.nsKey(fmt.Sprintf("delayqueue_%s_jobs", q.name))
+}
+
+func (q *DelayQueue) attemptsKey() string {
+	return q.cache.nsKey(fmt.Sprintf("delayqueue_%s_attempts", q.name))
+}
+
+func (q *DelayQueue) tokensKey() string {
+	return q.cache.nsKey(fmt.Sprintf("delayqueue_%s_tokens", q.name))
+}
+
+func unixMilli(t time.Time) int64 {
+	return t.UnixNano() / int64(time.Millisecond)
+}
+
+func (q *DelayQueue) backoff(attempts int) time.Duration {
+	d := q.conf.Backoff
+	for i := 1; i < attempts && d < q.conf.MaxBackoff; i++ {
+		d *= 2
+	}
+	if d > q.conf.MaxBackoff {
+		d = q.conf.MaxBackoff
+	}
+	return d
+}
+
+// 投递任务,在runAt之后执行
+func (q *DelayQueue) Enqueue(ctx context.Context, payload json.RawMessage, runAt time.Time) (string, error) {
+	conn, err := q.cache.getConn(ctx)
+	if err != nil {
+		log.ErrLog("", fmt.Errorf("获取redis conn失败 key(%s),error(%v)", q.name, err))
+		return "", err
+	}
+	defer conn.Close()
+	job := &Job{
+		ID:      uuid.NewV4().String(),
+		Payload: payload,
+		RunAt:   runAt,
+	}
+	data, err := json.Marshal(job)
+	if err != nil {
+		log.ErrLog("", fmt.Errorf("mashal job fail, error(%v)", err))
+		return "", err
+	}
+	err = conn.Send("MULTI")
+	if err == nil {
+		err = conn.Send("HSET", q.jobsKey(), job.ID, data)
+	}
+	if err == nil {
+		err = conn.Send("ZADD", q.readyKey(), unixMilli(runAt), job.ID)
+	}
+	if err == nil {
+		_, err = conn.Do("EXEC")
+	}
+	if err != nil {
+		err = fmt.Errorf("Enqueue conn.Do(EXEC, %s) error(%v)", q.name, err)
+		log.ErrLog("", err)
+		return "", err
+	}
+	return job.ID, nil
+}
+
+// 投递任务,在delay之后执行
+func (q *DelayQueue) EnqueueAfter(ctx context.Context, payload json.RawMessage, delay time.Duration) (string, error) {
+	return q.Enqueue(ctx, payload, time.Now().Add(delay))
+}
+
+// 取出一个到期任务,没有任务时返回nil
+func (q *DelayQueue) claim(ctx context.Context) (*Job, error) {
+	conn, err := q.cache.getConn(ctx)
+	if err != nil {
+		log.ErrLog("", fmt.Errorf("获取redis conn失败 key(%s),error(%v)", q.name, err))
+		return nil, err
+	}
+	defer conn.Close()
+	now := time.Now()
+	token := uuid.NewV4().String()
+	reply, err := redis.Values(claimJobScript.Do(conn, q.readyKey(), q.runningKey(), q.jobsKey(), q.attemptsKey(),
+		q.tokensKey(), unixMilli(now), unixMilli(now.Add(q.conf.VisibilityTimeout)), token))
+	if err != nil {
+		if err == redis.ErrNil {
+			return nil, nil
+		}
+		err = fmt.Errorf("claim claimJobScript.Do(%s) error(%v)", q.name, err)
+		log.ErrLog("", err)
+		return nil, err
+	}
+	var (
+		data     []byte
+		attempts int
+	)
+	if _, err = redis.Scan(reply, &data, &attempts); err != nil {
+		log.ErrLog("", err)
+		return nil, err
+	}
+	job := &Job{}
+	if err = json.Unmarshal(data, job); err != nil {
+		log.ErrLog("", fmt.Errorf("unmarshal job fail, error(%v)", err))
+		return nil, err
+	}
+	job.Attempts = attempts
+	job.token = token
+	return job, nil
+}
+
+// 重新投递处理超时的任务
+func (q *DelayQueue) requeue(ctx context.Context) (int64, error) {
+	conn, err := q.cache.getConn(ctx)
+	if err != nil {
+		log.ErrLog("", fmt.Errorf("获取redis conn失败 key(%s),error(%v)", q.name, err))
+		return 0, err
+	}
+	defer conn.Close()
+	n, err := redis.Int64(requeueJobScript.Do(conn, q.runningKey(), q.readyKey(), q.tokensKey(), unixMilli(time.Now()), 100))
+	if err != nil {
+		err = fmt.Errorf("requeue requeueJobScript.Do(%s) error(%v)", q.name, err)
+		log.ErrLog("", err)
+	}
+	return n, err
+}
+
+// 确认任务完成,任务已超时重投时不做处理
+func (q *DelayQueue) ack(ctx context.Context, job *Job) error {
+	conn, err := q.cache.getConn(ctx)
+	if err != nil {
+		log.ErrLog("", fmt.Errorf("获取redis conn失败 key(%s),error(%v)", q.name, err))
+		return err
+	}
+	defer conn.Close()
+	if _, err = ackJobScript.Do(conn, q.runningKey(), q.jobsKey(), q.attemptsKey(), q.tokensKey(), job.ID, job.token); err != nil {
+		err = fmt.Errorf("ack ackJobScript.Do(%s, %s) error(%v)", q.name, job.ID, err)
+		log.ErrLog("", err)
+	}
+	return err
+}
+
+// 任务失败,未超过重试次数时退避后重新投递,否则进入死信集合
+func (q *DelayQueue) fail(ctx context.Context, job *Job) error {
+	conn, err := q.cache.getConn(ctx)
+	if err != nil {
+		log.ErrLog("", fmt.Errorf("获取redis conn失败 key(%s),error(%v)", q.name, err))
+		return err
+	}
+	defer conn.Close()
+	now := time.Now()
+	target, score := q.readyKey(), unixMilli(now.Add(q.backoff(job.Attempts)))
+	if job.Attempts >= q.conf.MaxRetry {
+		target, score = q.deadKey(), unixMilli(now)
+	}
+	if _, err = moveJobScript.Do(conn, q.runningKey(), target, q.tokensKey(), score, job.ID, job.token); err != nil {
+		err = fmt.Errorf("fail moveJobScript.Do(%s, %s) error(%v)", q.name, job.ID, err)
+		log.ErrLog("", err)
+	}
+	return err
+}
+
+// 延长任务的处理超时时间,用于耗时较长的任务,handler的ctx同时延长到d之后结束。
+// 任务已超时重投时返回false
+func (q *DelayQueue) Extend(ctx context.Context, job *Job, d time.Duration) (bool, error) {
+	conn, err := q.cache.getConn(ctx)
+	if err != nil {
+		log.ErrLog("", fmt.Errorf("获取redis conn失败 key(%s),error(%v)", q.name, err))
+		return false, err
+	}
+	defer conn.Close()
+	ok, err := redis.Bool(extendJobScript.Do(conn, q.runningKey(), q.tokensKey(),
+		unixMilli(time.Now().Add(d)), job.ID, job.token))
+	if err != nil {
+		err = fmt.Errorf("Extend extendJobScript.Do(%s, %s) error(%v)", q.name, job.ID, err)
+		log.ErrLog("", err)
+	}
+	if ok && job.timer != nil {
+		job.timer.Reset(d)
+	}
+	return ok, err
+}
+
+func (q *DelayQueue) handle(job *Job, handler func(ctx context.Context, job *Job) error) {
+	// 超时重投导致投递次数超限的任务直接进入死信
+	if job.Attempts > q.conf.MaxRetry {
+		q.fail(context.Background(), job)
+		return
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	job.timer = time.AfterFunc(q.conf.VisibilityTimeout, cancel)
+	defer job.timer.Stop()
+	err := func() (err error) {
+		defer func() {
+			if r := recover(); r != nil {
+				err = fmt.Errorf("delay queue(%s) job(%s) panic: %v", q.name, job.ID, r)
+			}
+		}()
+		return handler(ctx, job)
+	}()
+	if err != nil {
+		log.ErrLog(fmt.Sprintf("delay queue(%s) job(%s) attempts(%d)", q.name, job.ID, job.Attempts), err)
+		q.fail(context.Background(), job)
+		return
+	}
+	q.ack(context.Background(), job)
+}
+
+// Run 启动worker处理到期任务,阻塞直到ctx结束且正在处理的任务全部完成
+func (q *DelayQueue) Run(ctx context.Context, handler func(ctx context.Context, job *Job) error) {
+	var wg sync.WaitGroup
+	wait := func(d time.Duration) bool {
+		t := time.NewTimer(d)
+		defer t.Stop()
+		select {
+		case <-ctx.Done():
+			return false
+		case <-t.C:
+			return true
+		}
+	}
+	for i := 0; i < q.conf.Workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for ctx.Err() == nil {
+				job, err := q.claim(ctx)
+				if err != nil || job == nil {
+					if !wait(q.conf.PollInterval) {
+						return
+					}
+					continue
+				}
+				q.handle(job, handler)
+			}
+		}()
+	}
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for wait(q.conf.PollInterval) {
+			q.requeue(ctx)
+		}
+	}()
+	wg.Wait()
+}
+
+// 分页获取死信任务,按进入死信的时间排序,limit<=0时获取offset之后的全部
+func (q *DelayQueue) DeadJobs(ctx context.Context, offset, limit int64) ([]*Job, error) {
+	conn, err := q.cache.getConn(ctx)
+	if err != nil {
+		log.ErrLog("", fmt.Errorf("获取redis conn失败 key(%s),error(%v)", q.name, err))
+		return nil, err
+	}
+	defer conn.Close()
+	stop := offset + limit - 1
+	if limit <= 0 {
+		stop = -1
+	}
+	ids, err := redis.Strings(conn.Do("ZRANGE", q.deadKey(), offset, stop))
+	if err != nil && err != redis.ErrNil {
+		err = fmt.Errorf("DeadJobs conn.Do(ZRANGE, %s) error(%v)", q.deadKey(), err)
+		log.ErrLog("", err)
+		return nil, err
+	}
+	jobs := make([]*Job, 0, len(ids))
+	for _, id := range ids {
+		data, err := redis.Bytes(conn.Do("HGET", q.jobsKey(), id))
+		if err != nil {
+			if err == redis.ErrNil {
+				continue
+			}
+			err = fmt.Errorf("DeadJobs conn.Do(HGET, %s, %s) error(%v)", q.jobsKey(), id, err)
+			log.ErrLog("", err)
+			return nil, err
+		}
+		job := &Job{}
+		if err = json.Unmarshal(data, job); err != nil {
+			log.ErrLog("", fmt.Errorf("unmarshal job fail, error(%v)", err))
+			return nil, err
+		}
+		job.Attempts, _ = redis.Int(conn.Do("HGET", q.attemptsKey(), id))
+		jobs = append(jobs, job)
+	}
+	return jobs, nil
+}
+
+// 将死信任务重新投递,投递次数清零,任务不在死信集合时返回false
+func (q *DelayQueue) RetryDead(ctx context.Context, id string) (bool, error) {
+	conn, err := q.cache.getConn(ctx)
+	if err != nil {
+		log.ErrLog("", fmt.Errorf("获取redis conn失败 key(%s),error(%v)", q.name, err))
+		return false, err
+	}
+	defer conn.Close()
+	ok, err := redis.Bool(retryDeadJobScript.Do(conn, q.deadKey(), q.readyKey(), q.attemptsKey(),
+		unixMilli(time.Now()), id))
+	if err != nil {
+		err = fmt.Errorf("RetryDead retryDeadJobScript.Do(%s, %s) error(%v)", q.name, id, err)
+		log.ErrLog("", err)
+	}
+	return ok, err
+}
+
+// 获取等待执行的任务数量
+func (q *DelayQueue) Len(ctx context.Context) (int64, error) {
+	conn, err := q.cache.getConn(ctx)
+	if err != nil {
+		log.ErrLog("", fmt.Errorf("获取redis conn失败 key(%s),error(%v)", q.name, err))
+		return 0, err
+	}
+	defer conn.Close()
+	n, err := redis.Int64(conn.Do("ZCARD", q.readyKey()))
+	if err != nil && err != redis.ErrNil {
+		err = fmt.Errorf("Len conn.Do(ZCARD, %s) error(%v)", q.readyKey(), err)
+		log.ErrLog("", err)
+		return 0, err
+	}
+	return n, nil
+}
@@ -0,0 +1,126 @@
+package redis
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestDelayQueueClaimToken(t *testing.T) {
+	c, _ := newTestCache(t, nil)
+	ctx := context.Background()
+	q := c.NewDelayQueue("dq", &DelayQueueConfig{VisibilityTimeout: time.Millisecond})
+	if _, err := q.Enqueue(ctx, []byte(`1`), time.Now()); err != nil {
+		t.Fatal(err)
+	}
+	stale, err := q.claim(ctx)
+	if err != nil || stale == nil {
+		t.Fatalf("claim = %v, %v", stale, err)
+	}
+	// 超时重投后旧的领取不能再确认、延长或失败任务
+	time.Sleep(5 * time.Millisecond)
+	if n, err := q.requeue(ctx); err != nil || n != 1 {
+		t.Fatalf("requeue = %d, %v", n, err)
+	}
+	job, err := q.claim(ctx)
+	if err != nil || job == nil || job.Attempts != 2 {
+		t.Fatalf("reclaim = %+v, %v", job, err)
+	}
+	if ok, err := q.Extend(ctx, stale, time.Minute); err != nil || ok {
+		t.Fatalf("stale Extend = %v, %v", ok, err)
+	}
+	if ok, err := q.Extend(ctx, job, time.Minute); err != nil || !ok {
+		t.Fatalf("Extend = %v, %v", ok, err)
+	}
+	if err = q.ack(ctx, stale); err != nil {
+		t.Fatal(err)
+	}
+	if err = q.fail(ctx, stale); err != nil {
+		t.Fatal(err)
+	}
+	if n, _ := q.requeue(ctx); n != 0 {
+		t.Fatalf("extended job requeued")
+	}
+	if err = q.ack(ctx, job); err != nil {
+		t.Fatal(err)
+	}
+	if n, err := q.Len(ctx); err != nil || n != 0 {
+		t.Fatalf("Len = %d, %v", n, err)
+	}
+	if job, err = q.claim(ctx); err != nil || job != nil {
+		t.Fatalf("claim after ack = %+v, %v", job, err)
+	}
+}
+
+func TestDelayQueueDead(t *testing.T) {
+	c, _ := newTestCache(t, nil)
+	ctx := context.Background()
+	q := c.NewDelayQueue("dq", &DelayQueueConfig{MaxRetry: 1})
+	for i := 0; i < 3; i++ {
+		if _, err := q.Enqueue(ctx, []byte(`1`), time.Now()); err != nil {
+			t.Fatal(err)
+		}
+		job, err := q.claim(ctx)
+		if err != nil || job == nil {
+			t.Fatalf("claim = %v, %v", job, err)
+		}
+		if err = q.fail(ctx, job); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if jobs, err := q.DeadJobs(ctx, 0, 2); err != nil || len(jobs) != 2 {
+		t.Fatalf("DeadJobs limit = %d, %v", len(jobs), err)
+	}
+	jobs, err := q.DeadJobs(ctx, 1, 0)
+	if err != nil || len(jobs) != 2 || jobs[0].Attempts != 1 {
+		t.Fatalf("DeadJobs all = %d, %v", len(jobs), err)
+	}
+	// 不在死信集合的任务不处理
+	if ok, err := q.RetryDead(ctx, "missing"); err != nil || ok {
+		t.Fatalf("RetryDead missing = %v, %v", ok, err)
+	}
+	if ok, err := q.RetryDead(ctx, jobs[0].ID); err != nil || !ok {
+		t.Fatalf("RetryDead = %v, %v", ok, err)
+	}
+	job, err := q.claim(ctx)
+	if err != nil || job == nil || job.ID != jobs[0].ID || job.Attempts != 1 {
+		t.Fatalf("claim retried = %+v, %v", job, err)
+	}
+	if jobs, _ = q.DeadJobs(ctx, 0, 0); len(jobs) != 2 {
+		t.Fatalf("DeadJobs after retry = %d", len(jobs))
+	}
+}
+
+func TestDelayQueueExtendCtx(t *testing.T) {
+	c, _ := newTestCache(t, nil)
+	ctx := context.Background()
+	q := c.NewDelayQueue("dq", &DelayQueueConfig{VisibilityTimeout: 30 * time.Millisecond})
+	run := func(extend bool) error {
+		if _, err := q.Enqueue(ctx, []byte(`1`), time.Now()); err != nil {
+			t.Fatal(err)
+		}
+		job, err := q.claim(ctx)
+		if err != nil || job == nil {
+			t.Fatalf("claim = %v, %v", job, err)
+		}
+		var herr error
+		q.handle(job, func(ctx context.Context, job *Job) error {
+			if extend {
+				if ok, err := q.Extend(ctx, job, time.Second); err != nil || !ok {
+					t.Fatalf("Extend = %v, %v", ok, err)
+				}
+			}
+			time.Sleep(60 * time.Millisecond)
+			herr = ctx.Err()
+			return nil
+		})
+		return herr
+	}
+	// 延长后handler的ctx不会在原超时时间结束
+	if err := run(true); err != nil {
+		t.Fatalf("extended ctx err = %v", err)
+	}
+	if err := run(false); err == nil {
+		t.Fatal("ctx not cancelled after visibility timeout")
+	}
+}
@@ -0,0 +1,253 @@
+package redis
+
+import (
+	"encoding/json"
+	"hash/fnv"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/thesky9531/lareina/log"
+)
+
+const (
+	sketchDepth = 4
+	sketchWidth = 2048
+
+	defaultHotKeyWindow    = 10 // 秒
+	defaultHotKeyThreshold = 1000
+	defaultHotKeyTopK      = 10
+	defaultHotKeyLocalSize = 1000
+	// 默认每100次访问采样一次,只有采样的访问需要加锁
+	defaultHotKeySampleRate = 100
+)
+
+// 热点key及窗口内的估算访问次数
+type HotKey struct {
+	Key   string
+	Count uint64
+}
+
+// 热点key探测,使用count-min sketch估算每个窗口内key的访问次数,
+// 维护少量候选key得到top-K,超过阈值的key可提升到本地缓存
+type hotKeys struct {
+	rate      uint64
+	threshold uint64
+	topK      int
+	sampled   uint64
+	// 已提升的key,map[string]bool,每个窗口整体替换,读取不加锁
+	promoted atomic.Value
+
+	mu         sync.Mutex
+	sketch     [sketchDepth][sketchWidth]uint32
+	candidates map[string]uint64
+	top        []HotKey
+	onReport   func([]HotKey)
+
+	// 提升后的本地缓存,为nil时只探测不提升
+	local *localCache
+}
+
+func newHotKeys(conf *Config) *hotKeys {
+	if !conf.HotKeyDetect {
+		return nil
+	}
+	h := &hotKeys{
+		rate:       uint64(conf.HotKeySampleRate),
+		threshold:  uint64(conf.HotKeyThreshold),
+		topK:       conf.HotKeyTopK,
+		candidates: make(map[string]uint64),
+	}
+	h.promoted.Store(map[string]bool{})
+	if h.rate == 0 {
+		h.rate = defaultHotKeySampleRate
+	}
+	if h.threshold == 0 {
+		h.threshold = defaultHotKeyThreshold
+	}
+	if h.topK <= 0 {
+		h.topK = defaultHotKeyTopK
+	}
+	if conf.HotKeyLocalTTL > 0 {
+		size := conf.HotKeyLocalSize
+		if size <= 0 {
+			size = defaultHotKeyLocalSize
+		}
+		h.local = newLocalCache(size, time.Duration(conf.HotKeyLocalTTL)*time.Second)
+	}
+	return h
+}
+
+// 记录一次访问,返回key是否已被提升到本地缓存
+func (h *hotKeys) record(key string) bool {
+	if h == nil {
+		return false
+	}
+	if atomic.AddUint64(&h.sampled, 1)%h.rate == 0 {
+		h.mu.Lock()
+		h.add(key)
+		h.mu.Unlock()
+	}
+	return h.isPromoted(key)
+}
+
+func (h *hotKeys) isPromoted(key string) bool {
+	return h.promoted.Load().(map[string]bool)[key]
+}
+
+func (h *hotKeys) add(key string) {
+	f := fnv.New64a()
+	f.Write([]byte(key))
+	sum := f.Sum64()
+	h1, h2 := uint32(sum), uint32(sum>>32)
+	est := uint32(0)
+	for i := 0; i < sketchDepth; i++ {
+		idx := (h1 + uint32(i)*h2) % sketchWidth
+		h.sketch[i][idx]++
+		if i == 0 || h.sketch[i][idx] < est {
+			est = h.sketch[i][idx]
+		}
+	}
+	count := uint64(est) * h.rate
+	if _, ok := h.candidates[key]; ok || len(h.candidates) < h.topK*4 {
+		h.candidates[key] = count
+		return
+	}
+	// 候选已满时替换计数最小的key
+	minKey, minCount := "", uint64(0)
+	for k, v := range h.candidates {
+		if minKey == "" || v < minCount {
+			minKey, minCount = k, v
+		}
+	}
+	if count > minCount {
+		delete(h.candidates, minKey)
+		h.candidates[key] = count
+	}
+}
+
+// 窗口结束时计算top-K并重置计数
+func (h *hotKeys) rotate() {
+	h.mu.Lock()
+	top := make([]HotKey, 0, len(h.candidates))
+	for k, v := range h.candidates {
+		top = append(top, HotKey{Key: k, Count: v})
+	}
+	sort.Slice(top, func(i, j int) bool { return top[i].Count > top[j].Count })
+	if len(top) > h.topK {
+		top = top[:h.topK]
+	}
+	promoted := make(map[string]bool)
+	if h.local != nil {
+		for _, k := range top {
+			if k.Count >= h.threshold {
+				promoted[k.Key] = true
+			}
+		}
+	}
+	h.promoted.Store(promoted)
+	h.top = top
+	h.sketch = [sketchDepth][sketchWidth]uint32{}
+	h.candidates = make(map[string]uint64)
+	onReport := h.onReport
+	h.mu.Unlock()
+	if onReport != nil && len(top) > 0 {
+		onReport(top)
+	}
+}
+
+func (c *Cache) runHotKeys() {
+	window := time.Duration(c.conf.HotKeyWindow) * time.Second
+	if window <= 0 {
+		window = defaultHotKeyWindow * time.Second
+	}
+	t := time.NewTicker(window)
+	defer t.Stop()
+	for {
+		select {
+		case <-c.closed:
+			return
+		case <-t.C:
+			c.hot.rotate()
+		}
+	}
+}
+
+// 上一个窗口访问次数最多的key,未开启 HotKeyDetect 时返回nil
+func (c *Cache) HotKeys() []HotKey {
+	if c.hot == nil {
+		return nil
+	}
+	c.hot.mu.Lock()
+	defer c.hot.mu.Unlock()
+	return append([]HotKey(nil), c.hot.top...)
+}
+
+// 每个窗口结束时回调当前的top-K热点key
+func (c *Cache) OnHotKeys(fn func(keys []HotKey)) {
+	if c.hot == nil {
+		return
+	}
+	c.hot.mu.Lock()
+	c.hot.onReport = fn
+	c.hot.mu.Unlock()
+}
+
+// 记录访问,已提升的key本地缓存命中时直接返回
+func (c *Cache) hotGet(key string) (json.RawMessage, bool) {
+	if !c.hot.record(key) {
+		return nil, false
+	}
+	v, ok := c.hot.local.get(c.key(key))
+	if !ok {
+		return nil, false
+	}
+	return v.(json.RawMessage), true
+}
+
+// 已提升的key从redis读取后写入本地缓存
+func (c *Cache) hotSet(key string, data json.RawMessage) {
+	if c.hot == nil || c.hot.local == nil {
+		return
+	}
+	if c.hot.isPromoted(key) {
+		c.hot.local.set(c.key(key), data)
+	}
+}
+
+// 本实例写入或删除redis后清除本地缓存,需要在redis命令返回后调用,
+// 否则并发读取可能在清除后读到旧值并重新写入本地缓存。其他实例最多延迟 HotKeyLocalTTL 生效
+func (c *Cache) hotDel(keys ...string) {
+	if c.hot == nil {
+		return
+	}
+	for _, key := range keys {
+		c.hot.local.del(c.key(key))
+	}
+}
+
+// 按redis中的完整key清除本地缓存,用于标签失效等只拿到完整key的场景
+func (c *Cache) hotDelRaw(keys ...string) {
+	if c.hot == nil {
+		return
+	}
+	for _, k := range keys {
+		c.hot.local.del(k)
+	}
+}
+
+// 清除匹配glob模式的本地缓存,用于 RegexpDelKey
+func (c *Cache) hotDelPattern(patterns ...string) {
+	if c.hot == nil || c.hot.local == nil {
+		return
+	}
+	for _, pattern := range patterns {
+		re, err := globRegexp(c.key(pattern))
+		if err != nil {
+			log.ErrLog("", err)
+			continue
+		}
+		c.hot.local.delMatch(re)
+	}
+}
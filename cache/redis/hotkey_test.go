@@ -0,0 +1,106 @@
+package redis
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"testing"
+)
+
+func TestHotKeys(t *testing.T) {
+	c := New(&Config{HotKeyDetect: true, HotKeySampleRate: 1, HotKeyThreshold: 100, HotKeyTopK: 2, HotKeyLocalTTL: 60})
+	defer c.Close()
+	var reported []HotKey
+	c.OnHotKeys(func(keys []HotKey) { reported = keys })
+	for i := 0; i < 500; i++ {
+		c.hot.record("hot_a")
+		if i%2 == 0 {
+			c.hot.record("hot_b")
+		}
+		c.hot.record(fmt.Sprintf("cold_%d", i))
+	}
+	c.hot.rotate()
+	if len(reported) != 2 || reported[0].Key != "hot_a" || reported[1].Key != "hot_b" {
+		t.Fatalf("reported = %v", reported)
+	}
+	if reported[0].Count < 500 {
+		t.Fatalf("count = %d, want >= 500", reported[0].Count)
+	}
+	if len(c.HotKeys()) != 2 {
+		t.Fatalf("HotKeys = %v", c.HotKeys())
+	}
+
+	if _, ok := c.hotGet("hot_a"); ok {
+		t.Fatal("hit before load")
+	}
+	c.hotSet("hot_a", json.RawMessage(`1`))
+	c.hotSet("cold_1", json.RawMessage(`1`))
+	if data, ok := c.hotGet("hot_a"); !ok || string(data) != "1" {
+		t.Fatalf("hotGet = %s, %v", data, ok)
+	}
+	if _, ok := c.hotGet("cold_1"); ok {
+		t.Fatal("cold key promoted")
+	}
+	c.hotDel("hot_a")
+	if _, ok := c.hotGet("hot_a"); ok {
+		t.Fatal("hit after delete")
+	}
+}
+
+func TestHotKeyInvalidate(t *testing.T) {
+	c, _ := newTestCache(t, &Config{HotKeyDetect: true, HotKeySampleRate: 1, HotKeyThreshold: 1, HotKeyLocalTTL: 60})
+	ctx := context.Background()
+	// 写入并提升到本地缓存
+	promote := func(key string, data string, tags ...string) {
+		if err := c.SetRawMessage(ctx, key, json.RawMessage(data), tags...); err != nil {
+			t.Fatal(err)
+		}
+		c.GetRawMessage(ctx, key)
+		c.hot.rotate()
+		c.GetRawMessage(ctx, key)
+		if _, ok := c.hot.local.get(c.key(key)); !ok {
+			t.Fatalf("%s not promoted", key)
+		}
+	}
+	cases := []struct {
+		name string
+		del  func(key string)
+	}{
+		{"set", func(key string) { c.SetRawMessage(ctx, key, json.RawMessage(`2`)) }},
+		{"del", func(key string) { c.DelKey(ctx, key) }},
+		{"double delete", func(key string) { c.DoubleDelete(ctx, key) }},
+		{"tx set", func(key string) {
+			c.Transaction(ctx, nil, func(tx *Tx) error { return tx.SetRawMessage(key, json.RawMessage(`2`)) })
+		}},
+		{"tx del", func(key string) {
+			c.Transaction(ctx, nil, func(tx *Tx) error { tx.Del(key); return nil })
+		}},
+		{"tags", func(key string) { c.InvalidateTags(ctx, "t") }},
+		{"regexp", func(key string) { c.RegexpDelMultiKey(ctx, "hot_*") }},
+	}
+	for _, tc := range cases {
+		key := "hot_" + tc.name
+		promote(key, `1`, "t")
+		tc.del(key)
+		if data, err := c.GetRawMessage(ctx, key); err == nil && string(data) == "1" {
+			t.Errorf("%s: stale local value", tc.name)
+		}
+	}
+}
+
+func TestHotKeysDefaultSampling(t *testing.T) {
+	c := New(&Config{HotKeyDetect: true, HotKeyThreshold: 1000, HotKeyLocalTTL: 60})
+	defer c.Close()
+	for i := 0; i < 5000; i++ {
+		c.hot.record("hot_a")
+	}
+	c.hot.rotate()
+	// 采样后按采样率估算访问次数
+	top := c.HotKeys()
+	if len(top) != 1 || top[0].Key != "hot_a" || top[0].Count != 5000 {
+		t.Fatalf("HotKeys = %v", top)
+	}
+	if !c.hot.isPromoted("hot_a") {
+		t.Fatal("hot_a not promoted")
+	}
+}
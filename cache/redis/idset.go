@@ -0,0 +1,261 @@
+package redis
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/gomodule/redigo/redis"
+	"github.com/thesky9531/lareina/log"
+)
+
+// 仅在列表已缓存时增量添加,不存在时不写入,避免产生不完整的列表
+var addToIdSetScript = RegisterScript("addToIdSet", 1, `
+local t = redis.call("TYPE", KEYS[1]).ok
+if t == "none" then
+	return 0
+end
+if t == "string" then
+	redis.call("DEL", KEYS[1])
+end
+for i = 2, #ARGV, 2 do
+	redis.call("ZADD", KEYS[1], ARGV[i], ARGV[i + 1])
+end
+redis.call("EXPIRE", KEYS[1], ARGV[1])
+return 1`)
+
+// 仅在列表已缓存时增量删除,删空后写入哨兵,保持缓存有效
+var removeFromIdSetScript = RegisterScript("removeFromIdSet", 1, `
+if redis.call("TYPE", KEYS[1]).ok ~= "zset" then
+	return 0
+end
+for i = 2, #ARGV do
+	redis.call("ZREM", KEYS[1], ARGV[i])
+end
+if redis.call("ZCARD", KEYS[1]) == 0 then
+	redis.call("SETEX", KEYS[1], ARGV[1], "emptylist")
+else
+	redis.call("EXPIRE", KEYS[1], ARGV[1])
+end
+return 1`)
+
+// 带分数的id,分数可以是时间戳等排序字段
+type ScoredId struct {
+	Id    int64
+	Score float64
+}
+
+func parseIds(key string, values []string) ([]int64, error) {
+	list := make([]int64, 0, len(values))
+	for _, v := range values {
+		id, err := strconv.ParseInt(v, 10, 64)
+		if err != nil {
+			err = fmt.Errorf("parseIds key(%s) ParseInt(%s) error(%v)", key, v, err)
+			log.ErrLog("", err)
+			return nil, err
+		}
+		list = append(list, id)
+	}
+	return list, nil
+}
+
+// 判断列表是否已缓存并续期,不存在时返回 redis.ErrNil
+func touchIdSet(conn redis.Conn, listKey string, expireTime int) error {
+	ok, err := redis.Bool(conn.Do("EXPIRE", listKey, expireTime))
+	if err != nil && err != redis.ErrNil {
+		err = fmt.Errorf("touchIdSet conn.Do(EXPIRE, %s) error(%v)", listKey, err)
+		log.ErrLog("", err)
+		return err
+	}
+	if !ok {
+		// 不存在或者为空都会重新去DB获取
+		return redis.ErrNil
+	}
+	return nil
+}
+
+// 查询zset,哨兵(空列表)返回空
+func queryIdSet(conn redis.Conn, listKey string, expireTime int, cmd string, args ...interface{}) ([]int64, error) {
+	if err := touchIdSet(conn, listKey, expireTime); err != nil {
+		return nil, err
+	}
+	values, err := redis.Strings(conn.Do(cmd, append([]interface{}{listKey}, args...)...))
+	if err != nil && err != redis.ErrNil {
+		if e, ok := err.(redis.Error); ok && strings.Index(e.Error(), "WRONGTYPE") != -1 {
+			return []int64{}, nil
+		}
+		err = fmt.Errorf("queryIdSet conn.Do(%s, %s, %v) error(%v)", cmd, listKey, args, err)
+		log.ErrLog("", err)
+		return nil, err
+	}
+	return parseIds(listKey, values)
+}
+
+func setScoredIdSet(conn redis.Conn, listKey string, list []ScoredId, expireTime int, tagKeys ...string) (err error) {
+	if len(list) <= 0 {
+		// 设置哨兵
+		if err = setSentinel(conn, listKey, expireTime, tagKeys); err != nil {
+			err = fmt.Errorf("setScoredIdSet conn.Do(SETEX, %s, %v) error(%v)", listKey, list, err)
+			log.ErrLog("", err)
+		}
+		return err
+	}
+	args := make([]interface{}, 0, 2*len(list)+1)
+	args = append(args, expireTime)
+	for _, v := range list {
+		args = append(args, v.Score, v.Id)
+	}
+	if _, err = replaceZSetScript.Do(conn, taggedArgs(listKey, tagKeys, args...)...); err != nil {
+		err = fmt.Errorf("setScoredIdSet replaceZSetScript.Do(%s, %v) error(%v)", listKey, list, err)
+		log.ErrLog("", err)
+	}
+	return err
+}
+
+func addToIdSet(conn redis.Conn, listKey string, list []ScoredId, expireTime int) error {
+	args := make([]interface{}, 0, 2*len(list)+2)
+	args = append(args, listKey, expireTime)
+	for _, v := range list {
+		args = append(args, v.Score, v.Id)
+	}
+	if _, err := addToIdSetScript.Do(conn, args...); err != nil {
+		err = fmt.Errorf("addToIdSet addToIdSetScript.Do(%s, %v) error(%v)", listKey, list, err)
+		log.ErrLog("", err)
+		return err
+	}
+	return nil
+}
+
+func removeFromIdSet(conn redis.Conn, listKey string, ids []int64, expireTime int) error {
+	args := make([]interface{}, 0, len(ids)+2)
+	args = append(args, listKey, expireTime)
+	for _, id := range ids {
+		args = append(args, id)
+	}
+	if _, err := removeFromIdSetScript.Do(conn, args...); err != nil {
+		err = fmt.Errorf("removeFromIdSet removeFromIdSetScript.Do(%s, %v) error(%v)", listKey, ids, err)
+		log.ErrLog("", err)
+		return err
+	}
+	return nil
+}
+
+// 使用zset存储带分数的id列表,如按时间戳排序的feed
+func (c *Cache) SetScoredIdSet(ctx context.Context, key string, list []ScoredId, tags ...string) error {
+	conn, err := c.getConn(ctx)
+	if err != nil {
+		log.ErrLog("", fmt.Errorf("获取redis conn失败 key(%s),error(%v)", key, err))
+		return err
+	}
+	defer conn.Close()
+	return setScoredIdSet(conn, c.key(key), list, c.conf.ExpireTime, c.tagKeys(tags)...)
+}
+
+// 按分数从低到高分页获取id列表,offset从0开始
+func (c *Cache) GetIdSetPage(ctx context.Context, key string, offset, limit int64) ([]int64, error) {
+	conn, err := c.getConn(ctx)
+	if err != nil {
+		log.ErrLog("", fmt.Errorf("获取redis conn失败 key(%s),error(%v)", key, err))
+		return nil, err
+	}
+	defer conn.Close()
+	if limit <= 0 {
+		return queryIdSet(conn, c.key(key), c.conf.ExpireTime, "ZRANGE", offset, -1)
+	}
+	return queryIdSet(conn, c.key(key), c.conf.ExpireTime, "ZRANGE", offset, offset+limit-1)
+}
+
+// 按分数从高到低分页获取id列表,offset从0开始
+func (c *Cache) GetIdSetPageRev(ctx context.Context, key string, offset, limit int64) ([]int64, error) {
+	conn, err := c.getConn(ctx)
+	if err != nil {
+		log.ErrLog("", fmt.Errorf("获取redis conn失败 key(%s),error(%v)", key, err))
+		return nil, err
+	}
+	defer conn.Close()
+	if limit <= 0 {
+		return queryIdSet(conn, c.key(key), c.conf.ExpireTime, "ZREVRANGE", offset, -1)
+	}
+	return queryIdSet(conn, c.key(key), c.conf.ExpireTime, "ZREVRANGE", offset, offset+limit-1)
+}
+
+// 按分数范围[min, max]从低到高获取id列表,limit<=0时不分页
+func (c *Cache) GetIdSetByScore(ctx context.Context, key string, min, max float64, offset, limit int64) ([]int64, error) {
+	conn, err := c.getConn(ctx)
+	if err != nil {
+		log.ErrLog("", fmt.Errorf("获取redis conn失败 key(%s),error(%v)", key, err))
+		return nil, err
+	}
+	defer conn.Close()
+	if limit <= 0 {
+		return queryIdSet(conn, c.key(key), c.conf.ExpireTime, "ZRANGEBYSCORE", min, max)
+	}
+	return queryIdSet(conn, c.key(key), c.conf.ExpireTime, "ZRANGEBYSCORE", min, max, "LIMIT", offset, limit)
+}
+
+// 按分数范围[min, max]从高到低获取id列表,limit<=0时不分页
+func (c *Cache) GetIdSetByScoreRev(ctx context.Context, key string, min, max float64, offset, limit int64) ([]int64, error) {
+	conn, err := c.getConn(ctx)
+	if err != nil {
+		log.ErrLog("", fmt.Errorf("获取redis conn失败 key(%s),error(%v)", key, err))
+		return nil, err
+	}
+	defer conn.Close()
+	if limit <= 0 {
+		return queryIdSet(conn, c.key(key), c.conf.ExpireTime, "ZREVRANGEBYSCORE", max, min)
+	}
+	return queryIdSet(conn, c.key(key), c.conf.ExpireTime, "ZREVRANGEBYSCORE", max, min, "LIMIT", offset, limit)
+}
+
+// 获取id列表长度,列表未缓存时返回 redis.ErrNil
+func (c *Cache) GetIdSetCount(ctx context.Context, key string) (int64, error) {
+	conn, err := c.getConn(ctx)
+	if err != nil {
+		log.ErrLog("", fmt.Errorf("获取redis conn失败 key(%s),error(%v)", key, err))
+		return 0, err
+	}
+	defer conn.Close()
+	key = c.key(key)
+	if err = touchIdSet(conn, key, c.conf.ExpireTime); err != nil {
+		return 0, err
+	}
+	count, err := redis.Int64(conn.Do("ZCARD", key))
+	if err != nil && err != redis.ErrNil {
+		if e, ok := err.(redis.Error); ok && strings.Index(e.Error(), "WRONGTYPE") != -1 {
+			return 0, nil
+		}
+		err = fmt.Errorf("GetIdSetCount conn.Do(ZCARD, %s) error(%v)", key, err)
+		log.ErrLog("", err)
+		return 0, err
+	}
+	return count, nil
+}
+
+// 向已缓存的id列表增量添加,列表未缓存时不做处理
+func (c *Cache) AddToIdSet(ctx context.Context, key string, list ...ScoredId) error {
+	if len(list) == 0 {
+		return nil
+	}
+	conn, err := c.getConn(ctx)
+	if err != nil {
+		log.ErrLog("", fmt.Errorf("获取redis conn失败 key(%s),error(%v)", key, err))
+		return err
+	}
+	defer conn.Close()
+	return addToIdSet(conn, c.key(key), list, c.conf.ExpireTime)
+}
+
+// 从已缓存的id列表增量删除,列表未缓存时不做处理
+func (c *Cache) RemoveFromIdSet(ctx context.Context, key string, ids ...int64) error {
+	if len(ids) == 0 {
+		return nil
+	}
+	conn, err := c.getConn(ctx)
+	if err != nil {
+		log.ErrLog("", fmt.Errorf("获取redis conn失败 key(%s),error(%v)", key, err))
+		return err
+	}
+	defer conn.Close()
+	return removeFromIdSet(conn, c.key(key), ids, c.conf.ExpireTime)
+}
@@ -0,0 +1,83 @@
+package redis
+
+import (
+	"context"
+	"reflect"
+	"testing"
+
+	"github.com/gomodule/redigo/redis"
+)
+
+func TestParseIds(t *testing.T) {
+	ids, err := parseIds("k", []string{"3", "1", "-2"})
+	if err != nil || !reflect.DeepEqual(ids, []int64{3, 1, -2}) {
+		t.Fatalf("parseIds = %v, %v", ids, err)
+	}
+	if _, err = parseIds("k", []string{"1", "x"}); err == nil {
+		t.Error("invalid id accepted")
+	}
+}
+
+func TestScoredIdSet(t *testing.T) {
+	c, _ := newTestCache(t, nil)
+	ctx := context.Background()
+	if _, err := c.GetIdSetPage(ctx, "feed", 0, 10); err != redis.ErrNil {
+		t.Fatalf("uncached err = %v, want ErrNil", err)
+	}
+	// 未缓存时增量添加不写入
+	if err := c.AddToIdSet(ctx, "feed", ScoredId{Id: 9, Score: 9}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := c.GetIdSetCount(ctx, "feed"); err != redis.ErrNil {
+		t.Fatalf("AddToIdSet created uncached list, err = %v", err)
+	}
+
+	list := []ScoredId{{Id: 1, Score: 10}, {Id: 2, Score: 30}, {Id: 3, Score: 20}}
+	if err := c.SetScoredIdSet(ctx, "feed", list); err != nil {
+		t.Fatal(err)
+	}
+	cases := []struct {
+		name string
+		get  func() ([]int64, error)
+		want []int64
+	}{
+		{"page", func() ([]int64, error) { return c.GetIdSetPage(ctx, "feed", 0, 2) }, []int64{1, 3}},
+		{"page all", func() ([]int64, error) { return c.GetIdSetPage(ctx, "feed", 1, 0) }, []int64{3, 2}},
+		{"rev", func() ([]int64, error) { return c.GetIdSetPageRev(ctx, "feed", 0, 2) }, []int64{2, 3}},
+		{"score", func() ([]int64, error) { return c.GetIdSetByScore(ctx, "feed", 15, 30, 0, 0) }, []int64{3, 2}},
+		{"score rev limit", func() ([]int64, error) { return c.GetIdSetByScoreRev(ctx, "feed", 0, 30, 1, 1) }, []int64{3}},
+	}
+	for _, tc := range cases {
+		got, err := tc.get()
+		if err != nil || !reflect.DeepEqual(got, tc.want) {
+			t.Errorf("%s = %v, %v, want %v", tc.name, got, err, tc.want)
+		}
+	}
+
+	if err := c.AddToIdSet(ctx, "feed", ScoredId{Id: 4, Score: 40}); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.RemoveFromIdSet(ctx, "feed", 1, 2); err != nil {
+		t.Fatal(err)
+	}
+	if got, _ := c.GetIdSetPage(ctx, "feed", 0, 0); !reflect.DeepEqual(got, []int64{3, 4}) {
+		t.Fatalf("after add/remove = %v", got)
+	}
+	// 删空后为哨兵,仍视为已缓存
+	if err := c.RemoveFromIdSet(ctx, "feed", 3, 4); err != nil {
+		t.Fatal(err)
+	}
+	if n, err := c.GetIdSetCount(ctx, "feed"); err != nil || n != 0 {
+		t.Fatalf("empty count = %d, %v", n, err)
+	}
+	if got, err := c.GetIdSetPage(ctx, "feed", 0, 10); err != nil || len(got) != 0 {
+		t.Fatalf("empty page = %v, %v", got, err)
+	}
+	// 哨兵上增量添加恢复为zset
+	if err := c.AddToIdSet(ctx, "feed", ScoredId{Id: 5, Score: 1}); err != nil {
+		t.Fatal(err)
+	}
+	if got, _ := c.GetIdSetPage(ctx, "feed", 0, 0); !reflect.DeepEqual(got, []int64{5}) {
+		t.Fatalf("after sentinel add = %v", got)
+	}
+}
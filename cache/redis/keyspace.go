@@ -0,0 +1,133 @@
+package redis
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/gomodule/redigo/redis"
+	"github.com/thesky9531/lareina/log"
+)
+
+// 键事件类型
+const (
+	KeyEventExpired = "expired"
+	KeyEventEvicted = "evicted"
+	KeyEventDel     = "del"
+)
+
+// 事件对应的 notify-keyspace-events 标志
+var keyEventFlags = map[string]string{
+	KeyEventExpired: "x",
+	KeyEventEvicted: "e",
+	KeyEventDel:     "g",
+}
+
+// 键空间通知事件
+type KeyEvent struct {
+	Event string
+	// 去掉命名空间和版本前缀后的key
+	Key string
+	// redis中的完整key
+	RawKey string
+}
+
+type KeyEventHandler func(ctx context.Context, e *KeyEvent)
+
+// 开启键空间通知,在现有配置上追加events需要的标志,events为空时开启expired/evicted/del。
+// 云服务禁用CONFIG命令时需要在控制台配置 notify-keyspace-events
+func (c *Cache) EnableKeyspaceEvents(ctx context.Context, events ...string) error {
+	if len(events) == 0 {
+		events = []string{KeyEventExpired, KeyEventEvicted, KeyEventDel}
+	}
+	conn, err := c.getConn(ctx)
+	if err != nil {
+		log.ErrLog("", fmt.Errorf("获取redis conn失败 error(%v)", err))
+		return err
+	}
+	defer conn.Close()
+	reply, err := redis.Strings(conn.Do("CONFIG", "GET", "notify-keyspace-events"))
+	if err != nil {
+		err = fmt.Errorf("EnableKeyspaceEvents conn.Do(CONFIG GET) error(%v)", err)
+		log.ErrLog("", err)
+		return err
+	}
+	flags := ""
+	if len(reply) == 2 {
+		flags = reply[1]
+	}
+	add := "K"
+	for _, e := range events {
+		f, ok := keyEventFlags[e]
+		if !ok {
+			return fmt.Errorf("EnableKeyspaceEvents unsupported event(%s)", e)
+		}
+		add += f
+	}
+	for _, f := range add {
+		if !strings.ContainsRune(flags, f) && !(strings.ContainsRune(flags, 'A') && f != 'K') {
+			flags += string(f)
+		}
+	}
+	if _, err = conn.Do("CONFIG", "SET", "notify-keyspace-events", flags); err != nil {
+		err = fmt.Errorf("EnableKeyspaceEvents conn.Do(CONFIG SET, %s) error(%v)", flags, err)
+		log.ErrLog("", err)
+	}
+	return err
+}
+
+func (c *Cache) keyspaceChannelPrefix() string {
+	return fmt.Sprintf("__keyspace@%d__:", c.conf.DB)
+}
+
+// 订阅的频道模式,开启命名空间版本时匹配所有版本,再在本地按pattern过滤
+func (c *Cache) keyspacePattern(pattern string) string {
+	if c.conf.Namespace != "" && c.conf.NamespaceVersion {
+		return c.keyspaceChannelPrefix() + c.nsKey("*"+pattern)
+	}
+	return c.keyspaceChannelPrefix() + c.nsKey(pattern)
+}
+
+// 去掉命名空间和版本前缀,只去掉当前版本的前缀,
+// 旧版本的缓存key保留版本号(通常不会匹配pattern),不随版本的key只去掉命名空间
+func (c *Cache) trimEventKey(key string) string {
+	if c.conf.Namespace == "" {
+		return key
+	}
+	if prefix := c.keyPrefix(); strings.HasPrefix(key, prefix) {
+		return key[len(prefix):]
+	}
+	return strings.TrimPrefix(key, c.conf.Namespace+":")
+}
+
+// 订阅键空间通知,pattern为不含命名空间的glob模式,如 presence_*、redislock_*,
+// events为空时接收所有已开启的事件。需要先调用 EnableKeyspaceEvents 或在服务端开启通知
+func (s *Subscriber) SubscribeKeyEvents(pattern string, handler KeyEventHandler, events ...string) error {
+	re, err := globRegexp(pattern)
+	if err != nil {
+		log.ErrLog("", err)
+		return err
+	}
+	filter := make(map[string]bool, len(events))
+	for _, e := range events {
+		filter[e] = true
+	}
+	c := s.cache
+	prefix := c.keyspaceChannelPrefix()
+	return s.PSubscribe(c.keyspacePattern(pattern), func(ctx context.Context, msg *Message) {
+		e := &KeyEvent{Event: string(msg.Data), RawKey: strings.TrimPrefix(msg.Channel, prefix)}
+		if len(filter) > 0 && !filter[e.Event] {
+			return
+		}
+		e.Key = c.trimEventKey(e.RawKey)
+		if !re.MatchString(e.Key) {
+			return
+		}
+		handler(ctx, e)
+	})
+}
+
+// 取消键空间通知订阅
+func (s *Subscriber) UnsubscribeKeyEvents(pattern string) error {
+	return s.PUnsubscribe(s.cache.keyspacePattern(pattern))
+}
@@ -0,0 +1,28 @@
+package redis
+
+import "testing"
+
+func TestKeyspacePattern(t *testing.T) {
+	c := New(&Config{Namespace: "app", DB: 2})
+	if p := c.keyspacePattern("presence_*"); p != "__keyspace@2__:app:presence_*" {
+		t.Fatalf("pattern = %s", p)
+	}
+	if k := c.trimEventKey("app:presence_1"); k != "presence_1" {
+		t.Fatalf("key = %s", k)
+	}
+
+	c = New(&Config{Namespace: "app", NamespaceVersion: true})
+	if p := c.keyspacePattern("user_*"); p != "__keyspace@0__:app:*user_*" {
+		t.Fatalf("pattern = %s", p)
+	}
+	for raw, want := range map[string]string{
+		"app:v0:user_1":           "user_1",
+		"app:v3:user_1":           "v3:user_1",
+		"app:redislock_user_1":    "redislock_user_1",
+		"app:v2:redislock_user_1": "v2:redislock_user_1",
+	} {
+		if k := c.trimEventKey(raw); k != want {
+			t.Fatalf("trimEventKey(%s) = %s, want %s", raw, k, want)
+		}
+	}
+}
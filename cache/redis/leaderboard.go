@@ -0,0 +1,281 @@
+package redis
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/gomodule/redigo/redis"
+	"github.com/thesky9531/lareina/log"
+	xtime "github.com/thesky9531/lareina/time"
+)
+
+// 排行榜成员
+type RankMember struct {
+	Member string
+	Score  float64
+	Rank   int64 // 名次,从1开始,分数越高名次越靠前
+}
+
+// 基于zset的排行榜
+type Leaderboard struct {
+	cache    *Cache
+	name     string
+	interval uint32
+	at       time.Time
+}
+
+// NewLeaderboard 创建排行榜,interval取值同time包 TimeIntervalDay/TimeIntervalWeek/TimeIntervalMonth 等,
+// 为0时不按周期重置,不支持的interval记录错误日志并按0处理
+func (c *Cache) NewLeaderboard(name string, interval uint32) *Leaderboard {
+	if interval != 0 && !xtime.IsValidInterval(interval) {
+		log.ErrLog("", fmt.Errorf("NewLeaderboard name(%s) unsupported interval(%d)", name, interval))
+		interval = 0
+	}
+	return &Leaderboard{
+		cache:    c,
+		name:     name,
+		interval: interval,
+	}
+}
+
+// At 返回指定时间所在周期的排行榜,用于查询历史周期
+func (l *Leaderboard) At(t time.Time) *Leaderboard {
+	rel := *l
+	rel.at = t
+	return &rel
+}
+
+func (l *Leaderboard) now() time.Time {
+	if l.at.IsZero() {
+		return time.Now()
+	}
+	return l.at
+}
+
+func (l *Leaderboard) key() string {
+	key, _ := l.period()
+	return key
+}
+
+// 按周期生成key,格式为 name_200601021504,周期排行榜保留到下个周期结束,方便查询上一期结果。
+// key和过期时间使用同一时间计算,避免周期边界写入时两者不属于同一周期
+func (l *Leaderboard) period() (key string, expireAt int64) {
+	if l.interval == 0 {
+		return l.cache.nsKey(l.name), 0
+	}
+	now := l.now()
+	start := xtime.GetIntervalStart(now, l.interval)
+	next := xtime.GetNextIntervalStart(now, l.interval)
+	return l.cache.nsKey(fmt.Sprintf("%s_%s", l.name, start.Format("200601021504"))),
+		xtime.GetNextIntervalStart(next, l.interval).Unix()
+}
+
+func zIncrBy(conn redis.Conn, key string, member string, delta float64, expireAt int64) (float64, error) {
+	score, err := redis.Float64(conn.Do("ZINCRBY", key, delta, member))
+	if err != nil {
+		err = fmt.Errorf("zIncrBy conn.Do(ZINCRBY, %s, %s) error(%v)", key, member, err)
+		log.ErrLog("", err)
+		return 0, err
+	}
+	if expireAt > 0 {
+		if _, err = conn.Do("EXPIREAT", key, expireAt); err != nil {
+			log.ErrLog("", err)
+			return score, err
+		}
+	}
+	return score, nil
+}
+
+func zAdd(conn redis.Conn, key string, member string, score float64, expireAt int64) error {
+	if _, err := conn.Do("ZADD", key, score, member); err != nil {
+		err = fmt.Errorf("zAdd conn.Do(ZADD, %s, %s) error(%v)", key, member, err)
+		log.ErrLog("", err)
+		return err
+	}
+	if expireAt > 0 {
+		if _, err := conn.Do("EXPIREAT", key, expireAt); err != nil {
+			log.ErrLog("", err)
+			return err
+		}
+	}
+	return nil
+}
+
+func zRem(conn redis.Conn, key string, members []string) error {
+	args := make([]interface{}, 0, len(members)+1)
+	args = append(args, key)
+	for _, m := range members {
+		args = append(args, m)
+	}
+	if _, err := conn.Do("ZREM", args...); err != nil {
+		err = fmt.Errorf("zRem conn.Do(ZREM, %s, %v) error(%v)", key, members, err)
+		log.ErrLog("", err)
+		return err
+	}
+	return nil
+}
+
+// 按分数从高到低获取[start, stop]区间的成员
+func zRevRangeWithScores(conn redis.Conn, key string, start, stop int64) ([]RankMember, error) {
+	values, err := redis.Strings(conn.Do("ZREVRANGE", key, start, stop, "WITHSCORES"))
+	if err != nil && err != redis.ErrNil {
+		err = fmt.Errorf("zRevRangeWithScores conn.Do(ZREVRANGE, %s, %d, %d) error(%v)", key, start, stop, err)
+		log.ErrLog("", err)
+		return nil, err
+	}
+	rsp := make([]RankMember, 0, len(values)/2)
+	for i := 0; i+1 < len(values); i = i + 2 {
+		score, err := strconv.ParseFloat(values[i+1], 64)
+		if err != nil {
+			err = fmt.Errorf("zRevRangeWithScores ParseFloat(%s) error(%v)", values[i+1], err)
+			log.ErrLog("", err)
+			return nil, err
+		}
+		rsp = append(rsp, RankMember{
+			Member: values[i],
+			Score:  score,
+			Rank:   start + int64(i/2) + 1,
+		})
+	}
+	return rsp, nil
+}
+
+// 成员不存在时返回 redis.ErrNil
+func zRevRank(conn redis.Conn, key string, member string) (*RankMember, error) {
+	rank, err := redis.Int64(conn.Do("ZREVRANK", key, member))
+	if err != nil {
+		if err != redis.ErrNil {
+			err = fmt.Errorf("zRevRank conn.Do(ZREVRANK, %s, %s) error(%v)", key, member, err)
+			log.ErrLog("", err)
+		}
+		return nil, err
+	}
+	score, err := redis.Float64(conn.Do("ZSCORE", key, member))
+	if err != nil {
+		if err != redis.ErrNil {
+			err = fmt.Errorf("zRevRank conn.Do(ZSCORE, %s, %s) error(%v)", key, member, err)
+			log.ErrLog("", err)
+		}
+		return nil, err
+	}
+	return &RankMember{Member: member, Score: score, Rank: rank + 1}, nil
+}
+
+// 增加成员分数,返回增加后的分数
+func (l *Leaderboard) IncrScore(ctx context.Context, member string, delta float64) (float64, error) {
+	conn, err := l.cache.getConn(ctx)
+	if err != nil {
+		log.ErrLog("", fmt.Errorf("获取redis conn失败 key(%s),error(%v)", l.name, err))
+		return 0, err
+	}
+	defer conn.Close()
+	key, expireAt := l.period()
+	return zIncrBy(conn, key, member, delta, expireAt)
+}
+
+// 设置成员分数
+func (l *Leaderboard) SetScore(ctx context.Context, member string, score float64) error {
+	conn, err := l.cache.getConn(ctx)
+	if err != nil {
+		log.ErrLog("", fmt.Errorf("获取redis conn失败 key(%s),error(%v)", l.name, err))
+		return err
+	}
+	defer conn.Close()
+	key, expireAt := l.period()
+	return zAdd(conn, key, member, score, expireAt)
+}
+
+// 移除成员
+func (l *Leaderboard) Remove(ctx context.Context, members ...string) error {
+	if len(members) == 0 {
+		return nil
+	}
+	conn, err := l.cache.getConn(ctx)
+	if err != nil {
+		log.ErrLog("", fmt.Errorf("获取redis conn失败 key(%s),error(%v)", l.name, err))
+		return err
+	}
+	defer conn.Close()
+	return zRem(conn, l.key(), members)
+}
+
+// 获取前n名
+func (l *Leaderboard) Top(ctx context.Context, n int64) ([]RankMember, error) {
+	return l.Range(ctx, 0, n)
+}
+
+// 分页获取排名,offset从0开始
+func (l *Leaderboard) Range(ctx context.Context, offset, limit int64) ([]RankMember, error) {
+	if limit <= 0 {
+		return []RankMember{}, nil
+	}
+	conn, err := l.cache.getConn(ctx)
+	if err != nil {
+		log.ErrLog("", fmt.Errorf("获取redis conn失败 key(%s),error(%v)", l.name, err))
+		return nil, err
+	}
+	defer conn.Close()
+	return zRevRangeWithScores(conn, l.key(), offset, offset+limit-1)
+}
+
+// 获取成员名次和分数,成员不存在时返回 redis.ErrNil
+func (l *Leaderboard) Rank(ctx context.Context, member string) (*RankMember, error) {
+	conn, err := l.cache.getConn(ctx)
+	if err != nil {
+		log.ErrLog("", fmt.Errorf("获取redis conn失败 key(%s),error(%v)", l.name, err))
+		return nil, err
+	}
+	defer conn.Close()
+	return zRevRank(conn, l.key(), member)
+}
+
+// 获取成员前后各n名(包含成员本身),成员不存在时返回 redis.ErrNil
+func (l *Leaderboard) Around(ctx context.Context, member string, n int64) ([]RankMember, error) {
+	conn, err := l.cache.getConn(ctx)
+	if err != nil {
+		log.ErrLog("", fmt.Errorf("获取redis conn失败 key(%s),error(%v)", l.name, err))
+		return nil, err
+	}
+	defer conn.Close()
+	key := l.key()
+	m, err := zRevRank(conn, key, member)
+	if err != nil {
+		return nil, err
+	}
+	start := m.Rank - 1 - n
+	if start < 0 {
+		start = 0
+	}
+	return zRevRangeWithScores(conn, key, start, m.Rank-1+n)
+}
+
+// 获取成员数量
+func (l *Leaderboard) Count(ctx context.Context) (int64, error) {
+	conn, err := l.cache.getConn(ctx)
+	if err != nil {
+		log.ErrLog("", fmt.Errorf("获取redis conn失败 key(%s),error(%v)", l.name, err))
+		return 0, err
+	}
+	defer conn.Close()
+	key := l.key()
+	count, err := redis.Int64(conn.Do("ZCARD", key))
+	if err != nil && err != redis.ErrNil {
+		err = fmt.Errorf("Count conn.Do(ZCARD, %s) error(%v)", key, err)
+		log.ErrLog("", err)
+		return 0, err
+	}
+	return count, nil
+}
+
+// 清空当前周期的排行榜
+func (l *Leaderboard) Reset(ctx context.Context) {
+	conn, err := l.cache.getConn(ctx)
+	if err != nil {
+		log.ErrLog("", fmt.Errorf("获取redis conn失败 key(%s),error(%v)", l.name, err))
+		return
+	}
+	defer conn.Close()
+	delKey(conn, l.key())
+}
@@ -0,0 +1,99 @@
+package redis
+
+import (
+	"context"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/gomodule/redigo/redis"
+	xtime "github.com/thesky9531/lareina/time"
+)
+
+func TestLeaderboardPeriod(t *testing.T) {
+	c := New(&Config{Namespace: "app"})
+	at := time.Date(2021, 8, 18, 13, 45, 0, 0, time.Local)
+	cases := []struct {
+		interval uint32
+		key      string
+		expireAt time.Time
+	}{
+		{0, "app:score", time.Time{}},
+		{xtime.TimeIntervalDay, "app:score_202108180000", time.Date(2021, 8, 20, 0, 0, 0, 0, time.Local)},
+		{xtime.TimeIntervalWeek, "app:score_202108160000", time.Date(2021, 8, 30, 0, 0, 0, 0, time.Local)},
+		{xtime.TimeIntervalMonth, "app:score_202108010000", time.Date(2021, 10, 1, 0, 0, 0, 0, time.Local)},
+	}
+	for _, tc := range cases {
+		l := c.NewLeaderboard("score", tc.interval).At(at)
+		key, expireAt := l.period()
+		if key != tc.key {
+			t.Errorf("interval(%d) key = %s, want %s", tc.interval, key, tc.key)
+		}
+		want := int64(0)
+		if !tc.expireAt.IsZero() {
+			want = tc.expireAt.Unix()
+		}
+		if expireAt != want {
+			t.Errorf("interval(%d) expireAt = %d, want %d", tc.interval, expireAt, want)
+		}
+	}
+}
+
+func TestLeaderboardInvalidInterval(t *testing.T) {
+	c := New(&Config{})
+	key, expireAt := c.NewLeaderboard("score", 100).period()
+	if key != "score" || expireAt != 0 {
+		t.Fatalf("period = %s, %d, want score, 0", key, expireAt)
+	}
+}
+
+func TestLeaderboard(t *testing.T) {
+	c, mr := newTestCache(t, nil)
+	ctx := context.Background()
+	l := c.NewLeaderboard("score", xtime.TimeIntervalDay)
+	for _, m := range []struct {
+		member string
+		delta  float64
+	}{{"a", 10}, {"b", 30}, {"c", 20}, {"a", 15}} {
+		if _, err := l.IncrScore(ctx, m.member, m.delta); err != nil {
+			t.Fatal(err)
+		}
+	}
+	key, expireAt := l.period()
+	if ttl := mr.TTL(key); ttl <= 0 || time.Now().Add(ttl).Unix() > expireAt+1 {
+		t.Fatalf("ttl = %v, want until %d", ttl, expireAt)
+	}
+
+	top, err := l.Top(ctx, 2)
+	want := []RankMember{{"b", 30, 1}, {"a", 25, 2}}
+	if err != nil || !reflect.DeepEqual(top, want) {
+		t.Fatalf("Top = %v, %v, want %v", top, err, want)
+	}
+	if m, err := l.Rank(ctx, "c"); err != nil || m.Rank != 3 || m.Score != 20 {
+		t.Fatalf("Rank = %+v, %v", m, err)
+	}
+	if _, err = l.Rank(ctx, "x"); err != redis.ErrNil {
+		t.Fatalf("missing Rank err = %v, want ErrNil", err)
+	}
+	if around, err := l.Around(ctx, "a", 1); err != nil || len(around) != 3 {
+		t.Fatalf("Around = %v, %v", around, err)
+	}
+	if err = l.Remove(ctx, "b"); err != nil {
+		t.Fatal(err)
+	}
+	if n, err := l.Count(ctx); err != nil || n != 2 {
+		t.Fatalf("Count = %d, %v", n, err)
+	}
+
+	// 过期后排行榜清空
+	mr.FastForward(49 * time.Hour)
+	if n, _ := l.Count(ctx); n != 0 {
+		t.Fatalf("Count after expiry = %d", n)
+	}
+	// 不重置的排行榜不过期
+	forever := c.NewLeaderboard("total", 0)
+	forever.SetScore(ctx, "a", 1)
+	if ttl := mr.TTL("total"); ttl != 0 {
+		t.Fatalf("ttl = %v, want none", ttl)
+	}
+}
@@ -0,0 +1,145 @@
+package redis
+
+import (
+	"container/list"
+	"encoding/json"
+	"regexp"
+	"sync"
+	"time"
+)
+
+// 进程内LRU缓存,redis不可用时作为Query*的本地兜底
+type localCache struct {
+	mu    sync.Mutex
+	size  int
+	ttl   time.Duration
+	ll    *list.List
+	items map[string]*list.Element
+}
+
+type localItem struct {
+	key      string
+	value    interface{}
+	expireAt time.Time
+}
+
+// 复制缓存值,避免调用方修改返回的切片或map影响缓存内容
+func copyLocalValue(v interface{}) interface{} {
+	switch v := v.(type) {
+	case json.RawMessage:
+		return append(json.RawMessage(nil), v...)
+	case []byte:
+		return append([]byte(nil), v...)
+	case []int64:
+		return append([]int64(nil), v...)
+	case []KeyValue:
+		return append([]KeyValue(nil), v...)
+	case map[string][]byte:
+		m := make(map[string][]byte, len(v))
+		for k, b := range v {
+			if b != nil {
+				b = append([]byte{}, b...)
+			}
+			m[k] = b
+		}
+		return m
+	}
+	return v
+}
+
+func newLocalCache(size int, ttl time.Duration) *localCache {
+	if size <= 0 {
+		return nil
+	}
+	if ttl <= 0 {
+		ttl = time.Minute
+	}
+	return &localCache{
+		size:  size,
+		ttl:   ttl,
+		ll:    list.New(),
+		items: make(map[string]*list.Element),
+	}
+}
+
+func (l *localCache) get(key string) (interface{}, bool) {
+	if l == nil {
+		return nil, false
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	e, ok := l.items[key]
+	if !ok {
+		return nil, false
+	}
+	item := e.Value.(*localItem)
+	if time.Now().After(item.expireAt) {
+		l.ll.Remove(e)
+		delete(l.items, key)
+		return nil, false
+	}
+	l.ll.MoveToFront(e)
+	return copyLocalValue(item.value), true
+}
+
+func (l *localCache) set(key string, value interface{}) {
+	if l == nil {
+		return
+	}
+	value = copyLocalValue(value)
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	expireAt := time.Now().Add(l.ttl)
+	if e, ok := l.items[key]; ok {
+		item := e.Value.(*localItem)
+		item.value, item.expireAt = value, expireAt
+		l.ll.MoveToFront(e)
+		return
+	}
+	l.items[key] = l.ll.PushFront(&localItem{key: key, value: value, expireAt: expireAt})
+	for l.ll.Len() > l.size {
+		e := l.ll.Back()
+		l.ll.Remove(e)
+		delete(l.items, e.Value.(*localItem).key)
+	}
+}
+
+func (l *localCache) del(key string) {
+	if l == nil {
+		return
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if e, ok := l.items[key]; ok {
+		l.ll.Remove(e)
+		delete(l.items, key)
+	}
+}
+
+// 删除key匹配正则的缓存
+func (l *localCache) delMatch(re *regexp.Regexp) {
+	if l == nil {
+		return
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	for k, e := range l.items {
+		if re.MatchString(k) {
+			l.ll.Remove(e)
+			delete(l.items, k)
+		}
+	}
+}
+
+// 本地缓存命中时直接返回,否则调用fn并缓存结果
+func (l *localCache) load(key string, fn func() (interface{}, error)) (interface{}, error) {
+	if v, ok := l.get(key); ok {
+		return v, nil
+	}
+	v, err := fn()
+	if err != nil {
+		return v, err
+	}
+	l.set(key, v)
+	return v, nil
+}
@@ -0,0 +1,35 @@
+package redis
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+)
+
+func TestLocalCacheCopy(t *testing.T) {
+	l := newLocalCache(10, time.Minute)
+	ids := []int64{1, 2}
+	hash := map[string][]byte{"name": []byte("a"), "nick": nil}
+	l.set("ids", ids)
+	l.set("hash", hash)
+	l.set("raw", json.RawMessage(`1`))
+	// 修改写入和读取的值都不影响缓存
+	ids[0] = 9
+	hash["name"][0] = 'x'
+	v, _ := l.get("ids")
+	v.([]int64)[1] = 9
+	h, _ := l.get("hash")
+	h.(map[string][]byte)["name"] = []byte("y")
+	r, _ := l.get("raw")
+	r.(json.RawMessage)[0] = '2'
+
+	if v, _ = l.get("ids"); v.([]int64)[0] != 1 || v.([]int64)[1] != 2 {
+		t.Errorf("ids = %v", v)
+	}
+	if h, _ = l.get("hash"); string(h.(map[string][]byte)["name"]) != "a" || h.(map[string][]byte)["nick"] != nil {
+		t.Errorf("hash = %v", h)
+	}
+	if r, _ = l.get("raw"); string(r.(json.RawMessage)) != "1" {
+		t.Errorf("raw = %s", r)
+	}
+}
@@ -0,0 +1,901 @@
+package redis
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gomodule/redigo/redis"
+	"github.com/satori/go.uuid"
+)
+
+// 空列表哨兵,与redis实现一致
+const emptySentinel = "emptylist"
+
+var (
+	errWrongType  = redis.Error("WRONGTYPE Operation against a key holding the wrong kind of value")
+	errNotInteger = redis.Error("ERR hash value is not an integer")
+)
+
+const (
+	memString = iota + 1
+	memHash
+	memZSet
+	memSet
+	memList
+)
+
+type memEntry struct {
+	kind     int
+	str      []byte
+	hash     map[string][]byte
+	zset     map[string]float64
+	set      map[string]struct{}
+	list     []int64
+	expireAt time.Time // 零值为不过期
+}
+
+type zMember struct {
+	member string
+	score  float64
+}
+
+// MemoryStore 内存实现的 Store,过期时间、哨兵、hash、zset和锁的行为与redis实现一致,
+// 用于单元测试和单机开发。expireTime小于等于0时key不过期。
+type MemoryStore struct {
+	mu         sync.Mutex
+	expireTime int
+	data       map[string]*memEntry
+	tags       map[string]map[string]struct{}
+}
+
+func NewMemoryStore(expireTime int) *MemoryStore {
+	return &MemoryStore{
+		expireTime: expireTime,
+		data:       make(map[string]*memEntry),
+		tags:       make(map[string]map[string]struct{}),
+	}
+}
+
+func expireAt(expireTime int) time.Time {
+	if expireTime <= 0 {
+		return time.Time{}
+	}
+	return time.Now().Add(time.Duration(expireTime) * time.Second)
+}
+
+// 以下小写方法调用时需持有锁
+
+func (m *MemoryStore) get(key string) *memEntry {
+	e, ok := m.data[key]
+	if !ok {
+		return nil
+	}
+	if !e.expireAt.IsZero() && !time.Now().Before(e.expireAt) {
+		delete(m.data, key)
+		return nil
+	}
+	return e
+}
+
+// 与 EXPIRE 一致,key存在时续期
+func (m *MemoryStore) touch(key string, expireTime int) *memEntry {
+	e := m.get(key)
+	if e != nil {
+		e.expireAt = expireAt(expireTime)
+	}
+	return e
+}
+
+func (m *MemoryStore) put(key string, e *memEntry, expireTime int) {
+	e.expireAt = expireAt(expireTime)
+	m.data[key] = e
+}
+
+func (m *MemoryStore) putString(key string, data []byte, expireTime int) {
+	m.put(key, &memEntry{kind: memString, str: append([]byte(nil), data...)}, expireTime)
+}
+
+func (m *MemoryStore) putSentinel(key string) {
+	m.putString(key, []byte(emptySentinel), m.expireTime)
+}
+
+func (m *MemoryStore) del(key string) bool {
+	if m.get(key) == nil {
+		return false
+	}
+	delete(m.data, key)
+	return true
+}
+
+func (m *MemoryStore) addTags(key string, tags []string) {
+	for _, tag := range tags {
+		keys, ok := m.tags[tag]
+		if !ok {
+			keys = make(map[string]struct{})
+			m.tags[tag] = keys
+		}
+		keys[key] = struct{}{}
+	}
+}
+
+// redis的glob模式转换为正则
+func globRegexp(pattern string) (*regexp.Regexp, error) {
+	var b strings.Builder
+	b.WriteString("^")
+	inClass := false
+	for i := 0; i < len(pattern); i++ {
+		ch := pattern[i]
+		switch {
+		case ch == '\\' && i+1 < len(pattern):
+			i++
+			b.WriteString(regexp.QuoteMeta(string(pattern[i])))
+		case inClass:
+			if ch == ']' {
+				inClass = false
+			}
+			if ch == '^' && pattern[i-1] == '[' {
+				b.WriteByte('^')
+				continue
+			}
+			b.WriteByte(ch)
+		case ch == '*':
+			b.WriteString(".*")
+		case ch == '?':
+			b.WriteString(".")
+		case ch == '[':
+			inClass = true
+			b.WriteByte(ch)
+		default:
+			b.WriteString(regexp.QuoteMeta(string(ch)))
+		}
+	}
+	b.WriteString("$")
+	return regexp.Compile(b.String())
+}
+
+func (m *MemoryStore) matchKeys(pattern string) ([]string, error) {
+	re, err := globRegexp(pattern)
+	if err != nil {
+		return nil, err
+	}
+	keys := make([]string, 0)
+	for k := range m.data {
+		if m.get(k) != nil && re.MatchString(k) {
+			keys = append(keys, k)
+		}
+	}
+	sort.Strings(keys)
+	return keys, nil
+}
+
+func (m *MemoryStore) Ping(ctx context.Context) error {
+	return nil
+}
+
+func (m *MemoryStore) DelKey(ctx context.Context, key string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.del(key)
+}
+
+func (m *MemoryStore) DelMultiKey(ctx context.Context, keys ...string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for _, key := range keys {
+		m.del(key)
+	}
+}
+
+func (m *MemoryStore) RegexpDelKey(ctx context.Context, key string) {
+	m.RegexpDelMultiKey(ctx, key)
+}
+
+func (m *MemoryStore) RegexpDelMultiKey(ctx context.Context, keys ...string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for _, pattern := range keys {
+		matched, _ := m.matchKeys(pattern)
+		for _, k := range matched {
+			m.del(k)
+		}
+	}
+}
+
+func (m *MemoryStore) GetRegexpKeys(ctx context.Context, key string) ([]string, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.matchKeys(key)
+}
+
+func (m *MemoryStore) getString(key string, expireTime int) ([]byte, error) {
+	e := m.touch(key, expireTime)
+	if e == nil {
+		return nil, redis.ErrNil
+	}
+	if e.kind != memString {
+		return nil, errWrongType
+	}
+	return append([]byte(nil), e.str...), nil
+}
+
+func (m *MemoryStore) GetRawMessage(ctx context.Context, key string) (json.RawMessage, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.getString(key, m.expireTime)
+}
+
+func (m *MemoryStore) SetRawMessage(ctx context.Context, key string, data json.RawMessage, tags ...string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.putString(key, data, m.expireTime)
+	m.addTags(key, tags)
+	return nil
+}
+
+func (m *MemoryStore) GetObject(ctx context.Context, key string, obj interface{}) error {
+	data, err := m.GetRawMessage(ctx, key)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(data, &obj)
+}
+
+func (m *MemoryStore) SetObject(ctx context.Context, key string, obj interface{}, tags ...string) error {
+	data, err := json.Marshal(obj)
+	if err != nil {
+		return err
+	}
+	return m.SetRawMessage(ctx, key, data, tags...)
+}
+
+func (m *MemoryStore) GetInt64(ctx context.Context, key string) (int64, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	data, err := m.getString(key, m.expireTime)
+	if err != nil {
+		return 0, err
+	}
+	return redis.Int64(data, nil)
+}
+
+func (m *MemoryStore) SetInt64(ctx context.Context, key string, data int64, tags ...string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.putString(key, strconv.AppendInt(nil, data, 10), m.expireTime)
+	m.addTags(key, tags)
+	return nil
+}
+
+func (m *MemoryStore) GetExpireTimeKey(ctx context.Context, key string, expireTime int) (string, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	data, err := m.getString(key, expireTime)
+	return string(data), err
+}
+
+func (m *MemoryStore) SetExpireTimeKey(ctx context.Context, key string, value string, expireTime int) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.putString(key, []byte(value), expireTime)
+	return nil
+}
+
+// 取hash,key不存在时新建,类型不对时返回WRONGTYPE
+func (m *MemoryStore) hashEntry(key string) (*memEntry, error) {
+	e := m.get(key)
+	if e == nil {
+		e = &memEntry{kind: memHash, hash: make(map[string][]byte)}
+		m.data[key] = e
+	}
+	if e.kind != memHash {
+		return nil, errWrongType
+	}
+	return e, nil
+}
+
+func (m *MemoryStore) GetHashObject(ctx context.Context, key string, fields []string, obj interface{}) error {
+	m.mu.Lock()
+	e := m.touch(key, m.expireTime)
+	if e == nil {
+		m.mu.Unlock()
+		return redis.ErrNil
+	}
+	data := make(map[string][]byte)
+	// 哨兵等非hash类型按空对象处理
+	if e.kind == memHash {
+		for _, f := range fields {
+			data[f] = e.hash[f]
+		}
+	}
+	m.mu.Unlock()
+	return unmarshalRedisObj(data, reflect.ValueOf(obj))
+}
+
+// 与 setKeyHash 一致:没有字段时写入哨兵,对象中被省略的字段从hash中删除
+func (m *MemoryStore) setHash(key string, fields []string, data map[string][]byte) error {
+	present := 0
+	for _, f := range fields {
+		v, ok := data[f]
+		if !ok {
+			return fmt.Errorf("setHash unsupported field(%v), key(%s)", f, key)
+		}
+		if v != nil {
+			present++
+		}
+	}
+	if present == 0 {
+		m.putSentinel(key)
+		return nil
+	}
+	e, err := m.hashEntry(key)
+	if err != nil {
+		return err
+	}
+	for _, f := range fields {
+		if v := data[f]; v != nil {
+			e.hash[f] = append([]byte(nil), v...)
+		} else {
+			delete(e.hash, f)
+		}
+	}
+	e.expireAt = expireAt(m.expireTime)
+	return nil
+}
+
+func (m *MemoryStore) SetHashObject(ctx context.Context, key string, fields []string, obj interface{}, tags ...string) error {
+	data, err := marshalRedisObj(reflect.ValueOf(obj))
+	if err != nil {
+		return err
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if err = m.setHash(key, fields, data); err != nil {
+		return err
+	}
+	m.addTags(key, tags)
+	return nil
+}
+
+func (m *MemoryStore) UpdateHashObject(ctx context.Context, key string, prev, cur interface{}) (bool, error) {
+	changed, removed, err := diffHash(reflect.ValueOf(prev), reflect.ValueOf(cur))
+	if err != nil {
+		return false, err
+	}
+	if len(changed) == 0 && len(removed) == 0 {
+		return false, nil
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	e := m.get(key)
+	if e == nil || e.kind != memHash {
+		return false, nil
+	}
+	for f, v := range changed {
+		e.hash[f] = v
+	}
+	for _, f := range removed {
+		delete(e.hash, f)
+	}
+	e.expireAt = expireAt(m.expireTime)
+	return true, nil
+}
+
+func (m *MemoryStore) HReset(ctx context.Context, key string, field string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	e, err := m.hashEntry(key)
+	if err != nil {
+		return err
+	}
+	e.hash[field] = []byte("0")
+	e.expireAt = expireAt(m.expireTime)
+	return nil
+}
+
+func (m *MemoryStore) HIncrBy(ctx context.Context, key string, field string, num int64) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	e, err := m.hashEntry(key)
+	if err != nil {
+		return err
+	}
+	var n int64
+	if v, ok := e.hash[field]; ok {
+		if n, err = strconv.ParseInt(string(v), 10, 64); err != nil {
+			return errNotInteger
+		}
+	}
+	e.hash[field] = strconv.AppendInt(nil, n+num, 10)
+	e.expireAt = expireAt(m.expireTime)
+	return nil
+}
+
+func (m *MemoryStore) HGetNum(ctx context.Context, key string, field string) (int64, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	e := m.touch(key, m.expireTime)
+	if e == nil || e.kind != memHash {
+		return 0, nil
+	}
+	v, ok := e.hash[field]
+	if !ok {
+		return 0, nil
+	}
+	return redis.Int64(v, nil)
+}
+
+func (m *MemoryStore) HDel(ctx context.Context, key string, fields ...string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	e := m.get(key)
+	if e == nil {
+		return nil
+	}
+	if e.kind != memHash {
+		return errWrongType
+	}
+	for _, f := range fields {
+		delete(e.hash, f)
+	}
+	if len(e.hash) == 0 {
+		delete(m.data, key)
+	}
+	return nil
+}
+
+// 按分数升序排列,分数相同时按成员排序,与redis一致
+func sortedZMembers(e *memEntry) []zMember {
+	list := make([]zMember, 0, len(e.zset))
+	for member, score := range e.zset {
+		list = append(list, zMember{member: member, score: score})
+	}
+	sort.Slice(list, func(i, j int) bool {
+		if list[i].score != list[j].score {
+			return list[i].score < list[j].score
+		}
+		return list[i].member < list[j].member
+	})
+	return list
+}
+
+func reverseZMembers(list []zMember) []zMember {
+	for i, j := 0, len(list)-1; i < j; i, j = i+1, j-1 {
+		list[i], list[j] = list[j], list[i]
+	}
+	return list
+}
+
+func pageZMembers(list []zMember, offset, limit int64) []zMember {
+	if offset < 0 {
+		offset = 0
+	}
+	if offset >= int64(len(list)) {
+		return nil
+	}
+	list = list[offset:]
+	if limit > 0 && limit < int64(len(list)) {
+		list = list[:limit]
+	}
+	return list
+}
+
+// 查询zset成员,key不存在返回 redis.ErrNil,哨兵返回空列表
+func (m *MemoryStore) queryZSet(key string, fn func([]zMember) []zMember) ([]int64, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	e := m.touch(key, m.expireTime)
+	if e == nil {
+		return nil, redis.ErrNil
+	}
+	if e.kind != memZSet {
+		return []int64{}, nil
+	}
+	list := fn(sortedZMembers(e))
+	values := make([]string, 0, len(list))
+	for _, v := range list {
+		values = append(values, v.member)
+	}
+	return parseIds(key, values)
+}
+
+func (m *MemoryStore) setZSet(key string, list []ScoredId) {
+	if len(list) == 0 {
+		m.putSentinel(key)
+		return
+	}
+	e := &memEntry{kind: memZSet, zset: make(map[string]float64, len(list))}
+	for _, v := range list {
+		e.zset[strconv.FormatInt(v.Id, 10)] = v.Score
+	}
+	m.put(key, e, m.expireTime)
+}
+
+func (m *MemoryStore) GetIdSet(ctx context.Context, key string) ([]int64, error) {
+	return m.queryZSet(key, func(list []zMember) []zMember { return list })
+}
+
+func (m *MemoryStore) SetIdSet(ctx context.Context, key string, list []int64, tags ...string) error {
+	scored := make([]ScoredId, 0, len(list))
+	for i, id := range list {
+		scored = append(scored, ScoredId{Id: id, Score: float64(i)})
+	}
+	return m.SetScoredIdSet(ctx, key, scored, tags...)
+}
+
+func (m *MemoryStore) SetScoredIdSet(ctx context.Context, key string, list []ScoredId, tags ...string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.setZSet(key, list)
+	m.addTags(key, tags)
+	return nil
+}
+
+func (m *MemoryStore) GetIdSetPage(ctx context.Context, key string, offset, limit int64) ([]int64, error) {
+	return m.queryZSet(key, func(list []zMember) []zMember {
+		return pageZMembers(list, offset, limit)
+	})
+}
+
+func (m *MemoryStore) GetIdSetPageRev(ctx context.Context, key string, offset, limit int64) ([]int64, error) {
+	return m.queryZSet(key, func(list []zMember) []zMember {
+		return pageZMembers(reverseZMembers(list), offset, limit)
+	})
+}
+
+func filterZMembers(list []zMember, min, max float64) []zMember {
+	out := make([]zMember, 0, len(list))
+	for _, v := range list {
+		if v.score >= min && v.score <= max {
+			out = append(out, v)
+		}
+	}
+	return out
+}
+
+func (m *MemoryStore) GetIdSetByScore(ctx context.Context, key string, min, max float64, offset, limit int64) ([]int64, error) {
+	return m.queryZSet(key, func(list []zMember) []zMember {
+		list = filterZMembers(list, min, max)
+		if limit <= 0 {
+			return list
+		}
+		return pageZMembers(list, offset, limit)
+	})
+}
+
+func (m *MemoryStore) GetIdSetByScoreRev(ctx context.Context, key string, min, max float64, offset, limit int64) ([]int64, error) {
+	return m.queryZSet(key, func(list []zMember) []zMember {
+		list = reverseZMembers(filterZMembers(list, min, max))
+		if limit <= 0 {
+			return list
+		}
+		return pageZMembers(list, offset, limit)
+	})
+}
+
+func (m *MemoryStore) GetIdSetCount(ctx context.Context, key string) (int64, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	e := m.touch(key, m.expireTime)
+	if e == nil {
+		return 0, redis.ErrNil
+	}
+	if e.kind != memZSet {
+		return 0, nil
+	}
+	return int64(len(e.zset)), nil
+}
+
+func (m *MemoryStore) AddToIdSet(ctx context.Context, key string, list ...ScoredId) error {
+	if len(list) == 0 {
+		return nil
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	e := m.get(key)
+	if e == nil {
+		return nil
+	}
+	if e.kind == memString {
+		e = &memEntry{kind: memZSet, zset: make(map[string]float64)}
+		m.data[key] = e
+	}
+	if e.kind != memZSet {
+		return errWrongType
+	}
+	for _, v := range list {
+		e.zset[strconv.FormatInt(v.Id, 10)] = v.Score
+	}
+	e.expireAt = expireAt(m.expireTime)
+	return nil
+}
+
+func (m *MemoryStore) RemoveFromIdSet(ctx context.Context, key string, ids ...int64) error {
+	if len(ids) == 0 {
+		return nil
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	e := m.get(key)
+	if e == nil || e.kind != memZSet {
+		return nil
+	}
+	for _, id := range ids {
+		delete(e.zset, strconv.FormatInt(id, 10))
+	}
+	if len(e.zset) == 0 {
+		m.putSentinel(key)
+		return nil
+	}
+	e.expireAt = expireAt(m.expireTime)
+	return nil
+}
+
+func (m *MemoryStore) GetNameList(ctx context.Context, listKey string) ([]KeyValue, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	e := m.touch(listKey, m.expireTime)
+	if e == nil {
+		return nil, redis.ErrNil
+	}
+	rsp := make([]KeyValue, 0)
+	if e.kind != memHash {
+		return rsp, nil
+	}
+	for k, v := range e.hash {
+		rsp = append(rsp, KeyValue{Key: k, Value: string(v)})
+	}
+	sort.Slice(rsp, func(i, j int) bool { return rsp[i].Key < rsp[j].Key })
+	return rsp, nil
+}
+
+func (m *MemoryStore) SetNameList(ctx context.Context, listKey string, list []KeyValue, tags ...string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if len(list) == 0 {
+		m.putSentinel(listKey)
+	} else {
+		e := &memEntry{kind: memHash, hash: make(map[string][]byte, len(list))}
+		for _, kv := range list {
+			e.hash[kv.Key] = []byte(kv.Value)
+		}
+		m.put(listKey, e, m.expireTime)
+	}
+	m.addTags(listKey, tags)
+	return nil
+}
+
+func (m *MemoryStore) SetSetID(ctx context.Context, key string, id int64) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	e := m.get(key)
+	if e == nil {
+		e = &memEntry{kind: memSet, set: make(map[string]struct{})}
+		m.data[key] = e
+	}
+	if e.kind != memSet {
+		return errWrongType
+	}
+	e.set[strconv.FormatInt(id, 10)] = struct{}{}
+	return nil
+}
+
+func (m *MemoryStore) SetKeyInt64List(ctx context.Context, listKey string, list int64) error {
+	return m.SetInt64(ctx, listKey, list)
+}
+
+func (m *MemoryStore) GetKeyInt64List(ctx context.Context, key string) (int64, error) {
+	return m.GetInt64(ctx, key)
+}
+
+// 与 rpushTrim 一致,右侧写入并只保留最新的maxLen条,不设置过期时间
+func (m *MemoryStore) rpushTrim(key string, value int64, maxLen int) error {
+	e := m.get(key)
+	if e == nil {
+		e = &memEntry{kind: memList}
+		m.data[key] = e
+	}
+	if e.kind != memList {
+		return errWrongType
+	}
+	e.list = append(e.list, value)
+	if n := len(e.list); n > maxLen {
+		e.list = append([]int64(nil), e.list[n-maxLen:]...)
+	}
+	return nil
+}
+
+func (m *MemoryStore) RPushOnlineCount(ctx context.Context, key string, count int64) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.rpushTrim(key, count, 10)
+}
+
+func (m *MemoryStore) GetLenOnlineCount(ctx context.Context, key string) ([]int64, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	e := m.get(key)
+	if e == nil {
+		return []int64{}, nil
+	}
+	if e.kind != memList {
+		return nil, errWrongType
+	}
+	return append([]int64{}, e.list...), nil
+}
+
+func (m *MemoryStore) RPushList(ctx context.Context, key string, id int64) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.rpushTrim(key, id, 10)
+}
+
+func (m *MemoryStore) GetSetCount(ctx context.Context, key string) (int64, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	e := m.get(key)
+	if e == nil {
+		return 0, nil
+	}
+	if e.kind != memSet {
+		return 0, errWrongType
+	}
+	return int64(len(e.set)), nil
+}
+
+func (m *MemoryStore) TagKey(ctx context.Context, key string, tags ...string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.addTags(key, tags)
+	return nil
+}
+
+func (m *MemoryStore) InvalidateTags(ctx context.Context, tags ...string) (int64, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	var n int64
+	for _, tag := range tags {
+		for key := range m.tags[tag] {
+			if m.del(key) {
+				n++
+			}
+		}
+		delete(m.tags, tag)
+	}
+	return n, nil
+}
+
+// 锁与redis实现一样保存在 redislock_ 前缀的key中,lockEx秒后自动过期
+func (m *MemoryStore) Lock(ctx context.Context, key string) *Lock {
+	redisKey := getLockKey(key)
+	token := uuid.NewV4()
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		default:
+		}
+		m.mu.Lock()
+		if m.get(redisKey) == nil {
+			m.putString(redisKey, []byte(token.String()), lockEx)
+			m.mu.Unlock()
+			return &Lock{key: redisKey, token: token}
+		}
+		m.mu.Unlock()
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func (m *MemoryStore) Unlock(ctx context.Context, l *Lock) {
+	if l == nil {
+		return
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if e := m.get(l.key); e != nil && e.kind == memString && string(e.str) == l.token.String() {
+		delete(m.data, l.key)
+	}
+}
+
+func (m *MemoryStore) QueryRawMessage(ctx context.Context, key string,
+	update func() (json.RawMessage, error), opts ...QueryOption) (json.RawMessage, error) {
+	rsp, err := m.GetRawMessage(ctx, key)
+	if err == nil {
+		return rsp, nil
+	}
+	o := newQueryOptions(opts)
+	if err = queryAllowed(ctx, o); err != nil {
+		return nil, err
+	}
+	lock := m.Lock(ctx, key)
+	defer m.Unlock(ctx, lock)
+	if rsp, err = m.GetRawMessage(ctx, key); err == nil {
+		return rsp, nil
+	}
+	if rsp, err = update(); err != nil {
+		return rsp, err
+	}
+	m.SetRawMessage(ctx, key, rsp, o.tags...)
+	return rsp, nil
+}
+
+func (m *MemoryStore) QueryHashObject(ctx context.Context, key string, fields []string, obj interface{},
+	update func() ([]string, interface{}, error), opts ...QueryOption) error {
+	if err := m.GetHashObject(ctx, key, fields, obj); err == nil {
+		return nil
+	}
+	o := newQueryOptions(opts)
+	if err := queryAllowed(ctx, o); err != nil {
+		return err
+	}
+	lock := m.Lock(ctx, key)
+	defer m.Unlock(ctx, lock)
+	if err := m.GetHashObject(ctx, key, fields, obj); err == nil {
+		return nil
+	}
+	fields, uptData, err := update()
+	if err != nil {
+		return err
+	}
+	data, err := marshalRedisObj(reflect.ValueOf(uptData))
+	if err != nil {
+		return err
+	}
+	m.mu.Lock()
+	if err = m.setHash(key, fields, data); err == nil {
+		m.addTags(key, o.tags)
+	}
+	m.mu.Unlock()
+	return unmarshalRedisObj(data, reflect.ValueOf(obj))
+}
+
+func (m *MemoryStore) QueryIdSet(ctx context.Context, key string,
+	update func() ([]int64, error), opts ...QueryOption) ([]int64, error) {
+	rsp, err := m.GetIdSet(ctx, key)
+	if err == nil {
+		return rsp, nil
+	}
+	o := newQueryOptions(opts)
+	if err = queryAllowed(ctx, o); err != nil {
+		return nil, err
+	}
+	lock := m.Lock(ctx, key)
+	defer m.Unlock(ctx, lock)
+	if rsp, err = m.GetIdSet(ctx, key); err == nil {
+		return rsp, nil
+	}
+	if rsp, err = update(); err != nil {
+		return rsp, err
+	}
+	m.SetIdSet(ctx, key, rsp, o.tags...)
+	return rsp, nil
+}
+
+func (m *MemoryStore) QueryNameList(ctx context.Context, listKey string,
+	update func() ([]KeyValue, error), opts ...QueryOption) ([]KeyValue, error) {
+	rsp, err := m.GetNameList(ctx, listKey)
+	if err == nil {
+		return rsp, nil
+	}
+	o := newQueryOptions(opts)
+	if err = queryAllowed(ctx, o); err != nil {
+		return nil, err
+	}
+	lock := m.Lock(ctx, listKey)
+	defer m.Unlock(ctx, lock)
+	if rsp, err = m.GetNameList(ctx, listKey); err == nil {
+		return rsp, nil
+	}
+	if rsp, err = update(); err != nil {
+		return rsp, err
+	}
+	m.SetNameList(ctx, listKey, rsp, o.tags...)
+	return rsp, nil
+}
@@ -0,0 +1,214 @@
+package redis
+
+import (
+	"context"
+	"encoding/json"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/gomodule/redigo/redis"
+)
+
+func TestMemoryStoreString(t *testing.T) {
+	ctx := context.Background()
+	var s Store = NewMemoryStore(60)
+	if _, err := s.GetRawMessage(ctx, "user_1"); err != redis.ErrNil {
+		t.Fatalf("GetRawMessage miss error = %v", err)
+	}
+	if err := s.SetObject(ctx, "user_1", map[string]int{"id": 1}, "user:1"); err != nil {
+		t.Fatal(err)
+	}
+	var obj map[string]int
+	if err := s.GetObject(ctx, "user_1", &obj); err != nil || obj["id"] != 1 {
+		t.Fatalf("GetObject = %v, %v", obj, err)
+	}
+	if err := s.SetExpireTimeKey(ctx, "code", "1234", 1); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(1100 * time.Millisecond)
+	if _, err := s.GetExpireTimeKey(ctx, "code", 1); err != redis.ErrNil {
+		t.Errorf("expired key error = %v", err)
+	}
+	keys, err := s.GetRegexpKeys(ctx, "user_*")
+	if err != nil || !reflect.DeepEqual(keys, []string{"user_1"}) {
+		t.Errorf("GetRegexpKeys = %v, %v", keys, err)
+	}
+	if n, err := s.InvalidateTags(ctx, "user:1"); err != nil || n != 1 {
+		t.Errorf("InvalidateTags = %d, %v", n, err)
+	}
+	if _, err = s.GetRawMessage(ctx, "user_1"); err != redis.ErrNil {
+		t.Errorf("GetRawMessage after invalidate error = %v", err)
+	}
+}
+
+func TestMemoryStoreHash(t *testing.T) {
+	type user struct {
+		ID   int64  `redis:"id"`
+		Name string `redis:"name,omitempty"`
+	}
+	ctx := context.Background()
+	s := NewMemoryStore(60)
+	fields := []string{"id", "name"}
+	if err := s.SetHashObject(ctx, "user_1", fields, user{ID: 1, Name: "a"}); err != nil {
+		t.Fatal(err)
+	}
+	ok, err := s.UpdateHashObject(ctx, "user_1", user{ID: 1, Name: "a"}, user{ID: 1})
+	if err != nil || !ok {
+		t.Fatalf("UpdateHashObject = %v, %v", ok, err)
+	}
+	var u user
+	if err = s.GetHashObject(ctx, "user_1", fields, &u); err != nil || u != (user{ID: 1}) {
+		t.Errorf("GetHashObject = %+v, %v", u, err)
+	}
+	if err = s.HIncrBy(ctx, "user_1", "id", 2); err != nil {
+		t.Fatal(err)
+	}
+	if n, err := s.HGetNum(ctx, "user_1", "id"); err != nil || n != 3 {
+		t.Errorf("HGetNum = %d, %v", n, err)
+	}
+	// 空对象写入哨兵,读取为空对象
+	if err = s.SetHashObject(ctx, "user_2", nil, user{}); err != nil {
+		t.Fatal(err)
+	}
+	u = user{}
+	if err = s.GetHashObject(ctx, "user_2", fields, &u); err != nil || u != (user{}) {
+		t.Errorf("GetHashObject sentinel = %+v, %v", u, err)
+	}
+}
+
+func TestMemoryStoreIdSet(t *testing.T) {
+	ctx := context.Background()
+	s := NewMemoryStore(60)
+	if err := s.AddToIdSet(ctx, "feed", ScoredId{Id: 1, Score: 1}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.GetIdSet(ctx, "feed"); err != redis.ErrNil {
+		t.Fatalf("AddToIdSet should not create list, error = %v", err)
+	}
+	if err := s.SetIdSet(ctx, "feed", nil); err != nil {
+		t.Fatal(err)
+	}
+	if ids, err := s.GetIdSet(ctx, "feed"); err != nil || len(ids) != 0 {
+		t.Fatalf("GetIdSet sentinel = %v, %v", ids, err)
+	}
+	s.AddToIdSet(ctx, "feed", ScoredId{Id: 3, Score: 30}, ScoredId{Id: 1, Score: 10}, ScoredId{Id: 2, Score: 20})
+	if ids, _ := s.GetIdSetPageRev(ctx, "feed", 0, 2); !reflect.DeepEqual(ids, []int64{3, 2}) {
+		t.Errorf("GetIdSetPageRev = %v", ids)
+	}
+	if ids, _ := s.GetIdSetByScore(ctx, "feed", 15, 40, 1, 1); !reflect.DeepEqual(ids, []int64{3}) {
+		t.Errorf("GetIdSetByScore = %v", ids)
+	}
+	s.RemoveFromIdSet(ctx, "feed", 1, 2, 3)
+	if n, err := s.GetIdSetCount(ctx, "feed"); err != nil || n != 0 {
+		t.Errorf("GetIdSetCount after remove = %d, %v", n, err)
+	}
+}
+
+func TestMemoryStoreQuery(t *testing.T) {
+	ctx := context.Background()
+	s := NewMemoryStore(60)
+	lock := s.Lock(ctx, "user_1")
+	timeout, cancel := context.WithTimeout(ctx, 50*time.Millisecond)
+	defer cancel()
+	if l := s.Lock(timeout, "user_1"); l != nil {
+		t.Fatal("lock should be held")
+	}
+	s.Unlock(ctx, lock)
+	loads := 0
+	update := func() (json.RawMessage, error) {
+		loads++
+		return json.RawMessage(`{"id":1}`), nil
+	}
+	for i := 0; i < 2; i++ {
+		rsp, err := s.QueryRawMessage(ctx, "user_1", update, WithTags("user:1"))
+		if err != nil || string(rsp) != `{"id":1}` {
+			t.Fatalf("QueryRawMessage = %s, %v", rsp, err)
+		}
+	}
+	if loads != 1 {
+		t.Errorf("update called %d times", loads)
+	}
+	s.InvalidateTags(ctx, "user:1")
+	s.QueryRawMessage(ctx, "user_1", update)
+	if loads != 2 {
+		t.Errorf("update after invalidate called %d times", loads)
+	}
+}
+
+func TestStoreList(t *testing.T) {
+	ctx := context.Background()
+	c, _ := newTestCache(t, nil)
+	for name, s := range map[string]Store{"redis": c, "memory": NewMemoryStore(60)} {
+		if list, err := s.GetLenOnlineCount(ctx, "online"); err != nil || len(list) != 0 {
+			t.Errorf("%s empty list = %v, %v", name, list, err)
+		}
+		for i := int64(1); i <= 12; i++ {
+			if err := s.RPushOnlineCount(ctx, "online", i); err != nil {
+				t.Fatal(err)
+			}
+		}
+		// 只保留最新的10条
+		list, err := s.GetLenOnlineCount(ctx, "online")
+		if err != nil || !reflect.DeepEqual(list, []int64{3, 4, 5, 6, 7, 8, 9, 10, 11, 12}) {
+			t.Errorf("%s list = %v, %v", name, list, err)
+		}
+		if err = s.RPushList(ctx, "online", 13); err != nil {
+			t.Fatal(err)
+		}
+		if list, _ = s.GetLenOnlineCount(ctx, "online"); len(list) != 10 || list[9] != 13 {
+			t.Errorf("%s RPushList = %v", name, list)
+		}
+		if err = s.SetKeyInt64List(ctx, "total", 7); err != nil {
+			t.Fatal(err)
+		}
+		if n, err := s.GetKeyInt64List(ctx, "total"); err != nil || n != 7 {
+			t.Errorf("%s GetKeyInt64List = %d, %v", name, n, err)
+		}
+	}
+}
+
+func TestStoreHGetNum(t *testing.T) {
+	ctx := context.Background()
+	c, _ := newTestCache(t, nil)
+	for name, s := range map[string]Store{"redis": c, "memory": NewMemoryStore(60)} {
+		if n, err := s.HGetNum(ctx, "stat", "views"); err != nil || n != 0 {
+			t.Errorf("%s HGetNum missing = %d, %v", name, n, err)
+		}
+		if err := s.HIncrBy(ctx, "stat", "views", 3); err != nil {
+			t.Fatal(err)
+		}
+		if n, err := s.HGetNum(ctx, "stat", "views"); err != nil || n != 3 {
+			t.Errorf("%s HGetNum = %d, %v", name, n, err)
+		}
+		if n, err := s.HGetNum(ctx, "stat", "likes"); err != nil || n != 0 {
+			t.Errorf("%s HGetNum missing field = %d, %v", name, n, err)
+		}
+	}
+}
+
+func TestStoreUpdateHash(t *testing.T) {
+	type user struct {
+		ID   int64  `redis:"id"`
+		Name string `redis:"name,omitempty"`
+	}
+	ctx := context.Background()
+	c, _ := newTestCache(t, nil)
+	fields := []string{"id", "name"}
+	for name, s := range map[string]Store{"redis": c, "memory": NewMemoryStore(60)} {
+		// 未缓存时不更新
+		if ok, err := s.UpdateHashObject(ctx, "user_1", user{ID: 1}, user{ID: 1, Name: "a"}); err != nil || ok {
+			t.Errorf("%s uncached UpdateHashObject = %v, %v", name, ok, err)
+		}
+		if err := s.SetHashObject(ctx, "user_1", fields, user{ID: 1, Name: "a"}); err != nil {
+			t.Fatal(err)
+		}
+		if ok, err := s.UpdateHashObject(ctx, "user_1", user{ID: 1, Name: "a"}, user{ID: 1, Name: "b"}); err != nil || !ok {
+			t.Errorf("%s UpdateHashObject = %v, %v", name, ok, err)
+		}
+		var u user
+		if err := s.GetHashObject(ctx, "user_1", fields, &u); err != nil || u != (user{ID: 1, Name: "b"}) {
+			t.Errorf("%s GetHashObject = %+v, %v", name, u, err)
+		}
+	}
+}
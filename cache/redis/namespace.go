@@ -0,0 +1,136 @@
+package redis
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/gomodule/redigo/redis"
+	"github.com/thesky9531/lareina/log"
+)
+
+// 本地缓存的命名空间版本刷新间隔,其他实例更新版本后最多延迟该时间生效
+const namespaceVersionRefresh = time.Second
+
+// 命名空间版本的本地缓存,由后台goroutine定时刷新,生成key时不访问redis
+type namespace struct {
+	version int64
+}
+
+// 命名空间版本号计数器的key,不受版本影响
+func (c *Cache) versionKey() string {
+	return c.conf.Namespace + ":version"
+}
+
+// 读取本地缓存的命名空间版本
+func (c *Cache) namespaceVersion() int64 {
+	return atomic.LoadInt64(&c.ns.version)
+}
+
+// 从redis加载命名空间版本,加载失败时沿用旧版本
+func (c *Cache) loadNamespaceVersion() {
+	conn, err := c.getConn(context.Background())
+	if err != nil {
+		log.ErrLog("", fmt.Errorf("获取redis conn失败 key(%s),error(%v)", c.versionKey(), err))
+		return
+	}
+	defer conn.Close()
+	v, err := redis.Int64(conn.Do("GET", c.versionKey()))
+	if err != nil && err != redis.ErrNil {
+		log.ErrLog("", fmt.Errorf("loadNamespaceVersion conn.Do(GET, %s) error(%v)", c.versionKey(), err))
+		return
+	}
+	atomic.StoreInt64(&c.ns.version, v)
+}
+
+// 定时刷新命名空间版本,直到Cache关闭
+func (c *Cache) runNamespaceVersion() {
+	t := time.NewTicker(namespaceVersionRefresh)
+	defer t.Stop()
+	for {
+		select {
+		case <-c.closed:
+			return
+		case <-t.C:
+			c.loadNamespaceVersion()
+		}
+	}
+}
+
+// 只加命名空间前缀,用于锁、队列、排行榜等不随版本失效的数据
+func (c *Cache) nsKey(key string) string {
+	if c.conf.Namespace == "" {
+		return key
+	}
+	return c.conf.Namespace + ":" + key
+}
+
+// 缓存key的完整前缀,开启版本时为 "Namespace:v版本号:"
+func (c *Cache) keyPrefix() string {
+	if c.conf.Namespace == "" {
+		return ""
+	}
+	if !c.conf.NamespaceVersion {
+		return c.conf.Namespace + ":"
+	}
+	return fmt.Sprintf("%s:v%d:", c.conf.Namespace, c.namespaceVersion())
+}
+
+// 缓存key加上命名空间和版本前缀
+func (c *Cache) key(key string) string {
+	return c.keyPrefix() + key
+}
+
+// 去掉redis返回key中的命名空间和版本前缀
+func (c *Cache) trimKeys(keys []string) []string {
+	prefix := c.keyPrefix()
+	if prefix == "" {
+		return keys
+	}
+	for i, k := range keys {
+		keys[i] = strings.TrimPrefix(k, prefix)
+	}
+	return keys
+}
+
+// 当前命名空间版本
+func (c *Cache) NamespaceVersion(ctx context.Context) (int64, error) {
+	conn, err := c.getConn(ctx)
+	if err != nil {
+		log.ErrLog("", fmt.Errorf("获取redis conn失败 key(%s),error(%v)", c.versionKey(), err))
+		return 0, err
+	}
+	defer conn.Close()
+	v, err := redis.Int64(conn.Do("GET", c.versionKey()))
+	if err == redis.ErrNil {
+		return 0, nil
+	}
+	if err != nil {
+		err = fmt.Errorf("NamespaceVersion conn.Do(GET, %s) error(%v)", c.versionKey(), err)
+		log.ErrLog("", err)
+	}
+	return v, err
+}
+
+// 增加命名空间版本,旧版本的缓存key不再被访问,由过期时间自动清理,返回新版本号
+func (c *Cache) BumpNamespaceVersion(ctx context.Context) (int64, error) {
+	if c.conf.Namespace == "" || !c.conf.NamespaceVersion {
+		return 0, fmt.Errorf("BumpNamespaceVersion namespace version not enabled")
+	}
+	conn, err := c.getConn(ctx)
+	if err != nil {
+		log.ErrLog("", fmt.Errorf("获取redis conn失败 key(%s),error(%v)", c.versionKey(), err))
+		return 0, err
+	}
+	defer conn.Close()
+	v, err := redis.Int64(conn.Do("INCR", c.versionKey()))
+	if err != nil {
+		err = fmt.Errorf("BumpNamespaceVersion conn.Do(INCR, %s) error(%v)", c.versionKey(), err)
+		log.ErrLog("", err)
+		return 0, err
+	}
+	atomic.StoreInt64(&c.ns.version, v)
+	return v, nil
+}
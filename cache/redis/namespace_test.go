@@ -0,0 +1,46 @@
+package redis
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestNamespaceVersion(t *testing.T) {
+	c, s := newTestCache(t, &Config{Namespace: "app", NamespaceVersion: true, MaxActive: 1, Wait: true})
+	ctx := context.Background()
+	if err := s.Set("app:version", "3"); err != nil {
+		t.Fatal(err)
+	}
+	other := New(&Config{Network: "tcp", Addr: s.Addr(), Namespace: "app", NamespaceVersion: true})
+	defer other.Close()
+	if got := other.key("k"); got != "app:v3:k" {
+		t.Fatalf("key after New = %s", got)
+	}
+
+	// 连接池已耗尽时生成key不能阻塞
+	conn, err := c.getConn(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	done := make(chan string)
+	go func() { done <- c.key("k") }()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("key blocked on exhausted pool")
+	}
+	conn.Close()
+
+	if v, err := c.BumpNamespaceVersion(ctx); err != nil || v != 4 {
+		t.Fatalf("BumpNamespaceVersion = %d, %v", v, err)
+	}
+	if got := c.key("k"); got != "app:v4:k" {
+		t.Fatalf("key after bump = %s", got)
+	}
+	// 其他实例在刷新间隔后生效
+	time.Sleep(namespaceVersionRefresh + 200*time.Millisecond)
+	if got := other.key("k"); got != "app:v4:k" {
+		t.Fatalf("other key after refresh = %s", got)
+	}
+}
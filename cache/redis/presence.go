@@ -0,0 +1,300 @@
+package redis
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/gomodule/redigo/redis"
+	"github.com/thesky9531/lareina/log"
+)
+
+// 更新心跳时间,返回之前不在线(不存在或已超时)的用户
+var presenceHeartbeatScript = RegisterScript("presenceHeartbeat", 1, `
+local online = {}
+for i = 3, #ARGV do
+	local s = redis.call("ZSCORE", KEYS[1], ARGV[i])
+	if not s or tonumber(s) < tonumber(ARGV[2]) then
+		online[#online + 1] = ARGV[i]
+	end
+	redis.call("ZADD", KEYS[1], ARGV[1], ARGV[i])
+end
+return online`)
+
+// 删除心跳超时的用户并返回,多个实例同时回收时每个用户只会被返回一次
+var presenceReapScript = RegisterScript("presenceReap", 1, `
+local ids = redis.call("ZRANGEBYSCORE", KEYS[1], "-inf", "(" .. ARGV[1])
+if #ids > 0 then
+	redis.call("ZREMRANGEBYSCORE", KEYS[1], "-inf", "(" .. ARGV[1])
+end
+return ids`)
+
+// 批量获取心跳时间,不存在时为false
+var presenceScoresScript = RegisterScript("presenceScores", 1, `
+local rsp = {}
+for i = 1, #ARGV do
+	rsp[i] = redis.call("ZSCORE", KEYS[1], ARGV[i])
+end
+return rsp`)
+
+// 上下线事件
+type PresenceEvent struct {
+	UserID int64
+	Online bool
+	Time   time.Time
+}
+
+// 在线状态,使用zset记录每个用户最后心跳的毫秒时间戳,超过TTL未心跳视为离线。
+// 上下线事件通过pub/sub广播,同一用户可能收到重复的上线事件,处理需要幂等
+type Presence struct {
+	cache *Cache
+	name  string
+
+	// 心跳超时时间,默认60秒
+	TTL time.Duration
+	// 回收超时用户的间隔,默认10秒
+	ReapInterval time.Duration
+	// 在线人数历史快照的保留策略,默认保留7天
+	HistoryRetention Retention
+}
+
+func (c *Cache) NewPresence(name string) *Presence {
+	return &Presence{
+		cache:            c,
+		name:             name,
+		TTL:              time.Minute,
+		ReapInterval:     10 * time.Second,
+		HistoryRetention: Retention{MaxAge: 7 * 24 * time.Hour},
+	}
+}
+
+func (p *Presence) key() string {
+	return p.cache.nsKey("presence_" + p.name)
+}
+
+func (p *Presence) historyKey() string {
+	return "presence_" + p.name + "_history"
+}
+
+// 事件频道
+func (p *Presence) Channel() string {
+	return p.cache.nsKey("presence_" + p.name + "_events")
+}
+
+func msTime(t time.Time) int64 {
+	return t.UnixNano() / int64(time.Millisecond)
+}
+
+func (p *Presence) minScore(now time.Time) int64 {
+	return msTime(now.Add(-p.TTL))
+}
+
+// 在同一连接上pipeline广播事件,避免每个事件再占用一个连接
+func (p *Presence) publish(conn redis.Conn, ids []int64, online bool, t time.Time) {
+	if len(ids) == 0 {
+		return
+	}
+	for _, id := range ids {
+		data, err := JSONCodec.Marshal(&PresenceEvent{UserID: id, Online: online, Time: t})
+		if err != nil {
+			log.ErrLog("", fmt.Errorf("mashal obj fail, error(%v)", err))
+			return
+		}
+		if err = conn.Send("PUBLISH", p.Channel(), data); err != nil {
+			log.ErrLog("", fmt.Errorf("publish conn.Send(PUBLISH, %s) error(%v)", p.Channel(), err))
+			return
+		}
+	}
+	if _, err := conn.Do(""); err != nil {
+		log.ErrLog("", fmt.Errorf("publish conn.Do(PUBLISH, %s) error(%v)", p.Channel(), err))
+	}
+}
+
+// 心跳时间不小于min时在线,ZSCORE不存在(nil)时离线
+func presenceOnline(score interface{}, min int64) bool {
+	s, err := redis.Int64(score, nil)
+	return err == nil && s >= min
+}
+
+// 上报心跳,之前不在线的用户广播上线事件
+func (p *Presence) Heartbeat(ctx context.Context, userIDs ...int64) error {
+	if len(userIDs) == 0 {
+		return nil
+	}
+	conn, err := p.cache.getConn(ctx)
+	if err != nil {
+		log.ErrLog("", fmt.Errorf("获取redis conn失败 key(%s),error(%v)", p.name, err))
+		return err
+	}
+	defer conn.Close()
+	now := time.Now()
+	args := make([]interface{}, 0, len(userIDs)+3)
+	args = append(args, p.key(), msTime(now), p.minScore(now))
+	for _, id := range userIDs {
+		args = append(args, id)
+	}
+	values, err := redis.Strings(presenceHeartbeatScript.Do(conn, args...))
+	if err != nil {
+		err = fmt.Errorf("Heartbeat presenceHeartbeatScript.Do(%s, %v) error(%v)", p.key(), userIDs, err)
+		log.ErrLog("", err)
+		return err
+	}
+	ids, err := parseIds(p.key(), values)
+	if err != nil {
+		return err
+	}
+	p.publish(conn, ids, true, now)
+	return nil
+}
+
+// 主动下线,如用户退出登录
+func (p *Presence) Offline(ctx context.Context, userID int64) error {
+	conn, err := p.cache.getConn(ctx)
+	if err != nil {
+		log.ErrLog("", fmt.Errorf("获取redis conn失败 key(%s),error(%v)", p.name, err))
+		return err
+	}
+	defer conn.Close()
+	n, err := redis.Int64(conn.Do("ZREM", p.key(), userID))
+	if err != nil {
+		err = fmt.Errorf("Offline conn.Do(ZREM, %s, %d) error(%v)", p.key(), userID, err)
+		log.ErrLog("", err)
+		return err
+	}
+	if n > 0 {
+		p.publish(conn, []int64{userID}, false, time.Now())
+	}
+	return nil
+}
+
+// 批量查询用户是否在线
+func (p *Presence) IsOnline(ctx context.Context, userIDs ...int64) (map[int64]bool, error) {
+	rsp := make(map[int64]bool, len(userIDs))
+	if len(userIDs) == 0 {
+		return rsp, nil
+	}
+	conn, err := p.cache.getConn(ctx)
+	if err != nil {
+		log.ErrLog("", fmt.Errorf("获取redis conn失败 key(%s),error(%v)", p.name, err))
+		return nil, err
+	}
+	defer conn.Close()
+	args := make([]interface{}, 0, len(userIDs)+1)
+	args = append(args, p.key())
+	for _, id := range userIDs {
+		args = append(args, id)
+	}
+	values, err := redis.Values(presenceScoresScript.Do(conn, args...))
+	if err != nil {
+		err = fmt.Errorf("IsOnline presenceScoresScript.Do(%s, %v) error(%v)", p.key(), userIDs, err)
+		log.ErrLog("", err)
+		return nil, err
+	}
+	min := p.minScore(time.Now())
+	for i, id := range userIDs {
+		rsp[id] = presenceOnline(values[i], min)
+	}
+	return rsp, nil
+}
+
+// 当前在线人数
+func (p *Presence) OnlineCount(ctx context.Context) (int64, error) {
+	conn, err := p.cache.getConn(ctx)
+	if err != nil {
+		log.ErrLog("", fmt.Errorf("获取redis conn失败 key(%s),error(%v)", p.name, err))
+		return 0, err
+	}
+	defer conn.Close()
+	n, err := redis.Int64(conn.Do("ZCOUNT", p.key(), p.minScore(time.Now()), "+inf"))
+	if err != nil {
+		err = fmt.Errorf("OnlineCount conn.Do(ZCOUNT, %s) error(%v)", p.key(), err)
+		log.ErrLog("", err)
+	}
+	return n, err
+}
+
+// 按最近心跳时间倒序分页获取在线用户,offset从0开始
+func (p *Presence) OnlineUsers(ctx context.Context, offset, limit int64) ([]int64, error) {
+	conn, err := p.cache.getConn(ctx)
+	if err != nil {
+		log.ErrLog("", fmt.Errorf("获取redis conn失败 key(%s),error(%v)", p.name, err))
+		return nil, err
+	}
+	defer conn.Close()
+	values, err := redis.Strings(conn.Do("ZREVRANGEBYSCORE", p.key(), "+inf", p.minScore(time.Now()),
+		"LIMIT", offset, limit))
+	if err != nil {
+		err = fmt.Errorf("OnlineUsers conn.Do(ZREVRANGEBYSCORE, %s) error(%v)", p.key(), err)
+		log.ErrLog("", err)
+		return nil, err
+	}
+	return parseIds(p.key(), values)
+}
+
+// 回收心跳超时的用户并广播下线事件,返回下线的用户
+func (p *Presence) Reap(ctx context.Context) ([]int64, error) {
+	conn, err := p.cache.getConn(ctx)
+	if err != nil {
+		log.ErrLog("", fmt.Errorf("获取redis conn失败 key(%s),error(%v)", p.name, err))
+		return nil, err
+	}
+	defer conn.Close()
+	now := time.Now()
+	values, err := redis.Strings(presenceReapScript.Do(conn, p.key(), p.minScore(now)))
+	if err != nil {
+		err = fmt.Errorf("Reap presenceReapScript.Do(%s) error(%v)", p.key(), err)
+		log.ErrLog("", err)
+		return nil, err
+	}
+	ids, err := parseIds(p.key(), values)
+	if err != nil {
+		return nil, err
+	}
+	p.publish(conn, ids, false, now)
+	return ids, nil
+}
+
+// 记录当前分钟的在线人数,多个实例同时运行时每分钟只记录一次
+func (p *Presence) Snapshot(ctx context.Context) error {
+	now := time.Now().Truncate(time.Minute)
+	lock, err := p.cache.TryLock(ctx, p.historyKey()+"_"+strconv.FormatInt(now.Unix(), 10), 120)
+	if err != nil || lock == nil {
+		return err
+	}
+	n, err := p.OnlineCount(ctx)
+	if err != nil {
+		return err
+	}
+	return p.cache.AddSample(ctx, p.historyKey(), Sample{Time: now, Value: float64(n)}, p.HistoryRetention)
+}
+
+// 在线人数历史快照
+func (p *Presence) History(ctx context.Context, start, end time.Time) ([]Sample, error) {
+	return p.cache.RangeSamples(ctx, p.historyKey(), start, end)
+}
+
+// 订阅上下线事件
+func (p *Presence) Subscribe(s *Subscriber, handler func(ctx context.Context, e *PresenceEvent)) error {
+	return s.Subscribe(p.Channel(), handler)
+}
+
+// 定时回收超时用户并每分钟记录在线人数,阻塞直到ctx结束
+func (p *Presence) Run(ctx context.Context) {
+	t := time.NewTicker(p.ReapInterval)
+	defer t.Stop()
+	var last time.Time
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-t.C:
+		}
+		p.Reap(ctx)
+		if minute := time.Now().Truncate(time.Minute); minute.After(last) {
+			if err := p.Snapshot(ctx); err == nil {
+				last = minute
+			}
+		}
+	}
+}
@@ -0,0 +1,99 @@
+package redis
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+	"time"
+)
+
+func TestPresenceMinScore(t *testing.T) {
+	now := time.Unix(1600000000, 500*int64(time.Millisecond))
+	cases := []struct {
+		ttl  time.Duration
+		want int64
+	}{
+		{time.Minute, 1600000000500 - 60000},
+		{1500 * time.Millisecond, 1600000000500 - 1500},
+		{0, 1600000000500},
+	}
+	p := &Presence{}
+	for _, tc := range cases {
+		p.TTL = tc.ttl
+		if got := p.minScore(now); got != tc.want {
+			t.Errorf("minScore(ttl=%v) = %d, want %d", tc.ttl, got, tc.want)
+		}
+	}
+}
+
+func TestPresenceOnline(t *testing.T) {
+	cases := []struct {
+		name  string
+		score interface{}
+		want  bool
+	}{
+		{"missing", nil, false},
+		{"fresh", []byte("1000"), true},
+		{"boundary", []byte("999"), true},
+		{"expired", []byte("998"), false},
+		{"invalid", []byte("x"), false},
+	}
+	for _, tc := range cases {
+		if got := presenceOnline(tc.score, 999); got != tc.want {
+			t.Errorf("%s: presenceOnline = %v, want %v", tc.name, got, tc.want)
+		}
+	}
+}
+
+func TestPresenceEvents(t *testing.T) {
+	c, mr := newTestCache(t, nil)
+	ctx := context.Background()
+	p := c.NewPresence("room")
+	sub := mr.NewSubscriber()
+	defer sub.Close()
+	sub.Subscribe(p.Channel())
+	// miniredis的订阅channel没有缓冲,需要持续读取
+	events := make(chan PresenceEvent, 8)
+	go func() {
+		for m := range sub.Messages() {
+			var e PresenceEvent
+			json.Unmarshal([]byte(m.Message), &e)
+			events <- e
+		}
+	}()
+
+	next := func() PresenceEvent {
+		t.Helper()
+		select {
+		case e := <-events:
+			return e
+		case <-time.After(time.Second):
+			t.Fatal("no event")
+		}
+		return PresenceEvent{}
+	}
+
+	if err := p.Heartbeat(ctx, 1, 2); err != nil {
+		t.Fatal(err)
+	}
+	if e1, e2 := next(), next(); e1.UserID != 1 || e2.UserID != 2 || !e1.Online || !e2.Online {
+		t.Fatalf("online events = %+v %+v", e1, e2)
+	}
+	online, err := p.IsOnline(ctx, 1, 3)
+	if err != nil || !online[1] || online[3] {
+		t.Fatalf("IsOnline = %v, %v", online, err)
+	}
+	if err = p.Offline(ctx, 1); err != nil {
+		t.Fatal(err)
+	}
+	if e := next(); e.UserID != 1 || e.Online {
+		t.Fatalf("offline event = %+v", e)
+	}
+	p.TTL = -time.Minute
+	if ids, err := p.Reap(ctx); err != nil || len(ids) != 1 || ids[0] != 2 {
+		t.Fatalf("Reap = %v, %v", ids, err)
+	}
+	if e := next(); e.UserID != 2 || e.Online {
+		t.Fatalf("reap event = %+v", e)
+	}
+}
@@ -0,0 +1,383 @@
+package redis
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"reflect"
+	"sync"
+	"time"
+
+	"github.com/gomodule/redigo/redis"
+	"github.com/thesky9531/lareina/log"
+)
+
+// 消息编解码
+type Codec interface {
+	Marshal(v interface{}) ([]byte, error)
+	Unmarshal(data []byte, v interface{}) error
+}
+
+type jsonCodec struct{}
+
+func (jsonCodec) Marshal(v interface{}) ([]byte, error) {
+	return json.Marshal(v)
+}
+
+func (jsonCodec) Unmarshal(data []byte, v interface{}) error {
+	return json.Unmarshal(data, v)
+}
+
+// 默认使用json编解码
+var JSONCodec Codec = jsonCodec{}
+
+// 订阅收到的原始消息
+type Message struct {
+	Channel string
+	Pattern string // 通过模式订阅收到时为匹配的模式
+	Data    []byte
+}
+
+var (
+	messageType = reflect.TypeOf((*Message)(nil))
+	contextType = reflect.TypeOf((*context.Context)(nil)).Elem()
+	errorType   = reflect.TypeOf((*error)(nil)).Elem()
+)
+
+// 反射包装的消息处理函数
+type subHandler struct {
+	fn      reflect.Value
+	argType reflect.Type
+	withCtx bool
+}
+
+// 支持的处理函数:
+//
+//	func(T)
+//	func(T) error
+//	func(context.Context, T)
+//	func(context.Context, T) error
+//
+// T为*Message时传入原始消息,否则使用codec解码到T
+func newSubHandler(handler interface{}) (*subHandler, error) {
+	fn := reflect.ValueOf(handler)
+	ft := fn.Type()
+	if ft.Kind() != reflect.Func {
+		return nil, errors.New("subscribe handler must be a func")
+	}
+	h := &subHandler{fn: fn}
+	switch ft.NumIn() {
+	case 1:
+		h.argType = ft.In(0)
+	case 2:
+		if ft.In(0) != contextType {
+			return nil, errors.New("subscribe handler first param must be context.Context")
+		}
+		h.withCtx = true
+		h.argType = ft.In(1)
+	default:
+		return nil, fmt.Errorf("subscribe handler has %d params", ft.NumIn())
+	}
+	if ft.NumOut() > 1 || (ft.NumOut() == 1 && ft.Out(0) != errorType) {
+		return nil, errors.New("subscribe handler must return nothing or error")
+	}
+	return h, nil
+}
+
+func (h *subHandler) call(ctx context.Context, codec Codec, msg *Message) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("subscribe handler channel(%s) panic: %v", msg.Channel, r)
+		}
+	}()
+	var arg reflect.Value
+	if h.argType == messageType {
+		arg = reflect.ValueOf(msg)
+	} else if h.argType.Kind() == reflect.Ptr {
+		arg = reflect.New(h.argType.Elem())
+		if err = codec.Unmarshal(msg.Data, arg.Interface()); err != nil {
+			return fmt.Errorf("decode channel(%s) message error(%v)", msg.Channel, err)
+		}
+	} else {
+		ptr := reflect.New(h.argType)
+		if err = codec.Unmarshal(msg.Data, ptr.Interface()); err != nil {
+			return fmt.Errorf("decode channel(%s) message error(%v)", msg.Channel, err)
+		}
+		arg = ptr.Elem()
+	}
+	in := []reflect.Value{arg}
+	if h.withCtx {
+		in = []reflect.Value{reflect.ValueOf(ctx), arg}
+	}
+	out := h.fn.Call(in)
+	if len(out) == 1 && !out[0].IsNil() {
+		err = out[0].Interface().(error)
+	}
+	return err
+}
+
+// 订阅者,在一个连接上复用多个频道和模式,断线后自动重连并重新订阅
+type Subscriber struct {
+	cache *Cache
+	codec Codec
+
+	// 重连退避时间,默认100毫秒到30秒
+	MinBackoff time.Duration
+	MaxBackoff time.Duration
+	// 连接健康检查间隔,默认30秒
+	PingPeriod time.Duration
+	// 订阅成功(包括重连后重新订阅)时回调
+	OnSubscribe func()
+
+	mu       sync.Mutex
+	conn     *redis.PubSubConn
+	channels map[string]*subHandler
+	patterns map[string]*subHandler
+}
+
+// NewSubscriber 创建订阅者,codec为nil时使用json
+func (c *Cache) NewSubscriber(codec Codec) *Subscriber {
+	if codec == nil {
+		codec = JSONCodec
+	}
+	return &Subscriber{
+		cache:      c,
+		codec:      codec,
+		MinBackoff: 100 * time.Millisecond,
+		MaxBackoff: 30 * time.Second,
+		PingPeriod: 30 * time.Second,
+		channels:   make(map[string]*subHandler),
+		patterns:   make(map[string]*subHandler),
+	}
+}
+
+// 订阅频道,handler格式见 newSubHandler,可以在Run之前或运行中调用
+func (s *Subscriber) Subscribe(channel string, handler interface{}) error {
+	h, err := newSubHandler(handler)
+	if err != nil {
+		log.ErrLog("", err)
+		return err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.channels[channel] = h
+	if s.conn != nil {
+		return s.conn.Subscribe(channel)
+	}
+	return nil
+}
+
+// 按模式订阅,如 news.*
+func (s *Subscriber) PSubscribe(pattern string, handler interface{}) error {
+	h, err := newSubHandler(handler)
+	if err != nil {
+		log.ErrLog("", err)
+		return err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.patterns[pattern] = h
+	if s.conn != nil {
+		return s.conn.PSubscribe(pattern)
+	}
+	return nil
+}
+
+// 取消订阅频道
+func (s *Subscriber) Unsubscribe(channels ...string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	args := make([]interface{}, 0, len(channels))
+	for _, ch := range channels {
+		delete(s.channels, ch)
+		args = append(args, ch)
+	}
+	if s.conn != nil && len(args) > 0 {
+		return s.conn.Unsubscribe(args...)
+	}
+	return nil
+}
+
+// 取消模式订阅
+func (s *Subscriber) PUnsubscribe(patterns ...string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	args := make([]interface{}, 0, len(patterns))
+	for _, p := range patterns {
+		delete(s.patterns, p)
+		args = append(args, p)
+	}
+	if s.conn != nil && len(args) > 0 {
+		return s.conn.PUnsubscribe(args...)
+	}
+	return nil
+}
+
+func (s *Subscriber) handler(msg *Message) *subHandler {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if msg.Pattern != "" {
+		return s.patterns[msg.Pattern]
+	}
+	return s.channels[msg.Channel]
+}
+
+// 建立连接并订阅当前所有频道和模式
+func (s *Subscriber) connect(ctx context.Context) (*redis.PubSubConn, error) {
+	conn, err := s.cache.pool.DialContext(ctx)
+	if err != nil {
+		return nil, err
+	}
+	psc := &redis.PubSubConn{Conn: conn}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	channels := make([]interface{}, 0, len(s.channels))
+	for ch := range s.channels {
+		channels = append(channels, ch)
+	}
+	patterns := make([]interface{}, 0, len(s.patterns))
+	for p := range s.patterns {
+		patterns = append(patterns, p)
+	}
+	if len(channels) > 0 {
+		err = psc.Subscribe(channels...)
+	}
+	if err == nil && len(patterns) > 0 {
+		err = psc.PSubscribe(patterns...)
+	}
+	if err != nil {
+		psc.Close()
+		return nil, err
+	}
+	s.conn = psc
+	return psc, nil
+}
+
+func (s *Subscriber) empty() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return len(s.channels) == 0 && len(s.patterns) == 0
+}
+
+func (s *Subscriber) disconnect(psc *redis.PubSubConn) {
+	s.mu.Lock()
+	if s.conn == psc {
+		s.conn = nil
+	}
+	s.mu.Unlock()
+	psc.Close()
+}
+
+// 在连接上接收消息直到出错或ctx结束
+func (s *Subscriber) receive(ctx context.Context, psc *redis.PubSubConn) error {
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		t := time.NewTicker(s.PingPeriod)
+		defer t.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				s.mu.Lock()
+				psc.Unsubscribe()
+				psc.PUnsubscribe()
+				s.mu.Unlock()
+				return
+			case <-done:
+				return
+			case <-t.C:
+				s.mu.Lock()
+				err := psc.Ping("")
+				s.mu.Unlock()
+				if err != nil {
+					return
+				}
+			}
+		}
+	}()
+	for {
+		switch v := psc.ReceiveWithTimeout(s.PingPeriod * 2).(type) {
+		case error:
+			return v
+		case redis.Message:
+			msg := &Message{Channel: v.Channel, Pattern: v.Pattern, Data: v.Data}
+			h := s.handler(msg)
+			if h == nil {
+				continue
+			}
+			if err := h.call(ctx, s.codec, msg); err != nil {
+				log.ErrLog(fmt.Sprintf("subscribe channel(%s)", msg.Channel), err)
+			}
+		case redis.Subscription:
+			// 全部取消订阅后断开连接,有新的订阅时再重连
+			if v.Count == 0 {
+				return nil
+			}
+		}
+	}
+}
+
+// Run 接收并分发消息,连接失败时按退避时间重连,阻塞直到ctx结束
+func (s *Subscriber) Run(ctx context.Context) {
+	backoff := s.MinBackoff
+	for ctx.Err() == nil {
+		// 没有订阅时不建立连接,等待新的订阅
+		if s.empty() {
+			select {
+			case <-ctx.Done():
+				return
+			case <-time.After(s.MinBackoff):
+			}
+			continue
+		}
+		psc, err := s.connect(ctx)
+		if err == nil {
+			backoff = s.MinBackoff
+			if s.OnSubscribe != nil {
+				s.OnSubscribe()
+			}
+			err = s.receive(ctx, psc)
+			s.disconnect(psc)
+			if err == nil || ctx.Err() != nil {
+				continue
+			}
+		}
+		log.ErrLog(fmt.Sprintf("subscriber reconnect after %v", backoff), err)
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(backoff):
+		}
+		backoff *= 2
+		if backoff > s.MaxBackoff {
+			backoff = s.MaxBackoff
+		}
+	}
+}
+
+// 使用json编码后发布消息,返回收到消息的订阅者数量
+func (c *Cache) Publish(ctx context.Context, channel string, msg interface{}) (int64, error) {
+	data, err := JSONCodec.Marshal(msg)
+	if err != nil {
+		log.ErrLog("", fmt.Errorf("mashal obj fail, error(%v)", err))
+		return 0, err
+	}
+	return c.PublishRaw(ctx, channel, data)
+}
+
+// 发布已编码的消息,返回收到消息的订阅者数量
+func (c *Cache) PublishRaw(ctx context.Context, channel string, data []byte) (int64, error) {
+	conn, err := c.getConn(ctx)
+	if err != nil {
+		log.ErrLog("", fmt.Errorf("获取redis conn失败 key(%s),error(%v)", channel, err))
+		return 0, err
+	}
+	defer conn.Close()
+	n, err := redis.Int64(conn.Do("PUBLISH", channel, data))
+	if err != nil {
+		err = fmt.Errorf("PublishRaw conn.Do(PUBLISH, %s) error(%v)", channel, err)
+		log.ErrLog("", err)
+	}
+	return n, err
+}
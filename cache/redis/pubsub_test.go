@@ -0,0 +1,144 @@
+package redis
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+type pubsubCtxKey struct{}
+
+type pubsubMsg struct {
+	N int `json:"n"`
+}
+
+func TestNewSubHandler(t *testing.T) {
+	cases := []struct {
+		name    string
+		handler interface{}
+		ok      bool
+	}{
+		{"value", func(pubsubMsg) {}, true},
+		{"ptr error", func(*pubsubMsg) error { return nil }, true},
+		{"ctx", func(context.Context, *Message) {}, true},
+		{"ctx error", func(context.Context, pubsubMsg) error { return nil }, true},
+		{"not func", 1, false},
+		{"no params", func() {}, false},
+		{"first not ctx", func(int, int) {}, false},
+		{"bad return", func(int) int { return 0 }, false},
+		{"two returns", func(int) (int, error) { return 0, nil }, false},
+	}
+	for _, tc := range cases {
+		if _, err := newSubHandler(tc.handler); (err == nil) != tc.ok {
+			t.Errorf("%s: err = %v", tc.name, err)
+		}
+	}
+}
+
+func TestSubHandlerCall(t *testing.T) {
+	msg := &Message{Channel: "ch", Data: []byte(`{"n":3}`)}
+	ctx := context.WithValue(context.Background(), pubsubCtxKey{}, "ok")
+	var got []int
+	handlers := []interface{}{
+		func(m pubsubMsg) { got = append(got, m.N) },
+		func(m *pubsubMsg) error { got = append(got, m.N); return nil },
+		func(c context.Context, m *Message) {
+			if c.Value(pubsubCtxKey{}) == "ok" && m == msg {
+				got = append(got, 0)
+			}
+		},
+	}
+	for _, fn := range handlers {
+		h, err := newSubHandler(fn)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err = h.call(ctx, JSONCodec, msg); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if len(got) != 3 || got[0] != 3 || got[1] != 3 || got[2] != 0 {
+		t.Fatalf("got = %v", got)
+	}
+
+	errHandler, _ := newSubHandler(func(pubsubMsg) error { return errors.New("fail") })
+	if err := errHandler.call(ctx, JSONCodec, msg); err == nil || err.Error() != "fail" {
+		t.Errorf("handler error = %v", err)
+	}
+	panicHandler, _ := newSubHandler(func(pubsubMsg) { panic("boom") })
+	if err := panicHandler.call(ctx, JSONCodec, msg); err == nil {
+		t.Error("panic not recovered")
+	}
+	if err := errHandler.call(ctx, JSONCodec, &Message{Channel: "ch", Data: []byte("x")}); err == nil {
+		t.Error("invalid data decoded")
+	}
+}
+
+func TestSubscriber(t *testing.T) {
+	c, _ := newTestCache(t, nil)
+	sub := c.NewSubscriber(nil)
+	subscribed := make(chan struct{}, 4)
+	sub.OnSubscribe = func() { subscribed <- struct{}{} }
+	got := make(chan string, 4)
+	if err := sub.Subscribe("news", func(m pubsubMsg) { got <- "news" }); err != nil {
+		t.Fatal(err)
+	}
+	if err := sub.PSubscribe("sport.*", func(m *Message) { got <- m.Channel }); err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		sub.Run(ctx)
+		close(done)
+	}()
+	defer func() {
+		cancel()
+		<-done
+	}()
+	select {
+	case <-subscribed:
+	case <-time.After(time.Second):
+		t.Fatal("not subscribed")
+	}
+
+	recv := func(want string) {
+		t.Helper()
+		select {
+		case ch := <-got:
+			if ch != want {
+				t.Fatalf("received %q, want %q", ch, want)
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("%q not received", want)
+		}
+	}
+	// OnSubscribe在订阅命令发出后回调,等待服务端处理完成
+	for i := 0; ; i++ {
+		n, err := c.Publish(context.Background(), "news", pubsubMsg{N: 1})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if n == 1 {
+			break
+		}
+		if i == 100 {
+			t.Fatal("news has no subscriber")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	recv("news")
+	if _, err := c.PublishRaw(context.Background(), "sport.ball", []byte(`{}`)); err != nil {
+		t.Fatal(err)
+	}
+	recv("sport.ball")
+
+	if err := sub.Unsubscribe("news"); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(50 * time.Millisecond)
+	if n, _ := c.Publish(context.Background(), "news", pubsubMsg{N: 2}); n != 0 {
+		t.Fatalf("unsubscribed channel received by %d", n)
+	}
+}
@@ -3,13 +3,68 @@ package redis
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"github.com/thesky9531/lareina/log"
 	"reflect"
 )
 
+// bloom过滤器判断数据不存在时Query*返回该错误,不会调用update
+var ErrNotExist = errors.New("redis: item not exist in bloom filter")
+
+type queryOptions struct {
+	bloom     *BloomFilter
+	bloomItem string
+	tags      []string
+}
+
+type QueryOption func(*queryOptions)
+
+// 缓存未命中时先查询bloom过滤器,item不存在则直接返回 ErrNotExist
+func WithBloomFilter(b *BloomFilter, item string) QueryOption {
+	return func(o *queryOptions) {
+		o.bloom = b
+		o.bloomItem = item
+	}
+}
+
+// 从db获取数据写入缓存时给key添加标签
+func WithTags(tags ...string) QueryOption {
+	return func(o *queryOptions) {
+		o.tags = append(o.tags, tags...)
+	}
+}
+
+func newQueryOptions(opts []QueryOption) *queryOptions {
+	o := &queryOptions{}
+	for _, opt := range opts {
+		opt(o)
+	}
+	return o
+}
+
+// 缓存未命中后判断是否需要从db获取,bloom过滤器出错时放行
+func queryAllowed(ctx context.Context, o *queryOptions) error {
+	if o.bloom == nil {
+		return nil
+	}
+	exist, err := o.bloom.Exists(ctx, o.bloomItem)
+	if err == nil && !exist {
+		return ErrNotExist
+	}
+	return nil
+}
+
 func (c *Cache) QueryRawMessage(ctx context.Context, key string,
-	update func() (json.RawMessage, error)) (rsp json.RawMessage, err error) {
+	update func() (json.RawMessage, error), opts ...QueryOption) (rsp json.RawMessage, err error) {
+	//redis熔断时直接从db获取,开启本地缓存时优先使用本地缓存
+	if c.CircuitOpen() {
+		v, err := c.local.load("raw_"+key, func() (interface{}, error) { return update() })
+		if err != nil {
+			return nil, err
+		}
+		return v.(json.RawMessage), nil
+	}
 	//首先判断缓存中有没有
 	//首先从缓存获取
 	rsp, err = c.GetRawMessage(ctx, key)
@@ -17,6 +72,10 @@ func (c *Cache) QueryRawMessage(ctx context.Context, key string,
 		return rsp, err
 	}
 	//缓存没有或失败，从db获取
+	o := newQueryOptions(opts)
+	if err = queryAllowed(ctx, o); err != nil {
+		return rsp, err
+	}
 	lock := c.Lock(ctx, key)
 	defer c.Unlock(ctx, lock)
 	//再次判断是否有数据
@@ -28,36 +87,56 @@ func (c *Cache) QueryRawMessage(ctx context.Context, key string,
 	if err != nil {
 		return rsp, err
 	}
-	err = c.SetRawMessage(ctx, key, rsp)
-	if err != nil {
-	}
-	return rsp, err
+	//写缓存失败时仍然返回db数据
+	c.SetRawMessage(ctx, key, rsp, o.tags...)
+	return rsp, nil
 }
 
 func (c *Cache) QueryHashObject(ctx context.Context, key string, fields []string, obj interface{},
-	update func() ([]string, interface{}, error)) error {
-	conn, err := c.pool.GetContext(ctx)
+	update func() ([]string, interface{}, error), opts ...QueryOption) error {
+	conn, err := c.getConn(ctx)
 	if err != nil {
-		log.ErrLog("", fmt.Errorf("获取redis conn失败 key(%s),error(%v)", key, err))
-		return err
+		if err != ErrCircuitOpen {
+			log.ErrLog("", fmt.Errorf("获取redis conn失败 key(%s),error(%v)", key, err))
+		}
+		//redis不可用时直接从db获取,开启本地缓存时优先使用本地缓存
+		v, err := c.local.load("hash_"+key, func() (interface{}, error) {
+			_, uptData, err := update()
+			if err != nil {
+				return nil, err
+			}
+			return marshalRedisObj(reflect.ValueOf(uptData))
+		})
+		if err != nil {
+			return err
+		}
+		return unmarshalRedisObj(v.(map[string][]byte), reflect.ValueOf(obj))
 	}
 	defer conn.Close()
 	//首先判断缓存中有没有
 	//首先从缓存获取
-	data, err := getKeyHash(conn, key, fields, c.conf.ExpireTime)
+	data, err := getKeyHash(conn, c.key(key), fields, c.conf.ExpireTime)
 	if err == nil {
-		err = unmarshalRedisObj(data, reflect.ValueOf(obj))
+		if data, err = c.decryptHash(data); err == nil {
+			err = unmarshalRedisObj(data, reflect.ValueOf(obj))
+		}
 		if err == nil {
 			return err
 		}
 	}
 	//缓存没有或失败，从db获取
+	o := newQueryOptions(opts)
+	if err = queryAllowed(ctx, o); err != nil {
+		return err
+	}
 	lock := c.Lock(ctx, key)
 	defer c.Unlock(ctx, lock)
 	//再次判断是否有数据
-	data, err = getKeyHash(conn, key, fields, c.conf.ExpireTime)
+	data, err = getKeyHash(conn, c.key(key), fields, c.conf.ExpireTime)
 	if err == nil {
-		err = unmarshalRedisObj(data, reflect.ValueOf(obj))
+		if data, err = c.decryptHash(data); err == nil {
+			err = unmarshalRedisObj(data, reflect.ValueOf(obj))
+		}
 		if err == nil {
 			return err
 		}
@@ -72,14 +151,23 @@ func (c *Cache) QueryHashObject(ctx context.Context, key string, fields []string
 	if err != nil {
 		return err
 	}
-	err = setKeyHash(conn, key, fields, data, c.conf.ExpireTime)
-	if err != nil {
+	stored, err := c.encryptHash(data)
+	if err == nil {
+		setKeyHash(conn, c.key(key), fields, stored, c.conf.ExpireTime, c.tagKeys(o.tags)...)
 	}
 	return unmarshalRedisObj(data, reflect.ValueOf(obj))
 }
 
 func (c *Cache) QueryIdSet(ctx context.Context, key string,
-	update func() ([]int64, error)) (rsp []int64, err error) {
+	update func() ([]int64, error), opts ...QueryOption) (rsp []int64, err error) {
+	//redis熔断时直接从db获取,开启本地缓存时优先使用本地缓存
+	if c.CircuitOpen() {
+		v, err := c.local.load("idset_"+key, func() (interface{}, error) { return update() })
+		if err != nil {
+			return nil, err
+		}
+		return v.([]int64), nil
+	}
 	//首先判断缓存中有没有
 	//首先从缓存获取
 	rsp, err = c.GetIdSet(ctx, key)
@@ -87,6 +175,10 @@ func (c *Cache) QueryIdSet(ctx context.Context, key string,
 		return rsp, err
 	}
 	//缓存没有或失败，从db获取
+	o := newQueryOptions(opts)
+	if err = queryAllowed(ctx, o); err != nil {
+		return rsp, err
+	}
 	lock := c.Lock(ctx, key)
 	defer c.Unlock(ctx, lock)
 	//再次判断是否有数据
@@ -98,14 +190,21 @@ func (c *Cache) QueryIdSet(ctx context.Context, key string,
 	if err != nil {
 		return rsp, err
 	}
-	err = c.SetIdSet(ctx, key, rsp)
-	if err != nil {
-	}
-	return rsp, err
+	//写缓存失败时仍然返回db数据
+	c.SetIdSet(ctx, key, rsp, o.tags...)
+	return rsp, nil
 }
 
 func (c *Cache) QueryNameList(ctx context.Context, listKey string,
-	update func() ([]KeyValue, error)) (rsp []KeyValue, err error) {
+	update func() ([]KeyValue, error), opts ...QueryOption) (rsp []KeyValue, err error) {
+	//redis熔断时直接从db获取,开启本地缓存时优先使用本地缓存
+	if c.CircuitOpen() {
+		v, err := c.local.load("namelist_"+listKey, func() (interface{}, error) { return update() })
+		if err != nil {
+			return nil, err
+		}
+		return v.([]KeyValue), nil
+	}
 	//首先判断缓存中有没有
 	//首先从缓存获取
 	rsp, err = c.GetNameList(ctx, listKey)
@@ -113,6 +212,10 @@ func (c *Cache) QueryNameList(ctx context.Context, listKey string,
 		return rsp, err
 	}
 	//缓存没有或失败，从db获取
+	o := newQueryOptions(opts)
+	if err = queryAllowed(ctx, o); err != nil {
+		return rsp, err
+	}
 	lock := c.Lock(ctx, listKey)
 	defer c.Unlock(ctx, lock)
 	//再次判断是否有数据
@@ -124,6 +227,7 @@ func (c *Cache) QueryNameList(ctx context.Context, listKey string,
 	if err != nil {
 		return rsp, err
 	}
-	err = c.SetNameList(ctx, listKey, rsp)
-	return rsp, err
+	//写缓存失败时仍然返回db数据
+	c.SetNameList(ctx, listKey, rsp, o.tags...)
+	return rsp, nil
 }
@@ -1,10 +1,8 @@
 package redis
 
 import (
-	"encoding/json"
 	"errors"
 	"fmt"
-	"reflect"
 	"strconv"
 	"strings"
 
@@ -18,6 +16,41 @@ const (
 	SetLockSuccess    = "OK" // 操作成功
 )
 
+// 写入hash并设置过期时间,ARGV[1]为过期时间,ARGV[2]为写入的字段数量,之后依次为字段和值以及需要删除的字段。
+// 原值不是hash(如哨兵)时先删除,KEYS[2]起为标签集合
+var setHashScript = RegisterScript("setHash", -1, `
+local t = redis.call("TYPE", KEYS[1]).ok
+if t ~= "hash" and t ~= "none" then
+	redis.call("DEL", KEYS[1])
+end
+local n = tonumber(ARGV[2])
+for i = 0, n - 1 do
+	redis.call("HSET", KEYS[1], ARGV[3 + i * 2], ARGV[4 + i * 2])
+end
+for i = 3 + n * 2, #ARGV do
+	redis.call("HDEL", KEYS[1], ARGV[i])
+end
+redis.call("EXPIRE", KEYS[1], ARGV[1])`+tagKeysLua+`
+return 1`)
+
+// 整体替换hash并设置过期时间,ARGV[1]为过期时间,之后依次为字段和值,KEYS[2]起为标签集合
+var replaceHashScript = RegisterScript("replaceHash", -1, `
+redis.call("DEL", KEYS[1])
+for i = 2, #ARGV, 2 do
+	redis.call("HSET", KEYS[1], ARGV[i], ARGV[i + 1])
+end
+redis.call("EXPIRE", KEYS[1], ARGV[1])`+tagKeysLua+`
+return 1`)
+
+// 整体替换zset并设置过期时间,ARGV[1]为过期时间,之后依次为分数和成员,KEYS[2]起为标签集合
+var replaceZSetScript = RegisterScript("replaceZSet", -1, `
+redis.call("DEL", KEYS[1])
+for i = 2, #ARGV, 2 do
+	redis.call("ZADD", KEYS[1], ARGV[i], ARGV[i + 1])
+end
+redis.call("EXPIRE", KEYS[1], ARGV[1])`+tagKeysLua+`
+return 1`)
+
 //删除key
 func delKey(conn redis.Conn, key string) {
 	if _, err := conn.Do("DEL", key); err != nil {
@@ -71,9 +104,14 @@ func getKeyBytes(conn redis.Conn, key string,
 	return data, nil
 }
 
+// tagKeys不为空时在同一脚本中写入标签集合
 func setKeyBytes(conn redis.Conn, key string,
-	data []byte, expireTime int) error {
-	_, err := conn.Do("SET", key, data, SetWithExpireTime, expireTime)
+	data []byte, expireTime int, tagKeys ...string) (err error) {
+	if len(tagKeys) == 0 {
+		_, err = conn.Do("SET", key, data, SetWithExpireTime, expireTime)
+	} else {
+		_, err = setTaggedScript.Do(conn, taggedArgs(key, tagKeys, data, expireTime)...)
+	}
 	if err != nil {
 		err = fmt.Errorf("RedisSetKeyBytes conn.Do(SET, %v) error(%v)", key, err)
 		log.ErrLog("", err)
@@ -105,8 +143,12 @@ func getKeyInt64(conn redis.Conn, key string,
 }
 
 func setKeyInt64(conn redis.Conn, key string,
-	data int64, expireTime int) error {
-	_, err := conn.Do("SET", key, data, SetWithExpireTime, expireTime)
+	data int64, expireTime int, tagKeys ...string) (err error) {
+	if len(tagKeys) == 0 {
+		_, err = conn.Do("SET", key, data, SetWithExpireTime, expireTime)
+	} else {
+		_, err = setTaggedScript.Do(conn, taggedArgs(key, tagKeys, data, expireTime)...)
+	}
 	if err != nil {
 		err = fmt.Errorf("RedisSetKeyBytes conn.Do(SET, %v) error(%v)", key, err)
 		log.ErrLog("", err)
@@ -154,10 +196,10 @@ func getKeyHash(conn redis.Conn, key string,
 }
 
 func setKeyHash(conn redis.Conn, key string, fields []string,
-	data map[string][]byte, expireTime int) error {
+	data map[string][]byte, expireTime int, tagKeys ...string) error {
 	if len(fields) <= 0 || len(data) <= 0 {
 		// 设置哨兵
-		if _, err := conn.Do("SETEX", key, expireTime, "emptylist"); err != nil {
+		if err := setSentinel(conn, key, expireTime, tagKeys); err != nil {
 			err = fmt.Errorf("setKeyInfo conn.Do(SETEX, %s,) error(%v)", key, err)
 			log.ErrLog("", err)
 			return err
@@ -165,100 +207,69 @@ func setKeyHash(conn redis.Conn, key string, fields []string,
 		return nil
 	}
 
-	args := make([]interface{}, len(fields)*2+1)
-	args[0] = key
-
-	for i, f := range fields {
+	// 对象中为空被省略(omitempty)的字段值为nil,从hash中删除,避免残留旧值
+	args := make([]interface{}, 0, len(fields)*2+2)
+	args = append(args, expireTime, 0)
+	dels := make([]interface{}, 0)
+	for _, f := range fields {
 		v, ok := data[f]
 		if !ok {
 			err := fmt.Errorf("setKeyInfo unsupported field(%v), key(%s)", f, key)
 			log.ErrLog("", err)
 			return err
 		}
-		args[i*2+1] = f
-		args[i*2+2] = v
-	}
-
-	if _, err := conn.Do("HMSET", args...); err != nil {
-		err = fmt.Errorf("setKeyInfo conn.Do(HMSET, %v) error(%v)", args, err)
-		log.ErrLog("", err)
-		return err
-	}
-	_, err := conn.Do("EXPIRE", key, expireTime)
-	if err != nil {
-		log.ErrLog("", err)
+		if v == nil {
+			dels = append(dels, f)
+			continue
+		}
+		args = append(args, f, v)
 	}
-	return err
-}
-
-func marshalRedisObj(dataValue reflect.Value) (rsp map[string][]byte, err error) {
-	var v []byte
-	rsp = make(map[string][]byte)
-	for dataValue.Kind() == reflect.Ptr {
-		dataValue = dataValue.Elem()
+	n := (len(args) - 2) / 2
+	if n == 0 {
+		// 所有字段都为空,设置哨兵
+		return setKeyHash(conn, key, nil, nil, expireTime, tagKeys...)
 	}
-	if dataValue.Kind() != reflect.Struct {
-		err = errors.New("json marshal fail : data not struct or struct pointer")
+	args[1] = n
+	args = append(args, dels...)
+	if _, err := setHashScript.Do(conn, taggedArgs(key, tagKeys, args...)...); err != nil {
+		err = fmt.Errorf("setKeyInfo setHashScript.Do(%s, %v) error(%v)", key, fields, err)
 		log.ErrLog("", err)
-		return
-	}
-	fileNum := dataValue.NumField()
-	for j := 0; j < fileNum; j++ {
-		f := dataValue.Type().Field(j).Tag.Get("redis")
-		if f == "" {
-			err = errors.New("json marshal fail : cant get redis tag")
-			log.ErrLog("", err)
-			return
-		}
-		v, err = json.Marshal(dataValue.Field(j).Interface())
-		if err != nil {
-			log.ErrLog("", err)
-			return
-		}
-		rsp[f] = v
+		return err
 	}
-	return
+	return nil
 }
 
-func unmarshalRedisObj(data map[string][]byte, baseValue reflect.Value) (err error) {
-	if baseValue.Kind() != reflect.Ptr {
-		err = fmt.Errorf("unmarshal fail %s", baseValue.Kind().String())
-		log.ErrLog("", err)
-		return
-	}
-	for baseValue.Kind() == reflect.Ptr {
-		baseValue = baseValue.Elem()
-	}
-	if baseValue.Kind() != reflect.Struct {
-		err = fmt.Errorf("unmarshal fail %s", baseValue.Kind().String())
+// hash存在时更新部分字段,ARGV[1]为过期时间,ARGV[2]为更新的字段数量,之后依次为更新的字段和值以及删除的字段
+var updateHashScript = RegisterScript("updateHash", 1, `
+if redis.call("TYPE", KEYS[1]).ok ~= "hash" then
+	return 0
+end
+local n = tonumber(ARGV[2])
+for i = 0, n - 1 do
+	redis.call("HSET", KEYS[1], ARGV[3 + i * 2], ARGV[4 + i * 2])
+end
+for i = 3 + n * 2, #ARGV do
+	redis.call("HDEL", KEYS[1], ARGV[i])
+end
+redis.call("EXPIRE", KEYS[1], ARGV[1])`+tagKeysLua+`
+return 1`)
+
+func updateKeyHash(conn redis.Conn, key string, changed map[string][]byte,
+	removed []string, expireTime int) (bool, error) {
+	args := make([]interface{}, 0, len(changed)*2+len(removed)+3)
+	args = append(args, key, expireTime, len(changed))
+	for f, v := range changed {
+		args = append(args, f, v)
+	}
+	for _, f := range removed {
+		args = append(args, f)
+	}
+	ok, err := redis.Bool(updateHashScript.Do(conn, args...))
+	if err != nil {
+		err = fmt.Errorf("updateKeyHash updateHashScript.Do(%s) error(%v)", key, err)
 		log.ErrLog("", err)
-		return
 	}
-	fieldNum := baseValue.NumField()
-	for f, v := range data {
-		if len(v) == 0 || strings.Contains(string(v), "null") {
-			continue
-		}
-		bfind := false
-		for i := 0; i < fieldNum; i++ {
-			if baseValue.Type().Field(i).Tag.Get("redis") == f {
-				err = json.Unmarshal(v, baseValue.Field(i).Addr().Interface())
-				if err != nil {
-					log.ErrLog("", err)
-					return
-				}
-				bfind = true
-				break
-			}
-		}
-		if !bfind {
-			err = errors.New(fmt.Sprintf("cant find field %s", f))
-			log.ErrLog("", err)
-			return
-
-		}
-	}
-	return nil
+	return ok, err
 }
 
 func getIdSet(conn redis.Conn, listKey string, expireTime int) ([]int64, error) {
@@ -283,12 +294,12 @@ func getIdSet(conn redis.Conn, listKey string, expireTime int) ([]int64, error)
 
 //设置redis列表
 func setIdSet(conn redis.Conn, listKey string,
-	list []int64, expireTime int) error {
+	list []int64, expireTime int, tagKeys ...string) error {
 	set := make([]string, 0)
 	for _, id := range list {
 		set = append(set, strconv.FormatInt(id, 10))
 	}
-	err := setStringSet(conn, listKey, set, expireTime)
+	err := setStringSet(conn, listKey, set, expireTime, tagKeys...)
 	return err
 }
 
@@ -318,32 +329,22 @@ func getStringSet(conn redis.Conn, listKey string, expireTime int) ([]string, er
 
 //设置redis列表
 func setStringSet(conn redis.Conn,
-	listKey string, list []string, expireTime int) (err error) {
+	listKey string, list []string, expireTime int, tagKeys ...string) (err error) {
 	if len(list) <= 0 {
 		// 设置哨兵
-		if _, err = conn.Do("SETEX", listKey, expireTime, "emptylist"); err != nil {
+		if err = setSentinel(conn, listKey, expireTime, tagKeys); err != nil {
 			err = fmt.Errorf("setStringList conn.Do(SETEX, %s, %v) error(%v)", listKey, list, err)
 			log.ErrLog("", err)
 		}
 		return err
 	}
-	if _, err = conn.Do("DEL", listKey); err != nil && err != redis.ErrNil {
-		err = fmt.Errorf("setStringList conn.Do(DEL, %s) error(%v)", listKey, err)
-		log.ErrLog("", err)
-		return err
-	}
-	args := make([]interface{}, 2*len(list)+1)
-	args[0] = listKey
+	args := make([]interface{}, 0, 2*len(list)+1)
+	args = append(args, expireTime)
 	for idx, value := range list {
-		args[idx*2+1] = idx
-		args[idx*2+2] = value
+		args = append(args, idx, value)
 	}
-	if _, err = conn.Do("ZADD", args...); err != nil {
-		err = fmt.Errorf("setStringList conn.Do(SADD, %s, %v) error(%v)", listKey, list, err)
-		log.ErrLog("", err)
-	}
-	_, err = conn.Do("EXPIRE", listKey, expireTime)
-	if err != nil {
+	if _, err = replaceZSetScript.Do(conn, taggedArgs(listKey, tagKeys, args...)...); err != nil {
+		err = fmt.Errorf("setStringList replaceZSetScript.Do(%s, %v) error(%v)", listKey, list, err)
 		log.ErrLog("", err)
 	}
 	return err
@@ -413,33 +414,22 @@ func getNameValue(conn redis.Conn, listKey string, key string, expireTime int) (
 
 //设置redis列表name id映射
 func setNameList(conn redis.Conn, listKey string,
-	list []KeyValue, expireTime int) (err error) {
+	list []KeyValue, expireTime int, tagKeys ...string) (err error) {
 	if len(list) <= 0 {
 		// 设置哨兵
-		if _, err = conn.Do("SETEX", listKey, expireTime, "emptylist"); err != nil {
+		if err = setSentinel(conn, listKey, expireTime, tagKeys); err != nil {
 			err = fmt.Errorf("redisSetNameList conn.Do(SETEX, %s, %v) error(%v)", listKey, list, err)
 			log.ErrLog("", err)
 		}
 		return err
 	}
-	if _, err = conn.Do("DEL", listKey); err != nil && err != redis.ErrNil {
-		err = fmt.Errorf("redisSetNameList conn.Do(DEL, %s) error(%v)", listKey, err)
-		log.ErrLog("", err)
-		return err
-	}
-	args := make([]interface{}, len(list)*2+1)
-	args[0] = listKey
-	for i, f := range list {
-		args[i*2+1] = f.Key
-		args[i*2+2] = f.Value
+	args := make([]interface{}, 0, len(list)*2+1)
+	args = append(args, expireTime)
+	for _, f := range list {
+		args = append(args, f.Key, f.Value)
 	}
-	if _, err = conn.Do("HMSET", args...); err != nil {
-		err = fmt.Errorf("redisSetNameList conn.Do(HMSET, %v) error(%v)", args, err)
-		log.ErrLog("", err)
-		return err
-	}
-	_, err = conn.Do("EXPIRE", listKey, expireTime)
-	if err != nil {
+	if _, err = replaceHashScript.Do(conn, taggedArgs(listKey, tagKeys, args...)...); err != nil {
+		err = fmt.Errorf("redisSetNameList replaceHashScript.Do(%s, %v) error(%v)", listKey, list, err)
 		log.ErrLog("", err)
 	}
 	return err
@@ -480,37 +470,32 @@ func getKeyString(conn redis.Conn, key string,
 
 //10分钟人数统计
 func rpushOnlineCount(conn redis.Conn, key string, count int64) (err error) {
-	data, err := redis.Int64(conn.Do("LLEN", key))
-	if err != nil && err != redis.ErrNil {
-		err = fmt.Errorf("getKeyString conn.Do(LLEN, %s) error(%v)", key, err)
+	//只需要前十分钟的数据，每一分钟进行存入
+	return rpushTrim(conn, key, count, 10)
+}
+
+//右侧写入并只保留最新的maxLen条,RPUSH与LTRIM在同一事务中执行
+func rpushTrim(conn redis.Conn, key string, value int64, maxLen int64) (err error) {
+	if err = conn.Send("MULTI"); err != nil {
+		err = fmt.Errorf("rpushTrim conn.Send(MULTI, %s) error(%v)", key, err)
 		log.ErrLog("", err)
 		return err
 	}
-	//只需要前十分钟的数据，每一分钟进行存入
-	if data >= 10 {
-
-		forLen := data - 9
-		for i := forLen; i > 0; i-- {
-			_, err := redis.Bool(conn.Do("LPOP", key))
-			if err != nil && err != redis.ErrNil {
-				err = fmt.Errorf("getKeyString conn.Do(LPOP, %s) error(%v)", key, err)
-				log.ErrLog("", err)
-				return err
-			}
-		}
+	if err = conn.Send("RPUSH", key, value); err != nil {
+		err = fmt.Errorf("rpushTrim conn.Send(RPUSH, %s) error(%v)", key, err)
+		log.ErrLog("", err)
+		return err
 	}
-	_, err = redis.Bool(conn.Do("RPUSH", key, count))
-	if err != nil && err != redis.ErrNil {
-		err = fmt.Errorf("RPUSH conn.Do(RPUSH, %s) error(%v)", key, err)
+	if err = conn.Send("LTRIM", key, -maxLen, -1); err != nil {
+		err = fmt.Errorf("rpushTrim conn.Send(LTRIM, %s) error(%v)", key, err)
 		log.ErrLog("", err)
 		return err
 	}
-
-	//if !ok {
-	//	err = fmt.Errorf("not found key(%s) ", key)
-	//	return err
-	//}
-	return
+	if _, err = conn.Do("EXEC"); err != nil {
+		err = fmt.Errorf("rpushTrim conn.Do(EXEC, %s) error(%v)", key, err)
+		log.ErrLog("", err)
+	}
+	return err
 }
 
 //
@@ -539,39 +524,8 @@ func getLenOnlineCount(conn redis.Conn, key string) (data []int64, err error) {
 //}
 
 func rpushList(conn redis.Conn, key string, count int64) (err error) {
-	data, err := redis.Int64(conn.Do("LLEN", key))
-	if err != nil && err != redis.ErrNil {
-		err = fmt.Errorf("getKeyString conn.Do(LLEN, %s) error(%v)", key, err)
-		log.ErrLog("", err)
-		return err
-	}
 	//只需要前十分钟的数据，每一分钟进行存入
-	if data >= 10 {
-		forLen := data - 9
-		for i := forLen; i == 0; i-- {
-			ok, err := redis.Bool(conn.Do("LPOP", key))
-			if err != nil && err != redis.ErrNil {
-				err = fmt.Errorf("getKeyString conn.Do(LPOP, %s) error(%v)", key, err)
-				log.ErrLog("", err)
-				return err
-			}
-			if !ok {
-				err = fmt.Errorf("not found key(%s) ", key)
-				return err
-			}
-		}
-	}
-	ok, err := redis.Bool(conn.Do("RPUSH", key, count))
-	if err != nil && err != redis.ErrNil {
-		err = fmt.Errorf("RPUSH conn.Do(RPUSH, %s) error(%v)", key, err)
-		log.ErrLog("", err)
-		return err
-	}
-	if !ok {
-		err = fmt.Errorf("not found key(%s) ", key)
-		return err
-	}
-	return
+	return rpushTrim(conn, key, count, 10)
 }
 
 func setSetID(conn redis.Conn, key string, id int64) (err error) {
@@ -674,10 +628,10 @@ func hGetNum(conn redis.Conn, key string, field string, expireTime int) (int64,
 	args := make([]interface{}, 2)
 	args[0] = key
 	args[1] = field
-	rel, err = redis.Int64(conn.Do("HMGET", args...))
+	rel, err = redis.Int64(conn.Do("HGET", args...))
 	if err != nil && err != redis.ErrNil {
-		if e, ok := err.(*redis.Error); ok && strings.Index(e.Error(), "WRONGTYPE") == -1 {
-			err = fmt.Errorf("redisGetKeyInfo conn.Do(HMGET, %s,%v) error(%v)", key, field, err)
+		if e, ok := err.(redis.Error); !ok || strings.Index(e.Error(), "WRONGTYPE") == -1 {
+			err = fmt.Errorf("hGetNum conn.Do(HGET, %s,%v) error(%v)", key, field, err)
 			log.ErrLog("", err)
 			return rel, err
 		}
@@ -700,3 +654,44 @@ func hDel(conn redis.Conn, key string, fields []string) error {
 	}
 	return nil
 }
+
+func incrBy(conn redis.Conn, key string, num int64) (int64, error) {
+	n, err := redis.Int64(conn.Do("INCRBY", key, num))
+	if err != nil {
+		err = fmt.Errorf("incrBy conn.Do(INCRBY, %s, %d) error(%v)", key, num, err)
+		log.ErrLog("", err)
+		return 0, err
+	}
+	return n, nil
+}
+
+func hGetAll(conn redis.Conn, key string) (map[string]string, error) {
+	data, err := redis.StringMap(conn.Do("HGETALL", key))
+	if err != nil {
+		err = fmt.Errorf("hGetAll conn.Do(HGETALL, %s) error(%v)", key, err)
+		log.ErrLog("", err)
+	}
+	return data, err
+}
+
+func hSet(conn redis.Conn, key string, field string, value string) error {
+	if _, err := conn.Do("HSET", key, field, value); err != nil {
+		err = fmt.Errorf("hSet conn.Do(HSET, %s, %s) error(%v)", key, field, err)
+		log.ErrLog("", err)
+		return err
+	}
+	return nil
+}
+
+func delKeys(conn redis.Conn, keys []string) error {
+	args := make([]interface{}, 0, len(keys))
+	for _, k := range keys {
+		args = append(args, k)
+	}
+	if _, err := conn.Do("DEL", args...); err != nil {
+		err = fmt.Errorf("delKeys conn.Do(DEL, %v) error(%v)", keys, err)
+		log.ErrLog("", err)
+		return err
+	}
+	return nil
+}
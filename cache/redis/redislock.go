@@ -10,13 +10,21 @@ import (
 	"time"
 )
 
-var delScript = redis.NewScript(1, `
+var delScript = RegisterScript("unlock", 1, `
 if redis.call("get", KEYS[1]) == ARGV[1] then
 	return redis.call("del", KEYS[1])
 else
 	return 0
 end`)
 
+// 锁仍由自己持有时续期
+var extendScript = RegisterScript("extendLock", 1, `
+if redis.call("get", KEYS[1]) == ARGV[1] then
+	return redis.call("expire", KEYS[1], ARGV[2])
+else
+	return 0
+end`)
+
 const lockEx int = 10
 
 type Lock struct {
@@ -24,10 +32,15 @@ type Lock struct {
 	token uuid.UUID
 }
 
-func getRedisKey(key string) string {
+func getLockKey(key string) string {
 	return fmt.Sprintf("redislock_%s", key)
 }
 
+// 锁key只加命名空间前缀,不随命名空间版本变化
+func (c *Cache) getRedisKey(key string) string {
+	return c.nsKey(getLockKey(key))
+}
+
 /*
    redis 类型 字符串设置一个分布式锁 (哈希内部字段不支持过期判断,redis只支持顶级key过期)
    @param key: 锁名,格式为  用户id_操作_方法
@@ -40,38 +53,73 @@ func (c *Cache) Lock(ctx context.Context, key string) *Lock {
 		return nil
 	default:
 	}
-	lock := c.addLock(ctx, key)
-	for lock == nil {
+	// redis不可用时不再等待,返回nil由调用方在无锁状态下继续执行
+	lock, err := c.addLock(ctx, key)
+	for lock == nil && err == nil {
 		time.Sleep(100 * time.Millisecond)
 		select {
 		case <-ctx.Done():
 			return nil
 		default:
 		}
-		lock = c.addLock(ctx, key)
+		lock, err = c.addLock(ctx, key)
 	}
 	return lock
 }
 
-func (c *Cache) addLock(ctx context.Context, key string) *Lock {
-	conn, err := c.pool.GetContext(ctx)
+// 尝试加锁一次不等待,锁已被占用时返回nil,ex为过期时间(秒),用于需要长期持有并续期的租约
+func (c *Cache) TryLock(ctx context.Context, key string, ex int) (*Lock, error) {
+	return c.setLock(ctx, key, ex)
+}
+
+// 锁续期,锁已过期或被他人持有时返回false
+func (c *Cache) ExtendLock(ctx context.Context, l *Lock, ex int) (bool, error) {
+	if l == nil {
+		return false, nil
+	}
+	conn, err := c.getConn(ctx)
+	if err != nil {
+		log.ErrLog("", fmt.Errorf("获取redis conn失败 error(%v)", err))
+		return false, err
+	}
+	defer conn.Close()
+	ok, err := redis.Bool(extendScript.Do(conn, l.key, l.token, ex))
+	if err != nil {
+		err = fmt.Errorf("ExtendLock extendScript.Do(%s) error(%v)", l.key, err)
+		log.ErrLog("", err)
+		return false, err
+	}
+	return ok, nil
+}
+
+// 加锁,锁已被占用时返回nil,redis出错时返回错误
+func (c *Cache) addLock(ctx context.Context, key string) (*Lock, error) {
+	return c.setLock(ctx, key, lockEx)
+}
+
+func (c *Cache) setLock(ctx context.Context, key string, ex int) (*Lock, error) {
+	conn, err := c.getConn(ctx)
 	if err != nil {
 		log.ErrLog("", fmt.Errorf("获取redis conn失败 key(%s),error(%v)", key, err))
-		return nil
+		return nil, err
 	}
 	defer conn.Close()
 	token := uuid.NewV4()
-	redisKey := getRedisKey(key)
-	msg, _ := redis.String(
-		conn.Do("SET", redisKey, token, SetIfNotExist, SetWithExpireTime, lockEx),
+	redisKey := c.getRedisKey(key)
+	msg, err := redis.String(
+		conn.Do("SET", redisKey, token, SetIfNotExist, SetWithExpireTime, ex),
 	)
+	if err != nil && err != redis.ErrNil {
+		log.ErrLog("", fmt.Errorf("addLock conn.Do(SET, %s) error(%v)", redisKey, err))
+		return nil, err
+	}
 	if msg == SetLockSuccess {
 		return &Lock{
 			key:   redisKey,
 			token: token,
-		}
+		}, nil
 	}
-	return nil
+	return nil, nil
 }
 
 /*
@@ -84,7 +132,7 @@ func (c *Cache) Unlock(ctx context.Context, l *Lock) {
 	if l == nil {
 		return
 	}
-	conn, err := c.pool.GetContext(ctx)
+	conn, err := c.getConn(ctx)
 	if err != nil {
 		log.ErrLog("", fmt.Errorf("获取redis conn失败 error(%v)", err))
 		return
@@ -0,0 +1,141 @@
+package redis
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+	"sync/atomic"
+
+	"github.com/gomodule/redigo/redis"
+	"github.com/thesky9531/lareina/log"
+)
+
+// Lua脚本,首次使用时 SCRIPT LOAD,之后使用 EVALSHA 调用,
+// redis重启或 SCRIPT FLUSH 后返回 NOSCRIPT 时重新加载再执行
+type Script struct {
+	name     string
+	keyCount int
+	script   *redis.Script
+	loaded   int32
+}
+
+var (
+	scriptsMu sync.RWMutex
+	scripts   = make(map[string]*Script)
+)
+
+// RegisterScript 注册脚本,keyCount为-1时第一个参数为key的数量,重复注册同名脚本会panic
+func RegisterScript(name string, keyCount int, src string) *Script {
+	scriptsMu.Lock()
+	defer scriptsMu.Unlock()
+	if _, ok := scripts[name]; ok {
+		panic(fmt.Sprintf("redis: script %s registered twice", name))
+	}
+	s := &Script{
+		name:     name,
+		keyCount: keyCount,
+		script:   redis.NewScript(keyCount, src),
+	}
+	scripts[name] = s
+	return s
+}
+
+func getScript(name string) *Script {
+	scriptsMu.RLock()
+	defer scriptsMu.RUnlock()
+	return scripts[name]
+}
+
+func (s *Script) Name() string {
+	return s.name
+}
+
+func (s *Script) load(conn redis.Conn) error {
+	if err := s.script.Load(conn); err != nil {
+		return fmt.Errorf("script %s SCRIPT LOAD error(%v)", s.name, err)
+	}
+	atomic.StoreInt32(&s.loaded, 1)
+	return nil
+}
+
+func (s *Script) args(keysAndArgs []interface{}) []interface{} {
+	args := make([]interface{}, 0, len(keysAndArgs)+2)
+	args = append(args, s.script.Hash())
+	if s.keyCount >= 0 {
+		args = append(args, s.keyCount)
+	}
+	return append(args, keysAndArgs...)
+}
+
+// Do 使用 EVALSHA 执行脚本
+func (s *Script) Do(conn redis.Conn, keysAndArgs ...interface{}) (interface{}, error) {
+	if atomic.LoadInt32(&s.loaded) == 0 {
+		if err := s.load(conn); err != nil {
+			return nil, err
+		}
+	}
+	args := s.args(keysAndArgs)
+	reply, err := conn.Do("EVALSHA", args...)
+	if e, ok := err.(redis.Error); ok && strings.HasPrefix(string(e), "NOSCRIPT ") {
+		if err = s.load(conn); err != nil {
+			return nil, err
+		}
+		reply, err = conn.Do("EVALSHA", args...)
+	}
+	return reply, err
+}
+
+// 预加载所有已注册的脚本,建议在服务启动时调用
+func (c *Cache) LoadScripts(ctx context.Context) error {
+	conn, err := c.getConn(ctx)
+	if err != nil {
+		log.ErrLog("", fmt.Errorf("获取redis conn失败 error(%v)", err))
+		return err
+	}
+	defer conn.Close()
+	scriptsMu.RLock()
+	list := make([]*Script, 0, len(scripts))
+	for _, s := range scripts {
+		list = append(list, s)
+	}
+	scriptsMu.RUnlock()
+	for _, s := range list {
+		if err = s.load(conn); err != nil {
+			log.ErrLog("", err)
+			return err
+		}
+	}
+	return nil
+}
+
+// 按名称执行已注册的脚本,keys会加上命名空间前缀
+func (c *Cache) EvalScript(ctx context.Context, name string, keys []string, args ...interface{}) (interface{}, error) {
+	s := getScript(name)
+	if s == nil {
+		return nil, fmt.Errorf("EvalScript script %s not registered", name)
+	}
+	if s.keyCount >= 0 && s.keyCount != len(keys) {
+		return nil, fmt.Errorf("EvalScript script %s want %d keys, got %d", name, s.keyCount, len(keys))
+	}
+	conn, err := c.getConn(ctx)
+	if err != nil {
+		log.ErrLog("", fmt.Errorf("获取redis conn失败 script(%s),error(%v)", name, err))
+		return nil, err
+	}
+	defer conn.Close()
+	keysAndArgs := make([]interface{}, 0, len(keys)+len(args)+1)
+	if s.keyCount < 0 {
+		keysAndArgs = append(keysAndArgs, len(keys))
+	}
+	for _, k := range keys {
+		keysAndArgs = append(keysAndArgs, c.key(k))
+	}
+	keysAndArgs = append(keysAndArgs, args...)
+	reply, err := s.Do(conn, keysAndArgs...)
+	if err != nil {
+		err = fmt.Errorf("EvalScript script %s error(%v)", name, err)
+		log.ErrLog("", err)
+	}
+	return reply, err
+}
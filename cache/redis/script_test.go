@@ -0,0 +1,28 @@
+package redis
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestScriptArgs(t *testing.T) {
+	fixed := RegisterScript("testFixed", 1, `return 1`)
+	args := fixed.args([]interface{}{"k", 1})
+	if !reflect.DeepEqual(args, []interface{}{fixed.script.Hash(), 1, "k", 1}) {
+		t.Errorf("fixed args = %v", args)
+	}
+	variadic := RegisterScript("testVariadic", -1, `return 1`)
+	args = variadic.args([]interface{}{2, "k1", "k2"})
+	if !reflect.DeepEqual(args, []interface{}{variadic.script.Hash(), 2, "k1", "k2"}) {
+		t.Errorf("variadic args = %v", args)
+	}
+	if getScript("testFixed") != fixed {
+		t.Error("getScript testFixed not found")
+	}
+	defer func() {
+		if recover() == nil {
+			t.Error("register duplicate script should panic")
+		}
+	}()
+	RegisterScript("testFixed", 1, `return 2`)
+}
@@ -0,0 +1,77 @@
+package redis
+
+import (
+	"context"
+	"encoding/json"
+)
+
+// Store 缓存操作接口,业务代码依赖该接口,测试或单机开发时可以使用 MemoryStore 代替redis
+type Store interface {
+	Ping(ctx context.Context) error
+
+	DelKey(ctx context.Context, key string)
+	DelMultiKey(ctx context.Context, keys ...string)
+	RegexpDelKey(ctx context.Context, key string)
+	RegexpDelMultiKey(ctx context.Context, keys ...string)
+	GetRegexpKeys(ctx context.Context, key string) ([]string, error)
+
+	GetRawMessage(ctx context.Context, key string) (json.RawMessage, error)
+	SetRawMessage(ctx context.Context, key string, data json.RawMessage, tags ...string) error
+	GetObject(ctx context.Context, key string, obj interface{}) error
+	SetObject(ctx context.Context, key string, obj interface{}, tags ...string) error
+	GetInt64(ctx context.Context, key string) (int64, error)
+	SetInt64(ctx context.Context, key string, data int64, tags ...string) error
+	GetExpireTimeKey(ctx context.Context, key string, expireTime int) (string, error)
+	SetExpireTimeKey(ctx context.Context, key string, value string, expireTime int) error
+
+	GetHashObject(ctx context.Context, key string, fields []string, obj interface{}) error
+	SetHashObject(ctx context.Context, key string, fields []string, obj interface{}, tags ...string) error
+	UpdateHashObject(ctx context.Context, key string, prev, cur interface{}) (bool, error)
+	HReset(ctx context.Context, key string, field string) error
+	HIncrBy(ctx context.Context, key string, field string, num int64) error
+	HGetNum(ctx context.Context, key string, field string) (int64, error)
+	HDel(ctx context.Context, key string, fields ...string) error
+
+	GetIdSet(ctx context.Context, key string) ([]int64, error)
+	SetIdSet(ctx context.Context, key string, list []int64, tags ...string) error
+	SetScoredIdSet(ctx context.Context, key string, list []ScoredId, tags ...string) error
+	GetIdSetPage(ctx context.Context, key string, offset, limit int64) ([]int64, error)
+	GetIdSetPageRev(ctx context.Context, key string, offset, limit int64) ([]int64, error)
+	GetIdSetByScore(ctx context.Context, key string, min, max float64, offset, limit int64) ([]int64, error)
+	GetIdSetByScoreRev(ctx context.Context, key string, min, max float64, offset, limit int64) ([]int64, error)
+	GetIdSetCount(ctx context.Context, key string) (int64, error)
+	AddToIdSet(ctx context.Context, key string, list ...ScoredId) error
+	RemoveFromIdSet(ctx context.Context, key string, ids ...int64) error
+
+	GetNameList(ctx context.Context, listKey string) ([]KeyValue, error)
+	SetNameList(ctx context.Context, listKey string, list []KeyValue, tags ...string) error
+
+	SetSetID(ctx context.Context, key string, id int64) error
+	GetSetCount(ctx context.Context, key string) (int64, error)
+
+	SetKeyInt64List(ctx context.Context, listKey string, list int64) error
+	GetKeyInt64List(ctx context.Context, key string) (int64, error)
+	RPushOnlineCount(ctx context.Context, key string, count int64) error
+	GetLenOnlineCount(ctx context.Context, key string) ([]int64, error)
+	RPushList(ctx context.Context, key string, id int64) error
+
+	TagKey(ctx context.Context, key string, tags ...string) error
+	InvalidateTags(ctx context.Context, tags ...string) (int64, error)
+
+	Lock(ctx context.Context, key string) *Lock
+	Unlock(ctx context.Context, l *Lock)
+
+	QueryRawMessage(ctx context.Context, key string,
+		update func() (json.RawMessage, error), opts ...QueryOption) (json.RawMessage, error)
+	QueryHashObject(ctx context.Context, key string, fields []string, obj interface{},
+		update func() ([]string, interface{}, error), opts ...QueryOption) error
+	QueryIdSet(ctx context.Context, key string,
+		update func() ([]int64, error), opts ...QueryOption) ([]int64, error)
+	QueryNameList(ctx context.Context, listKey string,
+		update func() ([]KeyValue, error), opts ...QueryOption) ([]KeyValue, error)
+}
+
+var (
+	_ Store = (*Cache)(nil)
+	_ Store = (*MemoryStore)(nil)
+)
@@ -0,0 +1,429 @@
+package redis
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/gomodule/redigo/redis"
+	"github.com/thesky9531/lareina/log"
+)
+
+// stream消息
+type StreamMessage struct {
+	ID     string
+	Values map[string]string
+}
+
+// 消费组中已投递未确认的消息
+type PendingEntry struct {
+	ID         string
+	Consumer   string
+	Idle       time.Duration
+	Deliveries int64
+}
+
+type StreamConsumerConfig struct {
+	Count        int64         // 每次读取的最大消息数,默认10
+	Block        time.Duration // 没有消息时阻塞等待时间,默认2秒
+	ClaimMinIdle time.Duration // 超过该时间未确认的消息会被当前消费者认领,默认1分钟,小于0时不认领
+	ClaimPeriod  time.Duration // 认领检查间隔,默认30秒
+	// 最大投递次数,认领时超过该次数的消息移入死信stream并确认,默认5,小于0时不限制
+	MaxDeliveries int64
+	// 死信stream,默认为 stream_dead
+	DeadLetterStream string
+}
+
+// 基于消费组的stream消费者
+type StreamConsumer struct {
+	cache    *Cache
+	stream   string
+	group    string
+	consumer string
+	conf     StreamConsumerConfig
+}
+
+// 解析 [[id, [field, value, ...]], ...] 格式的消息列表,已删除的消息字段为空
+func parseStreamMessages(reply interface{}) ([]StreamMessage, error) {
+	entries, err := redis.Values(reply, nil)
+	if err != nil {
+		if err == redis.ErrNil {
+			return []StreamMessage{}, nil
+		}
+		return nil, err
+	}
+	msgs := make([]StreamMessage, 0, len(entries))
+	for _, e := range entries {
+		entry, err := redis.Values(e, nil)
+		if err != nil {
+			return nil, err
+		}
+		if len(entry) != 2 {
+			return nil, fmt.Errorf("parseStreamMessages invalid entry length(%d)", len(entry))
+		}
+		id, err := redis.String(entry[0], nil)
+		if err != nil {
+			return nil, err
+		}
+		msg := StreamMessage{ID: id, Values: make(map[string]string)}
+		if entry[1] != nil {
+			values, err := redis.StringMap(entry[1], nil)
+			if err != nil {
+				return nil, err
+			}
+			msg.Values = values
+		}
+		msgs = append(msgs, msg)
+	}
+	return msgs, nil
+}
+
+func xAdd(conn redis.Conn, stream string, maxLen int64, values map[string]interface{}) (string, error) {
+	args := make([]interface{}, 0, len(values)*2+4)
+	args = append(args, stream)
+	if maxLen > 0 {
+		args = append(args, "MAXLEN", "~", maxLen)
+	}
+	args = append(args, "*")
+	for k, v := range values {
+		args = append(args, k, v)
+	}
+	id, err := redis.String(conn.Do("XADD", args...))
+	if err != nil {
+		err = fmt.Errorf("xAdd conn.Do(XADD, %s) error(%v)", stream, err)
+		log.ErrLog("", err)
+	}
+	return id, err
+}
+
+func xGroupCreate(conn redis.Conn, stream, group, start string) error {
+	_, err := conn.Do("XGROUP", "CREATE", stream, group, start, "MKSTREAM")
+	if err != nil {
+		if e, ok := err.(redis.Error); ok && strings.HasPrefix(e.Error(), "BUSYGROUP") {
+			return nil
+		}
+		err = fmt.Errorf("xGroupCreate conn.Do(XGROUP, %s, %s) error(%v)", stream, group, err)
+		log.ErrLog("", err)
+	}
+	return err
+}
+
+func xReadGroup(conn redis.Conn, stream, group, consumer string, count int64, block time.Duration) ([]StreamMessage, error) {
+	args := []interface{}{"GROUP", group, consumer}
+	if count > 0 {
+		args = append(args, "COUNT", count)
+	}
+	if block > 0 {
+		args = append(args, "BLOCK", int64(block/time.Millisecond))
+	}
+	args = append(args, "STREAMS", stream, ">")
+	reply, err := redis.Values(conn.Do("XREADGROUP", args...))
+	if err != nil {
+		if err == redis.ErrNil {
+			return []StreamMessage{}, nil
+		}
+		err = fmt.Errorf("xReadGroup conn.Do(XREADGROUP, %s, %s, %s) error(%v)", stream, group, consumer, err)
+		log.ErrLog("", err)
+		return nil, err
+	}
+	msgs := make([]StreamMessage, 0)
+	for _, s := range reply {
+		sv, err := redis.Values(s, nil)
+		if err != nil || len(sv) != 2 {
+			err = fmt.Errorf("xReadGroup invalid reply stream(%s) error(%v)", stream, err)
+			log.ErrLog("", err)
+			return nil, err
+		}
+		m, err := parseStreamMessages(sv[1])
+		if err != nil {
+			err = fmt.Errorf("xReadGroup parse stream(%s) error(%v)", stream, err)
+			log.ErrLog("", err)
+			return nil, err
+		}
+		msgs = append(msgs, m...)
+	}
+	return msgs, nil
+}
+
+func xAck(conn redis.Conn, stream, group string, ids []string) error {
+	args := make([]interface{}, 0, len(ids)+2)
+	args = append(args, stream, group)
+	for _, id := range ids {
+		args = append(args, id)
+	}
+	if _, err := conn.Do("XACK", args...); err != nil {
+		err = fmt.Errorf("xAck conn.Do(XACK, %s, %s, %v) error(%v)", stream, group, ids, err)
+		log.ErrLog("", err)
+		return err
+	}
+	return nil
+}
+
+func xPending(conn redis.Conn, stream, group, start, end string, count int64, consumer string) ([]PendingEntry, error) {
+	args := []interface{}{stream, group, start, end, count}
+	if consumer != "" {
+		args = append(args, consumer)
+	}
+	reply, err := redis.Values(conn.Do("XPENDING", args...))
+	if err != nil {
+		if err == redis.ErrNil {
+			return []PendingEntry{}, nil
+		}
+		err = fmt.Errorf("xPending conn.Do(XPENDING, %s, %s) error(%v)", stream, group, err)
+		log.ErrLog("", err)
+		return nil, err
+	}
+	rsp := make([]PendingEntry, 0, len(reply))
+	for _, r := range reply {
+		var (
+			p    PendingEntry
+			idle int64
+		)
+		values, err := redis.Values(r, nil)
+		if err == nil {
+			_, err = redis.Scan(values, &p.ID, &p.Consumer, &idle, &p.Deliveries)
+		}
+		if err != nil {
+			err = fmt.Errorf("xPending invalid reply stream(%s) error(%v)", stream, err)
+			log.ErrLog("", err)
+			return nil, err
+		}
+		p.Idle = time.Duration(idle) * time.Millisecond
+		rsp = append(rsp, p)
+	}
+	return rsp, nil
+}
+
+// 需要redis 6.2以上,返回下次认领的起始id
+func xAutoClaim(conn redis.Conn, stream, group, consumer string, minIdle time.Duration,
+	start string, count int64) (string, []StreamMessage, error) {
+	reply, err := redis.Values(conn.Do("XAUTOCLAIM", stream, group, consumer,
+		int64(minIdle/time.Millisecond), start, "COUNT", count))
+	if err != nil {
+		err = fmt.Errorf("xAutoClaim conn.Do(XAUTOCLAIM, %s, %s, %s) error(%v)", stream, group, consumer, err)
+		log.ErrLog("", err)
+		return "", nil, err
+	}
+	if len(reply) < 2 {
+		err = fmt.Errorf("xAutoClaim invalid reply length(%d)", len(reply))
+		log.ErrLog("", err)
+		return "", nil, err
+	}
+	next, err := redis.String(reply[0], nil)
+	if err != nil {
+		log.ErrLog("", err)
+		return "", nil, err
+	}
+	msgs, err := parseStreamMessages(reply[1])
+	if err != nil {
+		err = fmt.Errorf("xAutoClaim parse stream(%s) error(%v)", stream, err)
+		log.ErrLog("", err)
+		return "", nil, err
+	}
+	return next, msgs, nil
+}
+
+// 写入stream消息,maxLen>0时近似裁剪到该长度
+func (c *Cache) XAdd(ctx context.Context, stream string, maxLen int64, values map[string]interface{}) (string, error) {
+	conn, err := c.getConn(ctx)
+	if err != nil {
+		log.ErrLog("", fmt.Errorf("获取redis conn失败 key(%s),error(%v)", stream, err))
+		return "", err
+	}
+	defer conn.Close()
+	return xAdd(conn, c.nsKey(stream), maxLen, values)
+}
+
+// 创建消费组,stream不存在时自动创建,消费组已存在时不报错;start为 $ 时只消费新消息,为 0 时从头消费
+func (c *Cache) XGroupCreate(ctx context.Context, stream, group, start string) error {
+	conn, err := c.getConn(ctx)
+	if err != nil {
+		log.ErrLog("", fmt.Errorf("获取redis conn失败 key(%s),error(%v)", stream, err))
+		return err
+	}
+	defer conn.Close()
+	return xGroupCreate(conn, c.nsKey(stream), group, start)
+}
+
+// 以消费组方式读取新消息,block为0时不阻塞
+func (c *Cache) XReadGroup(ctx context.Context, stream, group, consumer string,
+	count int64, block time.Duration) ([]StreamMessage, error) {
+	conn, err := c.getConn(ctx)
+	if err != nil {
+		log.ErrLog("", fmt.Errorf("获取redis conn失败 key(%s),error(%v)", stream, err))
+		return nil, err
+	}
+	defer conn.Close()
+	return xReadGroup(conn, c.nsKey(stream), group, consumer, count, block)
+}
+
+// 确认消息
+func (c *Cache) XAck(ctx context.Context, stream, group string, ids ...string) error {
+	if len(ids) == 0 {
+		return nil
+	}
+	conn, err := c.getConn(ctx)
+	if err != nil {
+		log.ErrLog("", fmt.Errorf("获取redis conn失败 key(%s),error(%v)", stream, err))
+		return err
+	}
+	defer conn.Close()
+	return xAck(conn, c.nsKey(stream), group, ids)
+}
+
+// 查看消费组未确认的消息,consumer为空时查看所有消费者
+func (c *Cache) XPending(ctx context.Context, stream, group string, count int64, consumer string) ([]PendingEntry, error) {
+	conn, err := c.getConn(ctx)
+	if err != nil {
+		log.ErrLog("", fmt.Errorf("获取redis conn失败 key(%s),error(%v)", stream, err))
+		return nil, err
+	}
+	defer conn.Close()
+	return xPending(conn, c.nsKey(stream), group, "-", "+", count, consumer)
+}
+
+// 认领超过minIdle未确认的消息,返回下次认领的起始id,为 0-0 时表示已遍历完
+func (c *Cache) XAutoClaim(ctx context.Context, stream, group, consumer string, minIdle time.Duration,
+	start string, count int64) (string, []StreamMessage, error) {
+	conn, err := c.getConn(ctx)
+	if err != nil {
+		log.ErrLog("", fmt.Errorf("获取redis conn失败 key(%s),error(%v)", stream, err))
+		return "", nil, err
+	}
+	defer conn.Close()
+	return xAutoClaim(conn, c.nsKey(stream), group, consumer, minIdle, start, count)
+}
+
+// NewStreamConsumer 创建消费组消费者,conf为nil时使用默认配置
+func (c *Cache) NewStreamConsumer(stream, group, consumer string, conf *StreamConsumerConfig) *StreamConsumer {
+	s := &StreamConsumer{
+		cache:    c,
+		stream:   stream,
+		group:    group,
+		consumer: consumer,
+	}
+	if conf != nil {
+		s.conf = *conf
+	}
+	if s.conf.Count <= 0 {
+		s.conf.Count = 10
+	}
+	if s.conf.Block <= 0 {
+		s.conf.Block = 2 * time.Second
+	}
+	if s.conf.ClaimMinIdle == 0 {
+		s.conf.ClaimMinIdle = time.Minute
+	}
+	if s.conf.ClaimPeriod <= 0 {
+		s.conf.ClaimPeriod = 30 * time.Second
+	}
+	if s.conf.MaxDeliveries == 0 {
+		s.conf.MaxDeliveries = 5
+	}
+	if s.conf.DeadLetterStream == "" {
+		s.conf.DeadLetterStream = stream + "_dead"
+	}
+	return s
+}
+
+// 将投递次数超限的消息写入死信stream并确认,返回其余需要处理的消息
+func (s *StreamConsumer) deadLetter(ctx context.Context, msgs []StreamMessage) []StreamMessage {
+	if s.conf.MaxDeliveries < 0 || len(msgs) == 0 {
+		return msgs
+	}
+	conn, err := s.cache.getConn(ctx)
+	if err != nil {
+		log.ErrLog("", fmt.Errorf("获取redis conn失败 key(%s),error(%v)", s.stream, err))
+		return msgs
+	}
+	defer conn.Close()
+	stream := s.cache.nsKey(s.stream)
+	rsp := make([]StreamMessage, 0, len(msgs))
+	for _, msg := range msgs {
+		pending, err := xPending(conn, stream, s.group, msg.ID, msg.ID, 1, s.consumer)
+		if err != nil || len(pending) == 0 || pending[0].Deliveries <= s.conf.MaxDeliveries {
+			rsp = append(rsp, msg)
+			continue
+		}
+		values := make(map[string]interface{}, len(msg.Values)+2)
+		for k, v := range msg.Values {
+			values[k] = v
+		}
+		values["source_id"] = msg.ID
+		values["deliveries"] = pending[0].Deliveries
+		if _, err = xAdd(conn, s.cache.nsKey(s.conf.DeadLetterStream), 0, values); err != nil {
+			rsp = append(rsp, msg)
+			continue
+		}
+		if err = xAck(conn, stream, s.group, []string{msg.ID}); err != nil {
+			continue
+		}
+		log.ErrLog("", fmt.Errorf("stream(%s) group(%s) message(%s) deliveries(%d) moved to dead letter(%s)",
+			s.stream, s.group, msg.ID, pending[0].Deliveries, s.conf.DeadLetterStream))
+	}
+	return rsp
+}
+
+// 依次处理消息,ctx结束后剩余的消息不再处理,留在pending中等待认领
+func (s *StreamConsumer) handle(ctx context.Context, msgs []StreamMessage, handler func(ctx context.Context, msg StreamMessage) error) {
+	for _, msg := range msgs {
+		if ctx.Err() != nil {
+			return
+		}
+		err := func() (err error) {
+			defer func() {
+				if r := recover(); r != nil {
+					err = fmt.Errorf("stream(%s) message(%s) panic: %v", s.stream, msg.ID, r)
+				}
+			}()
+			return handler(ctx, msg)
+		}()
+		if err != nil {
+			// 不确认,等待超时后被认领重新处理
+			log.ErrLog(fmt.Sprintf("stream(%s) group(%s) message(%s)", s.stream, s.group, msg.ID), err)
+			continue
+		}
+		s.cache.XAck(context.Background(), s.stream, s.group, msg.ID)
+	}
+}
+
+// 认领并处理其他消费者超时未确认的消息,投递次数超限的消息移入死信stream
+func (s *StreamConsumer) claim(ctx context.Context, handler func(ctx context.Context, msg StreamMessage) error) {
+	start := "0-0"
+	for ctx.Err() == nil {
+		next, msgs, err := s.cache.XAutoClaim(ctx, s.stream, s.group, s.consumer, s.conf.ClaimMinIdle, start, s.conf.Count)
+		if err != nil {
+			return
+		}
+		s.handle(ctx, s.deadLetter(ctx, msgs), handler)
+		if next == "0-0" || next == start {
+			return
+		}
+		start = next
+	}
+}
+
+// Run 创建消费组并循环消费,处理成功的消息自动确认,阻塞直到ctx结束且当前批次处理完成
+func (s *StreamConsumer) Run(ctx context.Context, handler func(ctx context.Context, msg StreamMessage) error) error {
+	if err := s.cache.XGroupCreate(ctx, s.stream, s.group, "0"); err != nil {
+		return err
+	}
+	lastClaim := time.Time{}
+	for ctx.Err() == nil {
+		if s.conf.ClaimMinIdle > 0 && time.Since(lastClaim) >= s.conf.ClaimPeriod {
+			s.claim(ctx, handler)
+			lastClaim = time.Now()
+		}
+		msgs, err := s.cache.XReadGroup(ctx, s.stream, s.group, s.consumer, s.conf.Count, s.conf.Block)
+		if err != nil {
+			select {
+			case <-ctx.Done():
+			case <-time.After(time.Second):
+			}
+			continue
+		}
+		s.handle(ctx, msgs, handler)
+	}
+	return nil
+}
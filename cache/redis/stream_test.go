@@ -0,0 +1,92 @@
+package redis
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+type streamCtxKey struct{}
+
+func TestStreamConsumerDeadLetter(t *testing.T) {
+	c, s := newTestCache(t, nil)
+	ctx := context.WithValue(context.Background(), streamCtxKey{}, "run")
+	if err := c.XGroupCreate(ctx, "st", "g", "0"); err != nil {
+		t.Fatal(err)
+	}
+	bad, err := c.XAdd(ctx, "st", 0, map[string]interface{}{"v": "bad"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = c.XAdd(ctx, "st", 0, map[string]interface{}{"v": "good"}); err != nil {
+		t.Fatal(err)
+	}
+	if msgs, err := c.XReadGroup(ctx, "st", "g", "other", 10, 0); err != nil || len(msgs) != 2 {
+		t.Fatalf("XReadGroup = %d, %v", len(msgs), err)
+	}
+
+	sc := c.NewStreamConsumer("st", "g", "me", &StreamConsumerConfig{ClaimMinIdle: time.Millisecond, MaxDeliveries: 2})
+	handled := make(map[string]int)
+	handler := func(ctx context.Context, msg StreamMessage) error {
+		if ctx.Value(streamCtxKey{}) != "run" {
+			t.Error("handler not given consumer ctx")
+		}
+		handled[msg.Values["v"]]++
+		if msg.Values["v"] == "bad" {
+			return errors.New("bad")
+		}
+		return nil
+	}
+	// 第2次投递仍处理,第3次投递超限移入死信
+	for i := 0; i < 2; i++ {
+		time.Sleep(5 * time.Millisecond)
+		sc.claim(ctx, handler)
+	}
+	if handled["bad"] != 1 || handled["good"] != 1 {
+		t.Fatalf("handled = %v", handled)
+	}
+	if pending, err := c.XPending(ctx, "st", "g", 10, ""); err != nil || len(pending) != 0 {
+		t.Fatalf("XPending = %v, %v", pending, err)
+	}
+	dead, err := s.Stream(c.nsKey("st_dead"))
+	if err != nil || len(dead) != 1 {
+		t.Fatalf("dead letter = %v, %v", dead, err)
+	}
+	values := make(map[string]string)
+	for i := 0; i+1 < len(dead[0].Values); i += 2 {
+		values[dead[0].Values[i]] = dead[0].Values[i+1]
+	}
+	if values["v"] != "bad" || values["source_id"] != bad || values["deliveries"] != "3" {
+		t.Fatalf("dead letter values = %v", values)
+	}
+}
+
+func TestStreamConsumerHandleCancel(t *testing.T) {
+	c, _ := newTestCache(t, nil)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	if err := c.XGroupCreate(ctx, "st", "g", "0"); err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 3; i++ {
+		if _, err := c.XAdd(ctx, "st", 0, map[string]interface{}{"v": i}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	msgs, err := c.XReadGroup(ctx, "st", "g", "me", 10, 0)
+	if err != nil || len(msgs) != 3 {
+		t.Fatalf("XReadGroup = %d, %v", len(msgs), err)
+	}
+	sc := c.NewStreamConsumer("st", "g", "me", nil)
+	handled := 0
+	// 处理第一条时ctx结束,剩余消息不再处理
+	sc.handle(ctx, msgs, func(ctx context.Context, msg StreamMessage) error {
+		handled++
+		cancel()
+		return nil
+	})
+	if handled != 1 {
+		t.Fatalf("handled = %d, want 1", handled)
+	}
+}
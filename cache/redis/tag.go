@@ -0,0 +1,190 @@
+package redis
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/gomodule/redigo/redis"
+	"github.com/thesky9531/lareina/log"
+)
+
+// 将KEYS[1]加入KEYS[2]起的标签集合,标签集合的过期时间不短于KEYS[1],拼接在写入脚本之后使用
+const tagKeysLua = `
+local ttl = redis.call("PTTL", KEYS[1])
+for i = 2, #KEYS do
+	redis.call("SADD", KEYS[i], KEYS[1])
+	if ttl < 0 then
+		redis.call("PERSIST", KEYS[i])
+	elseif redis.call("PTTL", KEYS[i]) < ttl then
+		redis.call("PEXPIRE", KEYS[i], ttl)
+	end
+end`
+
+// 写入string并添加标签,ARGV[1]为值,ARGV[2]为过期时间
+var setTaggedScript = RegisterScript("setTagged", -1, `
+redis.call("SET", KEYS[1], ARGV[1], "EX", ARGV[2])`+tagKeysLua+`
+return 1`)
+
+// 给已存在的key添加标签
+var tagKeyScript = RegisterScript("tagKey", -1, `
+if redis.call("EXISTS", KEYS[1]) == 0 then
+	return 0
+end`+tagKeysLua+`
+return 1`)
+
+// 删除KEYS[ARGV[1]+1]起的缓存key,并从KEYS[1]到KEYS[ARGV[1]]的标签集合中移除,
+// 读取标签后新加入的key不受影响
+var invalidateTagsScript = RegisterScript("invalidateTags", -1, `
+local ntags = tonumber(ARGV[1])
+local n = 0
+for i = ntags + 1, #KEYS do
+	n = n + redis.call("DEL", KEYS[i])
+	for j = 1, ntags do
+		redis.call("SREM", KEYS[j], KEYS[i])
+	end
+end
+return n`)
+
+// 移除标签下已过期或已删除的key
+var pruneTagScript = RegisterScript("pruneTag", 1, `
+local n = 0
+local keys = redis.call("SMEMBERS", KEYS[1])
+for _, k in ipairs(keys) do
+	if redis.call("EXISTS", k) == 0 then
+		n = n + redis.call("SREM", KEYS[1], k)
+	end
+end
+return n`)
+
+// 标签集合的key,集合成员为打了该标签的缓存key
+func getTagKey(tag string) string {
+	return fmt.Sprintf("cachetag_%s", tag)
+}
+
+// 标签集合的完整key,集合成员为带命名空间的完整key
+func (c *Cache) tagKeys(tags []string) []string {
+	keys := make([]string, 0, len(tags))
+	for _, tag := range tags {
+		keys = append(keys, c.key(getTagKey(tag)))
+	}
+	return keys
+}
+
+// 生成带标签写入脚本的参数,依次为key数量、key、标签集合和其他参数
+func taggedArgs(key string, tagKeys []string, args ...interface{}) []interface{} {
+	rsp := make([]interface{}, 0, len(tagKeys)+len(args)+2)
+	rsp = append(rsp, len(tagKeys)+1, key)
+	for _, k := range tagKeys {
+		rsp = append(rsp, k)
+	}
+	return append(rsp, args...)
+}
+
+// 设置空列表哨兵,有标签时在同一脚本中写入标签集合
+func setSentinel(conn redis.Conn, key string, expireTime int, tagKeys []string) (err error) {
+	if len(tagKeys) == 0 {
+		_, err = conn.Do("SETEX", key, expireTime, "emptylist")
+	} else {
+		_, err = setTaggedScript.Do(conn, taggedArgs(key, tagKeys, "emptylist", expireTime)...)
+	}
+	return err
+}
+
+func (c *Cache) invalidateTags(conn redis.Conn, tags []string) (int64, error) {
+	tagKeys := c.tagKeys(tags)
+	for _, k := range tagKeys {
+		if err := conn.Send("SMEMBERS", k); err != nil {
+			err = fmt.Errorf("invalidateTags conn.Send(SMEMBERS, %s) error(%v)", k, err)
+			log.ErrLog("", err)
+			return 0, err
+		}
+	}
+	reply, err := redis.Values(conn.Do(""))
+	if err != nil {
+		err = fmt.Errorf("invalidateTags tags(%v) error(%v)", tags, err)
+		log.ErrLog("", err)
+		return 0, err
+	}
+	args := make([]interface{}, 0, len(tagKeys)+1)
+	args = append(args, 0)
+	for _, k := range tagKeys {
+		args = append(args, k)
+	}
+	seen := make(map[string]bool)
+	for _, r := range reply {
+		members, err := redis.Strings(r, nil)
+		if err != nil {
+			err = fmt.Errorf("invalidateTags tags(%v) error(%v)", tags, err)
+			log.ErrLog("", err)
+			return 0, err
+		}
+		for _, m := range members {
+			if !seen[m] {
+				seen[m] = true
+				args = append(args, m)
+			}
+		}
+	}
+	if len(seen) == 0 {
+		return 0, nil
+	}
+	args[0] = len(args) - 1
+	args = append(args, len(tagKeys))
+	n, err := redis.Int64(invalidateTagsScript.Do(conn, args...))
+	for k := range seen {
+		c.hotDelRaw(k)
+	}
+	if err != nil {
+		err = fmt.Errorf("invalidateTags invalidateTagsScript.Do(%v) error(%v)", tags, err)
+		log.ErrLog("", err)
+	}
+	return n, err
+}
+
+// 给已存在的缓存key添加标签,key不存在时不处理
+func (c *Cache) TagKey(ctx context.Context, key string, tags ...string) error {
+	if len(tags) == 0 {
+		return nil
+	}
+	conn, err := c.getConn(ctx)
+	if err != nil {
+		log.ErrLog("", fmt.Errorf("获取redis conn失败 key(%s),error(%v)", key, err))
+		return err
+	}
+	defer conn.Close()
+	if _, err = tagKeyScript.Do(conn, taggedArgs(c.key(key), c.tagKeys(tags))...); err != nil {
+		err = fmt.Errorf("TagKey tagKeyScript.Do(%s, %v) error(%v)", key, tags, err)
+		log.ErrLog("", err)
+	}
+	return err
+}
+
+// 删除带有任一标签的所有缓存key并从标签集合中移除,返回删除的key数量
+func (c *Cache) InvalidateTags(ctx context.Context, tags ...string) (int64, error) {
+	if len(tags) == 0 {
+		return 0, nil
+	}
+	conn, err := c.getConn(ctx)
+	if err != nil {
+		log.ErrLog("", fmt.Errorf("获取redis conn失败 tags(%v),error(%v)", tags, err))
+		return 0, err
+	}
+	defer conn.Close()
+	return c.invalidateTags(conn, tags)
+}
+
+// 清理标签集合中已经不存在的key,返回清理数量
+func (c *Cache) PruneTag(ctx context.Context, tag string) (int64, error) {
+	conn, err := c.getConn(ctx)
+	if err != nil {
+		log.ErrLog("", fmt.Errorf("获取redis conn失败 tag(%s),error(%v)", tag, err))
+		return 0, err
+	}
+	defer conn.Close()
+	n, err := redis.Int64(pruneTagScript.Do(conn, c.key(getTagKey(tag))))
+	if err != nil {
+		err = fmt.Errorf("PruneTag pruneTagScript.Do(%s) error(%v)", tag, err)
+		log.ErrLog("", err)
+	}
+	return n, err
+}
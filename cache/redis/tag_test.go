@@ -0,0 +1,61 @@
+package redis
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestTaggedSet(t *testing.T) {
+	c, s := newTestCache(t, &Config{ExpireTime: 60})
+	ctx := context.Background()
+	tag := c.key(getTagKey("user:1"))
+	if err := c.SetRawMessage(ctx, "a", []byte(`1`), "user:1"); err != nil {
+		t.Fatal(err)
+	}
+	if ok, _ := s.SIsMember(tag, c.key("a")); !ok {
+		t.Fatal("key not tagged")
+	}
+	if ttl := s.TTL(tag); ttl < 60*time.Second {
+		t.Fatalf("tag ttl = %v", ttl)
+	}
+	// 标签集合的过期时间只延长不缩短
+	s.SetTTL(tag, time.Hour)
+	if err := c.SetIdSet(ctx, "b", []int64{1, 2}, "user:1"); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.SetNameList(ctx, "c", nil, "user:1"); err != nil {
+		t.Fatal(err)
+	}
+	if ttl := s.TTL(tag); ttl != time.Hour {
+		t.Fatalf("tag ttl shortened to %v", ttl)
+	}
+	members, _ := s.Members(tag)
+	if len(members) != 3 {
+		t.Fatalf("tag members = %v", members)
+	}
+
+	// 不存在的key不添加标签
+	if err := c.TagKey(ctx, "missing", "user:2"); err != nil {
+		t.Fatal(err)
+	}
+	if s.Exists(c.key(getTagKey("user:2"))) {
+		t.Fatal("missing key tagged")
+	}
+	if err := c.TagKey(ctx, "a", "user:2"); err != nil {
+		t.Fatal(err)
+	}
+
+	n, err := c.InvalidateTags(ctx, "user:1", "user:2", "user:3")
+	if err != nil || n != 3 {
+		t.Fatalf("InvalidateTags = %d, %v", n, err)
+	}
+	for _, k := range []string{"a", "b", "c", getTagKey("user:1"), getTagKey("user:2")} {
+		if s.Exists(c.key(k)) {
+			t.Errorf("%s not deleted", k)
+		}
+	}
+	if n, err = c.InvalidateTags(ctx, "user:1"); err != nil || n != 0 {
+		t.Fatalf("InvalidateTags empty = %d, %v", n, err)
+	}
+}
@@ -0,0 +1,264 @@
+package redis
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gomodule/redigo/redis"
+	"github.com/thesky9531/lareina/log"
+	xtime "github.com/thesky9531/lareina/time"
+)
+
+// 降采样聚合方式
+const (
+	AggregateAvg   = "avg"
+	AggregateMax   = "max"
+	AggregateMin   = "min"
+	AggregateSum   = "sum"
+	AggregateCount = "count"
+)
+
+// 写入数据点并按条数/时间裁剪,整体在一个脚本内完成,member为空时只裁剪
+var addSampleScript = RegisterScript("addSample", 1, `
+if ARGV[2] ~= "" then
+	redis.call("ZADD", KEYS[1], ARGV[1], ARGV[2])
+end
+if tonumber(ARGV[4]) > 0 then
+	redis.call("ZREMRANGEBYSCORE", KEYS[1], "-inf", "(" .. ARGV[4])
+end
+if tonumber(ARGV[3]) > 0 then
+	redis.call("ZREMRANGEBYRANK", KEYS[1], 0, -tonumber(ARGV[3]) - 1)
+end
+if tonumber(ARGV[5]) > 0 then
+	redis.call("EXPIRE", KEYS[1], ARGV[5])
+end
+return redis.call("ZCARD", KEYS[1])`)
+
+// 时间序列数据点
+type Sample struct {
+	Time  time.Time
+	Value float64
+}
+
+// 时间序列保留策略,零值表示不限制
+type Retention struct {
+	MaxLen int64         // 最多保留的数据点数量
+	MaxAge time.Duration // 数据点最长保留时间
+}
+
+func (r Retention) minScore(now time.Time) int64 {
+	if r.MaxAge <= 0 {
+		return 0
+	}
+	return now.Add(-r.MaxAge).UnixNano() / int64(time.Millisecond)
+}
+
+func (r Retention) expireSeconds() int64 {
+	if r.MaxAge <= 0 {
+		return 0
+	}
+	sec := int64(r.MaxAge / time.Second)
+	if sec <= 0 {
+		sec = 1
+	}
+	return sec
+}
+
+// 数据点以zset存储,score为毫秒时间戳,member为 纳秒时间戳:值
+func encodeSample(s Sample) (score int64, member string) {
+	return s.Time.UnixNano() / int64(time.Millisecond),
+		strconv.FormatInt(s.Time.UnixNano(), 10) + ":" + strconv.FormatFloat(s.Value, 'f', -1, 64)
+}
+
+func decodeSample(member string) (s Sample, err error) {
+	idx := strings.IndexByte(member, ':')
+	if idx <= 0 {
+		err = fmt.Errorf("decodeSample invalid member(%s)", member)
+		return
+	}
+	ns, err := strconv.ParseInt(member[:idx], 10, 64)
+	if err != nil {
+		return
+	}
+	s.Value, err = strconv.ParseFloat(member[idx+1:], 64)
+	if err != nil {
+		return
+	}
+	s.Time = time.Unix(0, ns)
+	return
+}
+
+func decodeSamples(key string, members []string) ([]Sample, error) {
+	rsp := make([]Sample, 0, len(members))
+	for _, m := range members {
+		s, err := decodeSample(m)
+		if err != nil {
+			err = fmt.Errorf("decodeSamples key(%s) error(%v)", key, err)
+			log.ErrLog("", err)
+			return nil, err
+		}
+		rsp = append(rsp, s)
+	}
+	return rsp, nil
+}
+
+func addSample(conn redis.Conn, key string, sample Sample, retention Retention) error {
+	score, member := encodeSample(sample)
+	_, err := addSampleScript.Do(conn, key, score, member,
+		retention.MaxLen, retention.minScore(time.Now()), retention.expireSeconds())
+	if err != nil {
+		err = fmt.Errorf("addSample addSampleScript.Do(%s) error(%v)", key, err)
+		log.ErrLog("", err)
+	}
+	return err
+}
+
+func trimSamples(conn redis.Conn, key string, retention Retention) error {
+	_, err := addSampleScript.Do(conn, key, 0, "", retention.MaxLen, retention.minScore(time.Now()), 0)
+	if err != nil {
+		err = fmt.Errorf("trimSamples addSampleScript.Do(%s) error(%v)", key, err)
+		log.ErrLog("", err)
+	}
+	return err
+}
+
+func rangeSamples(conn redis.Conn, key string, start, end time.Time) ([]Sample, error) {
+	min := start.UnixNano() / int64(time.Millisecond)
+	max := end.UnixNano() / int64(time.Millisecond)
+	members, err := redis.Strings(conn.Do("ZRANGEBYSCORE", key, min, max))
+	if err != nil && err != redis.ErrNil {
+		err = fmt.Errorf("rangeSamples conn.Do(ZRANGEBYSCORE, %s, %d, %d) error(%v)", key, min, max, err)
+		log.ErrLog("", err)
+		return nil, err
+	}
+	return decodeSamples(key, members)
+}
+
+func latestSamples(conn redis.Conn, key string, n int64) ([]Sample, error) {
+	members, err := redis.Strings(conn.Do("ZRANGE", key, -n, -1))
+	if err != nil && err != redis.ErrNil {
+		err = fmt.Errorf("latestSamples conn.Do(ZRANGE, %s, %d) error(%v)", key, -n, err)
+		log.ErrLog("", err)
+		return nil, err
+	}
+	return decodeSamples(key, members)
+}
+
+// 按时间间隔聚合数据点,间隔取值同time包 TimeIntervalMin/TimeIntervalHour 等
+func downsample(samples []Sample, interval uint32, aggregate string) ([]Sample, error) {
+	if !xtime.IsValidInterval(interval) {
+		return nil, fmt.Errorf("downsample unsupported interval(%d)", interval)
+	}
+	type bucket struct {
+		start time.Time
+		sum   float64
+		max   float64
+		min   float64
+		count int64
+	}
+	buckets := make(map[int64]*bucket)
+	for _, s := range samples {
+		start := xtime.GetIntervalStart(s.Time, interval)
+		b, ok := buckets[start.UnixNano()]
+		if !ok {
+			b = &bucket{start: start, max: s.Value, min: s.Value}
+			buckets[start.UnixNano()] = b
+		}
+		b.sum += s.Value
+		b.count++
+		if s.Value > b.max {
+			b.max = s.Value
+		}
+		if s.Value < b.min {
+			b.min = s.Value
+		}
+	}
+	rsp := make([]Sample, 0, len(buckets))
+	for _, b := range buckets {
+		s := Sample{Time: b.start}
+		switch aggregate {
+		case AggregateAvg:
+			s.Value = b.sum / float64(b.count)
+		case AggregateMax:
+			s.Value = b.max
+		case AggregateMin:
+			s.Value = b.min
+		case AggregateSum:
+			s.Value = b.sum
+		case AggregateCount:
+			s.Value = float64(b.count)
+		default:
+			return nil, fmt.Errorf("downsample unsupported aggregate(%s)", aggregate)
+		}
+		rsp = append(rsp, s)
+	}
+	sort.Slice(rsp, func(i, j int) bool {
+		return rsp[i].Time.Before(rsp[j].Time)
+	})
+	return rsp, nil
+}
+
+// 写入时间序列数据点,同时按保留策略裁剪
+func (c *Cache) AddSample(ctx context.Context, key string, sample Sample, retention Retention) error {
+	conn, err := c.getConn(ctx)
+	if err != nil {
+		log.ErrLog("", fmt.Errorf("获取redis conn失败 key(%s),error(%v)", key, err))
+		return err
+	}
+	defer conn.Close()
+	return addSample(conn, c.nsKey(key), sample, retention)
+}
+
+// 按保留策略裁剪时间序列
+func (c *Cache) TrimSamples(ctx context.Context, key string, retention Retention) error {
+	conn, err := c.getConn(ctx)
+	if err != nil {
+		log.ErrLog("", fmt.Errorf("获取redis conn失败 key(%s),error(%v)", key, err))
+		return err
+	}
+	defer conn.Close()
+	return trimSamples(conn, c.nsKey(key), retention)
+}
+
+// 获取时间范围内的数据点(包含起止时间)
+func (c *Cache) RangeSamples(ctx context.Context, key string, start, end time.Time) ([]Sample, error) {
+	conn, err := c.getConn(ctx)
+	if err != nil {
+		log.ErrLog("", fmt.Errorf("获取redis conn失败 key(%s),error(%v)", key, err))
+		return nil, err
+	}
+	defer conn.Close()
+	return rangeSamples(conn, c.nsKey(key), start, end)
+}
+
+// 获取最新的n个数据点,按时间升序
+func (c *Cache) LatestSamples(ctx context.Context, key string, n int64) ([]Sample, error) {
+	if n <= 0 {
+		return []Sample{}, nil
+	}
+	conn, err := c.getConn(ctx)
+	if err != nil {
+		log.ErrLog("", fmt.Errorf("获取redis conn失败 key(%s),error(%v)", key, err))
+		return nil, err
+	}
+	defer conn.Close()
+	return latestSamples(conn, c.nsKey(key), n)
+}
+
+// 获取时间范围内按分钟/小时等间隔聚合后的数据点
+func (c *Cache) DownsampleSamples(ctx context.Context, key string, start, end time.Time,
+	interval uint32, aggregate string) ([]Sample, error) {
+	samples, err := c.RangeSamples(ctx, key, start, end)
+	if err != nil {
+		return nil, err
+	}
+	rsp, err := downsample(samples, interval, aggregate)
+	if err != nil {
+		log.ErrLog("", err)
+	}
+	return rsp, err
+}
@@ -0,0 +1,59 @@
+package redis
+
+import (
+	"testing"
+	"time"
+
+	xtime "github.com/thesky9531/lareina/time"
+)
+
+func TestDownsample(t *testing.T) {
+	base := time.Date(2021, 8, 18, 13, 0, 0, 0, time.UTC)
+	samples := []Sample{
+		{Time: base.Add(10 * time.Second), Value: 1},
+		{Time: base.Add(50 * time.Second), Value: 3},
+		{Time: base.Add(70 * time.Second), Value: 5},
+	}
+	cases := []struct {
+		aggregate string
+		want      []float64
+	}{
+		{AggregateAvg, []float64{2, 5}},
+		{AggregateMax, []float64{3, 5}},
+		{AggregateMin, []float64{1, 5}},
+		{AggregateSum, []float64{4, 5}},
+		{AggregateCount, []float64{2, 1}},
+	}
+	for _, c := range cases {
+		rsp, err := downsample(samples, xtime.TimeIntervalMin, c.aggregate)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(rsp) != len(c.want) {
+			t.Fatalf("%s: got %v", c.aggregate, rsp)
+		}
+		for i, s := range rsp {
+			if s.Value != c.want[i] || !s.Time.Equal(base.Add(time.Duration(i)*time.Minute)) {
+				t.Errorf("%s[%d] = %+v, want %v", c.aggregate, i, s, c.want[i])
+			}
+		}
+	}
+	if _, err := downsample(samples, 0, AggregateAvg); err == nil {
+		t.Error("unsupported interval accepted")
+	}
+	if _, err := downsample(samples, xtime.TimeIntervalMin, "median"); err == nil {
+		t.Error("unsupported aggregate accepted")
+	}
+}
+
+func TestSampleCodec(t *testing.T) {
+	s := Sample{Time: time.Unix(0, 1629291600123456789), Value: -1.5}
+	_, member := encodeSample(s)
+	got, err := decodeSample(member)
+	if err != nil || !got.Time.Equal(s.Time) || got.Value != s.Value {
+		t.Fatalf("decodeSample(%s) = %+v, %v", member, got, err)
+	}
+	if _, err = decodeSample("bad"); err == nil {
+		t.Error("invalid member accepted")
+	}
+}
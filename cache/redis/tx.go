@@ -0,0 +1,197 @@
+package redis
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/rand"
+	"time"
+
+	"github.com/gomodule/redigo/redis"
+	"github.com/thesky9531/lareina/log"
+)
+
+// 事务重试次数用尽仍然冲突时返回该错误
+var ErrTxConflict = errors.New("redis: transaction conflict, retries exhausted")
+
+const (
+	defaultTxMaxRetry = 5
+	txBackoff         = 10 * time.Millisecond
+	txMaxBackoff      = 500 * time.Millisecond
+)
+
+type txCmd struct {
+	name string
+	args []interface{}
+}
+
+// Tx 乐观事务,读操作立即执行,写操作排队在EXEC时原子执行。
+// 方法中的key与Cache方法一致,会自动加上命名空间前缀。
+type Tx struct {
+	c    *Cache
+	conn redis.Conn
+	cmds []txCmd
+	// 写入或删除的key,EXEC成功后清除热点key的本地缓存
+	dirty []string
+}
+
+// 完整的redis key,用于 Do/Queue 原始命令
+func (tx *Tx) Key(key string) string {
+	return tx.c.key(key)
+}
+
+// 立即执行读命令
+func (tx *Tx) Do(cmd string, args ...interface{}) (interface{}, error) {
+	return tx.conn.Do(cmd, args...)
+}
+
+// 排队写命令,EXEC时执行
+func (tx *Tx) Queue(cmd string, args ...interface{}) {
+	tx.cmds = append(tx.cmds, txCmd{name: cmd, args: args})
+}
+
+// 读取字符串值,不存在时返回 redis.ErrNil
+func (tx *Tx) GetRawMessage(key string) (json.RawMessage, error) {
+	data, err := redis.Bytes(tx.conn.Do("GET", tx.Key(key)))
+	if err != nil {
+		return nil, err
+	}
+	return tx.c.decodeValue(data)
+}
+
+func (tx *Tx) GetObject(key string, obj interface{}) error {
+	data, err := tx.GetRawMessage(key)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(data, obj)
+}
+
+func (tx *Tx) GetInt64(key string) (int64, error) {
+	return redis.Int64(tx.conn.Do("GET", tx.Key(key)))
+}
+
+func (tx *Tx) SetRawMessage(key string, data json.RawMessage) error {
+	data, err := tx.c.encodeValue(data)
+	if err != nil {
+		return err
+	}
+	tx.Queue("SET", tx.Key(key), []byte(data), SetWithExpireTime, tx.c.conf.ExpireTime)
+	tx.dirty = append(tx.dirty, key)
+	return nil
+}
+
+func (tx *Tx) SetObject(key string, obj interface{}) error {
+	data, err := json.Marshal(obj)
+	if err != nil {
+		return err
+	}
+	return tx.SetRawMessage(key, data)
+}
+
+func (tx *Tx) SetInt64(key string, data int64) {
+	tx.Queue("SET", tx.Key(key), data, SetWithExpireTime, tx.c.conf.ExpireTime)
+	tx.dirty = append(tx.dirty, key)
+}
+
+func (tx *Tx) HIncrBy(key string, field string, num int64) {
+	tx.Queue("HINCRBY", tx.Key(key), field, num)
+	tx.Queue("EXPIRE", tx.Key(key), tx.c.conf.ExpireTime)
+}
+
+func (tx *Tx) Del(keys ...string) {
+	for _, key := range keys {
+		tx.Queue("DEL", tx.Key(key))
+	}
+	tx.dirty = append(tx.dirty, keys...)
+}
+
+// 执行一次 WATCH/MULTI/EXEC,返回是否因为key被修改而失败,keys为空时不WATCH
+func (c *Cache) runTx(ctx context.Context, keys []string, fn func(tx *Tx) error) (conflict bool, err error) {
+	args := make([]interface{}, 0, len(keys))
+	for _, k := range keys {
+		args = append(args, c.key(k))
+	}
+	conn, err := c.getConn(ctx)
+	if err != nil {
+		log.ErrLog("", fmt.Errorf("获取redis conn失败 keys(%v),error(%v)", keys, err))
+		return false, err
+	}
+	defer conn.Close()
+	if len(args) > 0 {
+		if _, err = conn.Do("WATCH", args...); err != nil {
+			err = fmt.Errorf("Transaction conn.Do(WATCH, %v) error(%v)", keys, err)
+			log.ErrLog("", err)
+			return false, err
+		}
+	}
+	tx := &Tx{c: c, conn: conn}
+	if err = fn(tx); err != nil || len(tx.cmds) == 0 {
+		if len(args) > 0 {
+			conn.Do("UNWATCH")
+		}
+		return false, err
+	}
+	// 发送失败时连接放回连接池会自动DISCARD/UNWATCH
+	if err = conn.Send("MULTI"); err != nil {
+		err = fmt.Errorf("Transaction conn.Send(MULTI, %v) error(%v)", keys, err)
+		log.ErrLog("", err)
+		return false, err
+	}
+	for _, cmd := range tx.cmds {
+		if err = conn.Send(cmd.name, cmd.args...); err != nil {
+			err = fmt.Errorf("Transaction conn.Send(%s, %v) error(%v)", cmd.name, keys, err)
+			log.ErrLog("", err)
+			return false, err
+		}
+	}
+	replies, err := redis.Values(conn.Do("EXEC"))
+	if err == redis.ErrNil {
+		return true, nil
+	}
+	// EXEC已执行时写操作可能部分生效,统一清除
+	c.hotDel(tx.dirty...)
+	if err != nil {
+		err = fmt.Errorf("Transaction conn.Do(EXEC, %v) error(%v)", keys, err)
+		log.ErrLog("", err)
+		return false, err
+	}
+	for i, r := range replies {
+		if e, ok := r.(redis.Error); ok {
+			err = fmt.Errorf("Transaction %s error(%v)", tx.cmds[i].name, e)
+			log.ErrLog("", err)
+			return false, err
+		}
+	}
+	return false, nil
+}
+
+// Transaction WATCH keys后调用fn读取数据并排队写操作,EXEC时keys被其他客户端修改则按退避时间重试,
+// 超过 Config.TxMaxRetry(默认5)次返回 ErrTxConflict。fn可能被调用多次,不应有其他副作用。
+// keys为空时不WATCH,只保证写操作原子执行。
+func (c *Cache) Transaction(ctx context.Context, keys []string, fn func(tx *Tx) error) error {
+	maxRetry := c.conf.TxMaxRetry
+	if maxRetry <= 0 {
+		maxRetry = defaultTxMaxRetry
+	}
+	backoff := txBackoff
+	for i := 0; ; i++ {
+		conflict, err := c.runTx(ctx, keys, fn)
+		if err != nil || !conflict {
+			return err
+		}
+		if i >= maxRetry {
+			return ErrTxConflict
+		}
+		wait := backoff/2 + time.Duration(rand.Int63n(int64(backoff)))
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(wait):
+		}
+		if backoff *= 2; backoff > txMaxBackoff {
+			backoff = txMaxBackoff
+		}
+	}
+}
@@ -0,0 +1,60 @@
+package redis
+
+import (
+	"context"
+	"testing"
+)
+
+func TestTransaction(t *testing.T) {
+	c, s := newTestCache(t, &Config{Namespace: "app", TxMaxRetry: 2})
+	ctx := context.Background()
+	if err := c.SetInt64(ctx, "balance", 10); err != nil {
+		t.Fatal(err)
+	}
+	// 第一次执行时其他客户端修改了key,重试后成功
+	calls := 0
+	err := c.Transaction(ctx, []string{"balance"}, func(tx *Tx) error {
+		calls++
+		n, err := tx.GetInt64("balance")
+		if err != nil {
+			return err
+		}
+		if calls == 1 {
+			s.Set("app:balance", "20")
+		}
+		tx.SetInt64("balance", n-5)
+		return nil
+	})
+	if err != nil || calls != 2 {
+		t.Fatalf("Transaction = %v, calls = %d", err, calls)
+	}
+	if n, _ := c.GetInt64(ctx, "balance"); n != 15 {
+		t.Fatalf("balance = %d", n)
+	}
+
+	// 一直冲突时返回ErrTxConflict
+	calls = 0
+	err = c.Transaction(ctx, []string{"balance"}, func(tx *Tx) error {
+		calls++
+		tx.GetInt64("balance")
+		s.Set("app:balance", "1")
+		tx.SetInt64("balance", 0)
+		return nil
+	})
+	if err != ErrTxConflict || calls != 3 {
+		t.Fatalf("conflict = %v, calls = %d", err, calls)
+	}
+
+	// keys为空时不WATCH,写操作仍然执行
+	err = c.Transaction(ctx, nil, func(tx *Tx) error {
+		tx.SetInt64("a", 1)
+		tx.Del("balance")
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n, _ := c.GetInt64(ctx, "a"); n != 1 || s.Exists("app:balance") {
+		t.Fatalf("empty keys tx a = %d, balance exists = %v", n, s.Exists("app:balance"))
+	}
+}
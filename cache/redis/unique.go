@@ -0,0 +1,265 @@
+package redis
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/gomodule/redigo/redis"
+	"github.com/thesky9531/lareina/log"
+	xtime "github.com/thesky9531/lareina/time"
+)
+
+// 按天统计的key保留天数
+const statKeyExpireDays = 90
+
+// 对多个bitmap做BITOP后计数,临时key用完即删除
+var bitopCountScript = RegisterScript("bitopCount", -1, `
+redis.call("BITOP", ARGV[1], unpack(KEYS))
+local n = redis.call("BITCOUNT", KEYS[1])
+redis.call("DEL", KEYS[1])
+return n`)
+
+// 按天生成统计key,格式为 key_20060102
+func statDayKey(key string, day time.Time) string {
+	return fmt.Sprintf("%s_%s", key, xtime.GetOnedayStart(day).Format("20060102"))
+}
+
+// 获取起止时间内(包含起止当天)每天的统计key
+func statDayKeys(key string, start, end time.Time) []string {
+	keys := make([]string, 0)
+	end = xtime.GetOnedayStart(end)
+	for d := xtime.GetOnedayStart(start); !d.After(end); d = d.AddDate(0, 0, 1) {
+		keys = append(keys, statDayKey(key, d))
+	}
+	return keys
+}
+
+// 获取t所在周期(TimeIntervalDay/TimeIntervalWeek/TimeIntervalMonth等)内每天的统计key,
+// 统计按天存储,不支持分钟、小时等小于一天的周期
+func statPeriodKeys(key string, t time.Time, interval uint32) ([]string, error) {
+	switch interval {
+	case xtime.TimeIntervalDay, xtime.TimeIntervalTwoDay, xtime.TimeIntervalWeek,
+		xtime.TimeIntervalMonth, xtime.TimeIntervalQuarter, xtime.TimeIntervalYear:
+	default:
+		return nil, fmt.Errorf("statPeriodKeys unsupported interval(%d)", interval)
+	}
+	start := xtime.GetIntervalStart(t, interval)
+	end := xtime.GetNextIntervalStart(t, interval).Add(-1)
+	return statDayKeys(key, start, end), nil
+}
+
+func pfAdd(conn redis.Conn, key string, members []string) error {
+	args := make([]interface{}, 0, len(members)+1)
+	args = append(args, key)
+	for _, m := range members {
+		args = append(args, m)
+	}
+	if _, err := conn.Do("PFADD", args...); err != nil {
+		err = fmt.Errorf("pfAdd conn.Do(PFADD, %s) error(%v)", key, err)
+		log.ErrLog("", err)
+		return err
+	}
+	_, err := conn.Do("EXPIRE", key, statKeyExpireDays*24*3600)
+	if err != nil {
+		log.ErrLog("", err)
+	}
+	return err
+}
+
+func pfCount(conn redis.Conn, keys []string) (int64, error) {
+	args := make([]interface{}, 0, len(keys))
+	for _, k := range keys {
+		args = append(args, k)
+	}
+	count, err := redis.Int64(conn.Do("PFCOUNT", args...))
+	if err != nil && err != redis.ErrNil {
+		err = fmt.Errorf("pfCount conn.Do(PFCOUNT, %v) error(%v)", keys, err)
+		log.ErrLog("", err)
+		return 0, err
+	}
+	return count, nil
+}
+
+func pfMerge(conn redis.Conn, destKey string, keys []string, expireTime int) error {
+	args := make([]interface{}, 0, len(keys)+1)
+	args = append(args, destKey)
+	for _, k := range keys {
+		args = append(args, k)
+	}
+	if _, err := conn.Do("PFMERGE", args...); err != nil {
+		err = fmt.Errorf("pfMerge conn.Do(PFMERGE, %s, %v) error(%v)", destKey, keys, err)
+		log.ErrLog("", err)
+		return err
+	}
+	_, err := conn.Do("EXPIRE", destKey, expireTime)
+	if err != nil {
+		log.ErrLog("", err)
+	}
+	return err
+}
+
+func setBit(conn redis.Conn, key string, offset int64, expireTime int) error {
+	if _, err := conn.Do("SETBIT", key, offset, 1); err != nil {
+		err = fmt.Errorf("setBit conn.Do(SETBIT, %s, %d) error(%v)", key, offset, err)
+		log.ErrLog("", err)
+		return err
+	}
+	_, err := conn.Do("EXPIRE", key, expireTime)
+	if err != nil {
+		log.ErrLog("", err)
+	}
+	return err
+}
+
+func getBit(conn redis.Conn, key string, offset int64) (bool, error) {
+	ok, err := redis.Bool(conn.Do("GETBIT", key, offset))
+	if err != nil && err != redis.ErrNil {
+		err = fmt.Errorf("getBit conn.Do(GETBIT, %s, %d) error(%v)", key, offset, err)
+		log.ErrLog("", err)
+		return false, err
+	}
+	return ok, nil
+}
+
+func bitCount(conn redis.Conn, key string) (int64, error) {
+	count, err := redis.Int64(conn.Do("BITCOUNT", key))
+	if err != nil && err != redis.ErrNil {
+		err = fmt.Errorf("bitCount conn.Do(BITCOUNT, %s) error(%v)", key, err)
+		log.ErrLog("", err)
+		return 0, err
+	}
+	return count, nil
+}
+
+// op 取值 AND/OR/XOR
+func bitopCount(conn redis.Conn, op string, tmpKey string, keys []string) (int64, error) {
+	args := make([]interface{}, 0, len(keys)+3)
+	args = append(args, len(keys)+1, tmpKey)
+	for _, k := range keys {
+		args = append(args, k)
+	}
+	args = append(args, op)
+	count, err := redis.Int64(bitopCountScript.Do(conn, args...))
+	if err != nil && err != redis.ErrNil {
+		err = fmt.Errorf("bitopCount bitopCountScript.Do(%s, %v) error(%v)", op, keys, err)
+		log.ErrLog("", err)
+		return 0, err
+	}
+	return count, nil
+}
+
+// 记录某天的访客,使用HyperLogLog去重计数
+func (c *Cache) AddUniqueVisitor(ctx context.Context, key string, day time.Time, members ...string) error {
+	if len(members) == 0 {
+		return nil
+	}
+	conn, err := c.getConn(ctx)
+	if err != nil {
+		log.ErrLog("", fmt.Errorf("获取redis conn失败 key(%s),error(%v)", key, err))
+		return err
+	}
+	defer conn.Close()
+	return pfAdd(conn, statDayKey(c.nsKey(key), day), members)
+}
+
+// 获取起止日期内(包含起止当天)的去重访客数
+func (c *Cache) CountUniqueVisitors(ctx context.Context, key string, start, end time.Time) (int64, error) {
+	conn, err := c.getConn(ctx)
+	if err != nil {
+		log.ErrLog("", fmt.Errorf("获取redis conn失败 key(%s),error(%v)", key, err))
+		return 0, err
+	}
+	defer conn.Close()
+	return pfCount(conn, statDayKeys(c.nsKey(key), start, end))
+}
+
+// 获取t所在周期内的去重访客数,interval取值同time包 TimeIntervalDay/TimeIntervalWeek/TimeIntervalMonth 等
+func (c *Cache) CountUniqueVisitorsPeriod(ctx context.Context, key string, t time.Time, interval uint32) (int64, error) {
+	keys, err := statPeriodKeys(c.nsKey(key), t, interval)
+	if err != nil {
+		log.ErrLog("", err)
+		return 0, err
+	}
+	conn, err := c.getConn(ctx)
+	if err != nil {
+		log.ErrLog("", fmt.Errorf("获取redis conn失败 key(%s),error(%v)", key, err))
+		return 0, err
+	}
+	defer conn.Close()
+	return pfCount(conn, keys)
+}
+
+// 将起止日期内每天的访客合并到destKey,用于保存周/月等汇总结果
+func (c *Cache) MergeUniqueVisitors(ctx context.Context, destKey string, key string, start, end time.Time) error {
+	conn, err := c.getConn(ctx)
+	if err != nil {
+		log.ErrLog("", fmt.Errorf("获取redis conn失败 key(%s),error(%v)", destKey, err))
+		return err
+	}
+	defer conn.Close()
+	return pfMerge(conn, c.nsKey(destKey), statDayKeys(c.nsKey(key), start, end), statKeyExpireDays*24*3600)
+}
+
+// 使用bitmap记录用户某天活跃,userID作为偏移量
+func (c *Cache) MarkActive(ctx context.Context, key string, day time.Time, userID int64) error {
+	conn, err := c.getConn(ctx)
+	if err != nil {
+		log.ErrLog("", fmt.Errorf("获取redis conn失败 key(%s),error(%v)", key, err))
+		return err
+	}
+	defer conn.Close()
+	return setBit(conn, statDayKey(c.nsKey(key), day), userID, statKeyExpireDays*24*3600)
+}
+
+// 判断用户某天是否活跃
+func (c *Cache) IsActive(ctx context.Context, key string, day time.Time, userID int64) (bool, error) {
+	conn, err := c.getConn(ctx)
+	if err != nil {
+		log.ErrLog("", fmt.Errorf("获取redis conn失败 key(%s),error(%v)", key, err))
+		return false, err
+	}
+	defer conn.Close()
+	return getBit(conn, statDayKey(c.nsKey(key), day), userID)
+}
+
+// 获取某天活跃用户数
+func (c *Cache) CountActive(ctx context.Context, key string, day time.Time) (int64, error) {
+	conn, err := c.getConn(ctx)
+	if err != nil {
+		log.ErrLog("", fmt.Errorf("获取redis conn失败 key(%s),error(%v)", key, err))
+		return 0, err
+	}
+	defer conn.Close()
+	return bitCount(conn, statDayKey(c.nsKey(key), day))
+}
+
+// 获取t所在周期内的活跃用户数,TimeIntervalDay/TimeIntervalWeek/TimeIntervalMonth 分别对应DAU/WAU/MAU
+func (c *Cache) CountActivePeriod(ctx context.Context, key string, t time.Time, interval uint32) (int64, error) {
+	keys, err := statPeriodKeys(c.nsKey(key), t, interval)
+	if err != nil {
+		log.ErrLog("", err)
+		return 0, err
+	}
+	conn, err := c.getConn(ctx)
+	if err != nil {
+		log.ErrLog("", fmt.Errorf("获取redis conn失败 key(%s),error(%v)", key, err))
+		return 0, err
+	}
+	defer conn.Close()
+	tmpKey := fmt.Sprintf("%s_or_%d", keys[0], len(keys))
+	return bitopCount(conn, "OR", tmpKey, keys)
+}
+
+// 获取cohortDay活跃的用户在n天后仍活跃的数量
+func (c *Cache) CountRetention(ctx context.Context, key string, cohortDay time.Time, n int) (int64, error) {
+	conn, err := c.getConn(ctx)
+	if err != nil {
+		log.ErrLog("", fmt.Errorf("获取redis conn失败 key(%s),error(%v)", key, err))
+		return 0, err
+	}
+	defer conn.Close()
+	keys := []string{statDayKey(c.nsKey(key), cohortDay), statDayKey(c.nsKey(key), cohortDay.AddDate(0, 0, n))}
+	tmpKey := fmt.Sprintf("%s_and_%d", keys[0], n)
+	return bitopCount(conn, "AND", tmpKey, keys)
+}
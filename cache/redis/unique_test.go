@@ -0,0 +1,35 @@
+package redis
+
+import (
+	"testing"
+	"time"
+
+	xtime "github.com/thesky9531/lareina/time"
+)
+
+func TestStatPeriodKeys(t *testing.T) {
+	d := time.Date(2021, 8, 18, 13, 0, 0, 0, time.UTC)
+	cases := []struct {
+		interval    uint32
+		first, last string
+		n           int
+	}{
+		{xtime.TimeIntervalDay, "uv_20210818", "uv_20210818", 1},
+		{xtime.TimeIntervalWeek, "uv_20210816", "uv_20210822", 7},
+		{xtime.TimeIntervalMonth, "uv_20210801", "uv_20210831", 31},
+	}
+	for _, c := range cases {
+		keys, err := statPeriodKeys("uv", d, c.interval)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(keys) != c.n || keys[0] != c.first || keys[len(keys)-1] != c.last {
+			t.Errorf("statPeriodKeys(%d) = %v", c.interval, keys)
+		}
+	}
+	for _, interval := range []uint32{0, xtime.TimeIntervalMin, xtime.TimeIntervalHour, 99} {
+		if keys, err := statPeriodKeys("uv", d, interval); err == nil {
+			t.Errorf("statPeriodKeys(%d) = %v, want error", interval, keys)
+		}
+	}
+}
@@ -0,0 +1,204 @@
+package redis
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/thesky9531/lareina/log"
+)
+
+// 预热任务,keys列出需要预热的key,load加载单个key写入缓存
+type warmupLoader struct {
+	name string
+	keys func(ctx context.Context) ([]string, error)
+	load func(ctx context.Context, key string) error
+}
+
+// 预热进度
+type WarmupProgress struct {
+	Loader string
+	Total  int64
+	Done   int64
+	Failed int64
+}
+
+// 单个key预热失败
+type WarmupError struct {
+	Loader string
+	Key    string
+	Err    error
+}
+
+func (e *WarmupError) Error() string {
+	return fmt.Sprintf("warmup loader(%s) key(%s) error(%v)", e.Loader, e.Key, e.Err)
+}
+
+// 预热结果
+type WarmupReport struct {
+	Progress []WarmupProgress
+	Errors   []*WarmupError
+	Elapsed  time.Duration
+}
+
+// 缓存预热,在flush或发布后预先加载热点key,避免冷缓存打满db。
+// 加载复用 Query* 的update函数,已缓存的key不会重复加载
+type Warmer struct {
+	store   Store
+	loaders []*warmupLoader
+
+	// 并发数,默认4
+	Concurrency int
+	// 每秒最多加载的key数量,0为不限制
+	Rate int
+	// 每完成一个key回调一次
+	OnProgress func(p WarmupProgress)
+	// 最多保留的错误数,默认100
+	MaxErrors int
+}
+
+func NewWarmer(s Store) *Warmer {
+	return &Warmer{
+		store:       s,
+		Concurrency: 4,
+		MaxErrors:   100,
+	}
+}
+
+// 注册自定义预热任务
+func (w *Warmer) Register(name string, keys func(ctx context.Context) ([]string, error),
+	load func(ctx context.Context, key string) error) {
+	w.loaders = append(w.loaders, &warmupLoader{name: name, keys: keys, load: load})
+}
+
+// 使用 QueryRawMessage 预热
+func (w *Warmer) RegisterRawMessage(name string, keys func(ctx context.Context) ([]string, error),
+	update func(key string) (json.RawMessage, error), opts ...QueryOption) {
+	w.Register(name, keys, func(ctx context.Context, key string) error {
+		_, err := w.store.QueryRawMessage(ctx, key, func() (json.RawMessage, error) { return update(key) }, opts...)
+		return err
+	})
+}
+
+// 使用 QueryHashObject 预热,newObj返回用于接收数据的对象指针
+func (w *Warmer) RegisterHashObject(name string, keys func(ctx context.Context) ([]string, error), fields []string,
+	newObj func() interface{}, update func(key string) ([]string, interface{}, error), opts ...QueryOption) {
+	w.Register(name, keys, func(ctx context.Context, key string) error {
+		return w.store.QueryHashObject(ctx, key, fields, newObj(),
+			func() ([]string, interface{}, error) { return update(key) }, opts...)
+	})
+}
+
+// 使用 QueryIdSet 预热
+func (w *Warmer) RegisterIdSet(name string, keys func(ctx context.Context) ([]string, error),
+	update func(key string) ([]int64, error), opts ...QueryOption) {
+	w.Register(name, keys, func(ctx context.Context, key string) error {
+		_, err := w.store.QueryIdSet(ctx, key, func() ([]int64, error) { return update(key) }, opts...)
+		return err
+	})
+}
+
+// 使用 QueryNameList 预热
+func (w *Warmer) RegisterNameList(name string, keys func(ctx context.Context) ([]string, error),
+	update func(key string) ([]KeyValue, error), opts ...QueryOption) {
+	w.Register(name, keys, func(ctx context.Context, key string) error {
+		_, err := w.store.QueryNameList(ctx, key, func() ([]KeyValue, error) { return update(key) }, opts...)
+		return err
+	})
+}
+
+type warmupJob struct {
+	loader   *warmupLoader
+	key      string
+	progress *WarmupProgress
+}
+
+// 依次列出所有任务的key并发加载,阻塞直到全部完成或ctx结束。
+// 单个key失败不会中断预热,失败记录在报告中;ctx结束时返回ctx.Err()
+func (w *Warmer) Run(ctx context.Context) (*WarmupReport, error) {
+	start := time.Now()
+	report := &WarmupReport{Progress: make([]WarmupProgress, len(w.loaders))}
+	var mu sync.Mutex
+	addErr := func(e *WarmupError) {
+		log.ErrLog("", e)
+		mu.Lock()
+		if len(report.Errors) < w.MaxErrors || w.MaxErrors <= 0 {
+			report.Errors = append(report.Errors, e)
+		}
+		mu.Unlock()
+	}
+
+	var tick <-chan time.Time
+	if w.Rate > 0 {
+		// Rate超过1e9时间隔为0,NewTicker会panic
+		interval := time.Second / time.Duration(w.Rate)
+		if interval <= 0 {
+			interval = time.Nanosecond
+		}
+		t := time.NewTicker(interval)
+		defer t.Stop()
+		tick = t.C
+	}
+	concurrency := w.Concurrency
+	if concurrency <= 0 {
+		concurrency = 4
+	}
+	jobs := make(chan warmupJob)
+	var wg sync.WaitGroup
+	for i := 0; i < concurrency; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for job := range jobs {
+				if err := job.loader.load(ctx, job.key); err != nil && err != ErrNotExist {
+					atomic.AddInt64(&job.progress.Failed, 1)
+					addErr(&WarmupError{Loader: job.loader.name, Key: job.key, Err: err})
+				}
+				atomic.AddInt64(&job.progress.Done, 1)
+				if w.OnProgress != nil {
+					w.OnProgress(WarmupProgress{
+						Loader: job.loader.name,
+						Total:  atomic.LoadInt64(&job.progress.Total),
+						Done:   atomic.LoadInt64(&job.progress.Done),
+						Failed: atomic.LoadInt64(&job.progress.Failed),
+					})
+				}
+			}
+		}()
+	}
+
+	err := func() error {
+		defer close(jobs)
+		for i, l := range w.loaders {
+			p := &report.Progress[i]
+			p.Loader = l.name
+			keys, err := l.keys(ctx)
+			if err != nil {
+				addErr(&WarmupError{Loader: l.name, Err: err})
+				continue
+			}
+			atomic.StoreInt64(&p.Total, int64(len(keys)))
+			for _, key := range keys {
+				if tick != nil {
+					select {
+					case <-ctx.Done():
+						return ctx.Err()
+					case <-tick:
+					}
+				}
+				select {
+				case <-ctx.Done():
+					return ctx.Err()
+				case jobs <- warmupJob{loader: l, key: key, progress: p}:
+				}
+			}
+		}
+		return nil
+	}()
+	wg.Wait()
+	report.Elapsed = time.Since(start)
+	return report, err
+}
@@ -0,0 +1,57 @@
+package redis
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"sync/atomic"
+	"testing"
+)
+
+func TestWarmerRun(t *testing.T) {
+	s := NewMemoryStore(60)
+	ctx := context.Background()
+	s.SetRawMessage(ctx, "user_1", json.RawMessage(`"cached"`))
+
+	var loads, progress int64
+	w := NewWarmer(s)
+	w.Concurrency = 2
+	w.Rate = 1000
+	w.OnProgress = func(p WarmupProgress) { atomic.AddInt64(&progress, 1) }
+	w.RegisterRawMessage("user", func(ctx context.Context) ([]string, error) {
+		return []string{"user_1", "user_2", "user_3", "user_4"}, nil
+	}, func(key string) (json.RawMessage, error) {
+		atomic.AddInt64(&loads, 1)
+		if key == "user_4" {
+			return nil, errors.New("db error")
+		}
+		return json.RawMessage(`"` + key + `"`), nil
+	})
+	report, err := w.Run(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if loads != 3 || progress != 4 {
+		t.Fatalf("loads = %d, progress = %d", loads, progress)
+	}
+	p := report.Progress[0]
+	if p.Total != 4 || p.Done != 4 || p.Failed != 1 || len(report.Errors) != 1 || report.Errors[0].Key != "user_4" {
+		t.Fatalf("report = %+v %v", p, report.Errors)
+	}
+	if data, err := s.GetRawMessage(ctx, "user_2"); err != nil || string(data) != `"user_2"` {
+		t.Fatalf("user_2 = %s, %v", data, err)
+	}
+}
+
+func TestWarmerHighRate(t *testing.T) {
+	w := NewWarmer(NewMemoryStore(60))
+	w.Rate = 2e9
+	w.RegisterRawMessage("user", func(ctx context.Context) ([]string, error) {
+		return []string{"user_1"}, nil
+	}, func(key string) (json.RawMessage, error) {
+		return json.RawMessage(`"` + key + `"`), nil
+	})
+	if _, err := w.Run(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,304 @@
+package feature
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/thesky9531/lareina/cache/redis"
+	"github.com/thesky9531/lareina/log"
+)
+
+// 开关不存在
+var ErrNotFound = errors.New("feature: flag not found")
+
+type Config struct {
+	// 存储开关的hash key,默认 feature_flags
+	Key string
+	// 开关变更通知频道,默认 Key + "_changed"
+	Channel string
+	// 定时全量刷新间隔,防止丢失变更通知,默认1分钟
+	RefreshInterval time.Duration
+}
+
+// 开关变更回调,删除时value为空
+type ChangeFunc func(name, old, value string)
+
+type watcher struct {
+	name string
+	fn   ChangeFunc
+}
+
+type change struct {
+	w                watcher
+	name, old, value string
+}
+
+// 功能开关和动态配置,存储在redis hash中,本地缓存全部开关,收到变更通知或定时刷新时重新加载
+type Flags struct {
+	cache    *redis.Cache
+	key      string
+	channel  string
+	interval time.Duration
+
+	// 保证变更按加载顺序进入回调队列
+	reloadMu sync.Mutex
+	mu       sync.RWMutex
+	values   map[string]string
+	watchers []watcher
+
+	// 待触发的回调,同一时间只有一个goroutine依次执行,
+	// 回调中调用Set/Delete产生的变更排在队列后面,不会死锁
+	notifyMu  sync.Mutex
+	pending   []change
+	notifying bool
+}
+
+func New(c *redis.Cache, conf *Config) *Flags {
+	f := &Flags{
+		cache:    c,
+		key:      conf.Key,
+		channel:  conf.Channel,
+		interval: conf.RefreshInterval,
+		values:   make(map[string]string),
+	}
+	if f.key == "" {
+		f.key = "feature_flags"
+	}
+	if f.channel == "" {
+		f.channel = f.key + "_changed"
+	}
+	if f.interval <= 0 {
+		f.interval = time.Minute
+	}
+	return f
+}
+
+// 加载全部开关并开始监听变更,后台运行直到ctx结束。首次加载失败时返回错误
+func (f *Flags) Start(ctx context.Context) error {
+	if err := f.Reload(ctx); err != nil {
+		return err
+	}
+	sub := f.cache.NewSubscriber(nil)
+	// 重连后可能丢失了变更通知,重新加载
+	sub.OnSubscribe = func() { f.Reload(ctx) }
+	if err := sub.Subscribe(f.channel, func(name string) { f.Reload(ctx) }); err != nil {
+		return err
+	}
+	go sub.Run(ctx)
+	go func() {
+		t := time.NewTicker(f.interval)
+		defer t.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-t.C:
+				f.Reload(ctx)
+			}
+		}
+	}()
+	return nil
+}
+
+// 从redis重新加载全部开关,有变化的开关触发回调。
+// 其他goroutine正在执行回调时,本次的回调由其排队执行,返回时可能还未触发
+func (f *Flags) Reload(ctx context.Context) error {
+	f.reloadMu.Lock()
+	values, err := f.cache.GetPersistentHash(ctx, f.key)
+	if err != nil {
+		f.reloadMu.Unlock()
+		return err
+	}
+	f.mu.Lock()
+	old := f.values
+	f.values = values
+	watchers := f.watchers
+	f.mu.Unlock()
+	var changes []change
+	for _, w := range watchers {
+		for name, v := range values {
+			if (w.name == "" || w.name == name) && old[name] != v {
+				changes = append(changes, change{w: w, name: name, old: old[name], value: v})
+			}
+		}
+		for name, v := range old {
+			if _, ok := values[name]; !ok && (w.name == "" || w.name == name) {
+				changes = append(changes, change{w: w, name: name, old: v})
+			}
+		}
+	}
+	f.notifyMu.Lock()
+	f.pending = append(f.pending, changes...)
+	f.notifyMu.Unlock()
+	f.reloadMu.Unlock()
+	f.drain()
+	return nil
+}
+
+// 不持有锁依次执行排队的回调
+func (f *Flags) drain() {
+	f.notifyMu.Lock()
+	defer f.notifyMu.Unlock()
+	if f.notifying {
+		return
+	}
+	f.notifying = true
+	for len(f.pending) > 0 {
+		c := f.pending[0]
+		f.pending = f.pending[1:]
+		f.notifyMu.Unlock()
+		f.notify(c.w, c.name, c.old, c.value)
+		f.notifyMu.Lock()
+	}
+	f.notifying = false
+}
+
+func (f *Flags) notify(w watcher, name, old, value string) {
+	defer func() {
+		if r := recover(); r != nil {
+			log.ErrLog("", fmt.Errorf("feature flag(%s) change callback panic: %v", name, r))
+		}
+	}()
+	w.fn(name, old, value)
+}
+
+// 注册变更回调,name为空时监听所有开关
+func (f *Flags) OnChange(name string, fn ChangeFunc) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.watchers = append(f.watchers, watcher{name: name, fn: fn})
+}
+
+// 写入开关并通知所有实例刷新
+func (f *Flags) Set(ctx context.Context, name string, value string) error {
+	if err := f.cache.SetPersistentHash(ctx, f.key, name, value); err != nil {
+		return err
+	}
+	return f.publish(ctx, name)
+}
+
+// 使用json编码写入
+func (f *Flags) SetJSON(ctx context.Context, name string, v interface{}) error {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	return f.Set(ctx, name, string(data))
+}
+
+// 删除开关并通知所有实例刷新
+func (f *Flags) Delete(ctx context.Context, name string) error {
+	if err := f.cache.DelPersistentHash(ctx, f.key, name); err != nil {
+		return err
+	}
+	return f.publish(ctx, name)
+}
+
+func (f *Flags) publish(ctx context.Context, name string) error {
+	// 本实例立即生效,其他实例通过订阅刷新
+	if err := f.Reload(ctx); err != nil {
+		return err
+	}
+	_, err := f.cache.Publish(ctx, f.channel, name)
+	return err
+}
+
+func (f *Flags) get(name string) (string, bool) {
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+	v, ok := f.values[name]
+	return v, ok
+}
+
+// 获取字符串值,不存在时返回def
+func (f *Flags) String(name string, def string) string {
+	if v, ok := f.get(name); ok {
+		return v
+	}
+	return def
+}
+
+// 获取布尔值,不存在或解析失败时返回def
+func (f *Flags) Bool(name string, def bool) bool {
+	v, ok := f.get(name)
+	if !ok {
+		return def
+	}
+	b, err := strconv.ParseBool(v)
+	if err != nil {
+		return def
+	}
+	return b
+}
+
+// 获取整数值,不存在或解析失败时返回def
+func (f *Flags) Int(name string, def int64) int64 {
+	v, ok := f.get(name)
+	if !ok {
+		return def
+	}
+	n, err := strconv.ParseInt(v, 10, 64)
+	if err != nil {
+		return def
+	}
+	return n
+}
+
+// 获取浮点数值,不存在或解析失败时返回def
+func (f *Flags) Float(name string, def float64) float64 {
+	v, ok := f.get(name)
+	if !ok {
+		return def
+	}
+	n, err := strconv.ParseFloat(v, 64)
+	if err != nil {
+		return def
+	}
+	return n
+}
+
+// 使用json解码到v,不存在时返回 ErrNotFound
+func (f *Flags) JSON(name string, v interface{}) error {
+	data, ok := f.get(name)
+	if !ok {
+		return ErrNotFound
+	}
+	return json.Unmarshal([]byte(data), v)
+}
+
+// 按用户灰度,开关值为 true/false 时对所有用户开启/关闭,
+// 为0-100的数字(可带%)时按百分比开启,1为1%而不是全部开启,同一用户在同一开关下结果稳定
+func (f *Flags) Enabled(name string, userID int64) bool {
+	v, ok := f.get(name)
+	if !ok {
+		return false
+	}
+	v = strings.TrimSpace(v)
+	switch strings.ToLower(v) {
+	case "true":
+		return true
+	case "false":
+		return false
+	}
+	percent, err := strconv.ParseFloat(strings.TrimSuffix(v, "%"), 64)
+	if err != nil {
+		return false
+	}
+	return bucket(name, userID) < percent*100
+}
+
+// 用户在开关下的分桶,取值 [0, 10000)
+func bucket(name string, userID int64) float64 {
+	h := fnv.New32a()
+	h.Write([]byte(name))
+	h.Write([]byte(":"))
+	h.Write([]byte(strconv.FormatInt(userID, 10)))
+	return float64(h.Sum32() % 10000)
+}
@@ -0,0 +1,225 @@
+package feature
+
+import (
+	"context"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/thesky9531/lareina/cache/redis"
+)
+
+func newTestFlags(values map[string]string) *Flags {
+	f := New(nil, &Config{})
+	f.values = values
+	return f
+}
+
+func TestGetters(t *testing.T) {
+	f := newTestFlags(map[string]string{
+		"on":    "true",
+		"limit": "20",
+		"bad":   "x",
+		"cfg":   `{"a":1}`,
+	})
+	if !f.Bool("on", false) || f.Bool("bad", false) || !f.Bool("missing", true) {
+		t.Fatal("Bool")
+	}
+	if f.Int("limit", 0) != 20 || f.Int("bad", 5) != 5 {
+		t.Fatal("Int")
+	}
+	var cfg struct{ A int }
+	if err := f.JSON("cfg", &cfg); err != nil || cfg.A != 1 {
+		t.Fatalf("JSON = %+v, %v", cfg, err)
+	}
+	if err := f.JSON("missing", &cfg); err != ErrNotFound {
+		t.Fatalf("JSON missing = %v", err)
+	}
+}
+
+func TestEnabledRollout(t *testing.T) {
+	f := newTestFlags(map[string]string{"all": "true", "none": "false", "part": "30%"})
+	enabled := 0
+	for id := int64(0); id < 10000; id++ {
+		if !f.Enabled("all", id) || f.Enabled("none", id) {
+			t.Fatal("bool rollout")
+		}
+		if f.Enabled("part", id) {
+			enabled++
+		}
+		if f.Enabled("part", id) != f.Enabled("part", id) {
+			t.Fatal("rollout not stable")
+		}
+	}
+	if enabled < 2700 || enabled > 3300 {
+		t.Fatalf("enabled = %d, want about 3000", enabled)
+	}
+	if f.Enabled("missing", 1) {
+		t.Fatal("missing flag enabled")
+	}
+
+	// 数字都按百分比处理
+	f = newTestFlags(map[string]string{"zero": "0", "one": "1", "hundred": "100"})
+	enabled = 0
+	for id := int64(0); id < 10000; id++ {
+		if f.Enabled("zero", id) || !f.Enabled("hundred", id) {
+			t.Fatal("numeric rollout")
+		}
+		if f.Enabled("one", id) {
+			enabled++
+		}
+	}
+	if enabled < 50 || enabled > 150 {
+		t.Fatalf("enabled = %d, want about 100", enabled)
+	}
+}
+
+func newTestCache(t *testing.T) (*redis.Cache, *miniredis.Miniredis) {
+	mr, err := miniredis.Run()
+	if err != nil {
+		t.Fatal(err)
+	}
+	c := redis.New(&redis.Config{Network: "tcp", Addr: mr.Addr(), ExpireTime: 60})
+	t.Cleanup(func() {
+		c.Close()
+		mr.Close()
+	})
+	return c, mr
+}
+
+// 等待条件成立,超时失败
+func waitFor(t *testing.T, msg string, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(2 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatal(msg)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+func TestFlagsSetDelete(t *testing.T) {
+	c, mr := newTestCache(t)
+	ctx := context.Background()
+	mr.HSet("feature_flags", "on", "true")
+	f := New(c, &Config{})
+	if err := f.Reload(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if !f.Bool("on", false) {
+		t.Fatal("Reload did not load existing flags")
+	}
+
+	var mu sync.Mutex
+	var got [][3]string
+	f.OnChange("", func(name, old, value string) {
+		mu.Lock()
+		got = append(got, [3]string{name, old, value})
+		mu.Unlock()
+	})
+	f.OnChange("other", func(name, old, value string) { t.Errorf("other notified for %s", name) })
+	if err := f.Set(ctx, "limit", "10"); err != nil {
+		t.Fatal(err)
+	}
+	if err := f.Set(ctx, "limit", "20"); err != nil {
+		t.Fatal(err)
+	}
+	if f.Int("limit", 0) != 20 || mr.HGet("feature_flags", "limit") != "20" {
+		t.Fatalf("limit = %d", f.Int("limit", 0))
+	}
+	if err := f.Delete(ctx, "limit"); err != nil {
+		t.Fatal(err)
+	}
+	if f.Int("limit", 5) != 5 {
+		t.Fatal("deleted flag still set")
+	}
+	want := [][3]string{{"limit", "", "10"}, {"limit", "10", "20"}, {"limit", "20", ""}}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("changes = %v, want %v", got, want)
+	}
+}
+
+func TestFlagsCallbackSet(t *testing.T) {
+	c, _ := newTestCache(t)
+	ctx := context.Background()
+	f := New(c, &Config{})
+	var order []string
+	f.OnChange("", func(name, old, value string) {
+		order = append(order, name)
+		// 回调中修改其他开关不会死锁,变更在当前回调之后触发
+		if name == "a" {
+			if err := f.Set(ctx, "b", value); err != nil {
+				t.Error(err)
+			}
+		}
+	})
+	done := make(chan struct{})
+	go func() {
+		f.Set(ctx, "a", "1")
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(2 * time.Second):
+		t.Fatal("Set in callback deadlocked")
+	}
+	if f.String("b", "") != "1" || !reflect.DeepEqual(order, []string{"a", "b"}) {
+		t.Fatalf("b = %q, order = %v", f.String("b", ""), order)
+	}
+}
+
+func TestFlagsStart(t *testing.T) {
+	c, mr := newTestCache(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	mr.HSet("feature_flags", "on", "true")
+	a := New(c, &Config{RefreshInterval: time.Hour})
+	b := New(c, &Config{RefreshInterval: time.Hour})
+	if err := b.Start(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if !b.Bool("on", false) {
+		t.Fatal("Start did not load flags")
+	}
+	waitFor(t, "not subscribed", func() bool {
+		return mr.PubSubNumSub("feature_flags_changed")["feature_flags_changed"] == 1
+	})
+	changed := make(chan string, 4)
+	b.OnChange("on", func(name, old, value string) { changed <- value })
+
+	// 其他实例修改后通过订阅刷新
+	if err := a.Set(context.Background(), "on", "false"); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case v := <-changed:
+		if v != "false" {
+			t.Fatalf("changed to %q", v)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("change not received")
+	}
+	if b.Bool("on", true) {
+		t.Fatal("flag not refreshed")
+	}
+	if err := a.Delete(context.Background(), "on"); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case v := <-changed:
+		if v != "" {
+			t.Fatalf("deleted value = %q", v)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("delete not received")
+	}
+
+	// redis不可用时首次加载失败
+	mr.Close()
+	if err := New(c, &Config{}).Start(ctx); err == nil {
+		t.Fatal("Start succeeded with redis down")
+	}
+}
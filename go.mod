@@ -3,9 +3,12 @@ module github.com/thesky9531/lareina
 go 1.16
 
 require (
+	github.com/DATA-DOG/go-sqlmock v1.5.0
+	github.com/alicebob/miniredis/v2 v2.30.0
 	github.com/gin-gonic/gin v1.7.6
 	github.com/gomodule/redigo v1.8.5
 	github.com/jmoiron/sqlx v1.3.4
+	github.com/klauspost/compress v1.13.6
 	github.com/lib/pq v1.10.2
 	github.com/micro/go-micro/v2 v2.9.1
 	github.com/micro/go-plugins/registry/kubernetes/v2 v2.9.1
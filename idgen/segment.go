@@ -0,0 +1,116 @@
+package idgen
+
+import (
+	"context"
+	"errors"
+	"sync"
+
+	"github.com/thesky9531/lareina/cache/redis"
+	"github.com/thesky9531/lareina/log"
+)
+
+type segmentRange struct {
+	cur, max int64
+}
+
+// 号段分配器,每次通过INCRBY从redis预留step个连续id在本地分配,
+// 当前号段剩余不足1/10时异步预取下一号段。id全局唯一且递增,但进程重启会跳过未用完的号段
+type Segment struct {
+	mu      sync.Mutex
+	cache   *redis.Cache
+	key     string
+	step    int64
+	cur     segmentRange
+	next    *segmentRange
+	loading bool
+	done    chan struct{}
+}
+
+// key为计数器名,step为每次预留的号段大小
+func NewSegment(c *redis.Cache, key string, step int64) *Segment {
+	if step <= 0 {
+		step = 1000
+	}
+	return &Segment{
+		cache: c,
+		key:   "idgen_segment_" + key,
+		step:  step,
+	}
+}
+
+func (s *Segment) fetch(ctx context.Context) (segmentRange, error) {
+	max, err := s.cache.IncrBy(ctx, s.key, s.step)
+	if err != nil {
+		return segmentRange{}, err
+	}
+	return segmentRange{cur: max - s.step, max: max}, nil
+}
+
+func (s *Segment) prefetch() {
+	s.loading = true
+	s.done = make(chan struct{})
+	go func(done chan struct{}) {
+		r, err := s.fetch(context.Background())
+		s.mu.Lock()
+		if err == nil {
+			s.next = &r
+		} else {
+			log.ErrLog(s.key, err)
+		}
+		s.loading = false
+		close(done)
+		s.mu.Unlock()
+	}(s.done)
+}
+
+// 获取下一个id
+func (s *Segment) Next(ctx context.Context) (int64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for s.cur.cur >= s.cur.max {
+		if s.next != nil {
+			s.cur, s.next = *s.next, nil
+			break
+		}
+		if s.loading {
+			// 等待预取结果
+			done := s.done
+			s.mu.Unlock()
+			select {
+			case <-done:
+			case <-ctx.Done():
+				s.mu.Lock()
+				return 0, ctx.Err()
+			}
+			// 预取失败时下一轮同步获取
+			s.mu.Lock()
+			continue
+		}
+		r, err := s.fetch(ctx)
+		if err != nil {
+			return 0, err
+		}
+		s.cur = r
+	}
+	s.cur.cur++
+	if !s.loading && s.next == nil && s.cur.max-s.cur.cur < s.step/10+1 {
+		s.prefetch()
+	}
+	return s.cur.cur, nil
+}
+
+// 批量获取n个id,可能跨越多个号段,不保证连续
+func (s *Segment) NextN(ctx context.Context, n int) ([]int64, error) {
+	if n <= 0 {
+		return nil, errors.New("idgen: n must be positive")
+	}
+	ids := make([]int64, 0, n)
+	for i := 0; i < n; i++ {
+		id, err := s.Next(ctx)
+		if err != nil {
+			return ids, err
+		}
+		ids = append(ids, id)
+	}
+	return ids, nil
+}
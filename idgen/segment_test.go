@@ -0,0 +1,104 @@
+package idgen
+
+import (
+	"context"
+	"testing"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/thesky9531/lareina/cache/redis"
+)
+
+func newTestCache(t *testing.T) (*redis.Cache, *miniredis.Miniredis) {
+	mr, err := miniredis.Run()
+	if err != nil {
+		t.Fatal(err)
+	}
+	c := redis.New(&redis.Config{Network: "tcp", Addr: mr.Addr(), ExpireTime: 60})
+	t.Cleanup(func() {
+		c.Close()
+		mr.Close()
+	})
+	return c, mr
+}
+
+// 等待正在进行的预取完成
+func waitPrefetch(s *Segment) {
+	s.mu.Lock()
+	done := s.done
+	s.mu.Unlock()
+	if done != nil {
+		<-done
+	}
+}
+
+func TestSegmentPrefetch(t *testing.T) {
+	c, mr := newTestCache(t)
+	ctx := context.Background()
+	s := NewSegment(c, "order", 10)
+	for i := int64(1); i <= 9; i++ {
+		id, err := s.Next(ctx)
+		if err != nil || id != i {
+			t.Fatalf("Next = %d, %v, want %d", id, err, i)
+		}
+	}
+	// 剩余不足1/10时已预取下一号段
+	waitPrefetch(s)
+	s.mu.Lock()
+	next := s.next
+	s.mu.Unlock()
+	if next == nil || *next != (segmentRange{cur: 10, max: 20}) {
+		t.Fatalf("prefetched = %+v, want {10 20}", next)
+	}
+	if v, _ := mr.Get("idgen_segment_order"); v != "20" {
+		t.Fatalf("counter = %s, want 20", v)
+	}
+	// 用完当前号段后切换到预取的号段
+	for i := int64(10); i <= 12; i++ {
+		id, err := s.Next(ctx)
+		if err != nil || id != i {
+			t.Fatalf("Next = %d, %v, want %d", id, err, i)
+		}
+	}
+}
+
+func TestSegmentRollover(t *testing.T) {
+	c, _ := newTestCache(t)
+	ctx := context.Background()
+	a, b := NewSegment(c, "user", 10), NewSegment(c, "user", 10)
+	seen := make(map[int64]bool)
+	var lastA, lastB int64
+	for i := 0; i < 50; i++ {
+		ida, err := a.Next(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		idb, err := b.Next(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if ida <= lastA || idb <= lastB {
+			t.Fatalf("ids not increasing: a %d->%d, b %d->%d", lastA, ida, lastB, idb)
+		}
+		lastA, lastB = ida, idb
+		if seen[ida] || seen[idb] {
+			t.Fatalf("duplicate id %d/%d", ida, idb)
+		}
+		seen[ida], seen[idb] = true, true
+		waitPrefetch(a)
+		waitPrefetch(b)
+	}
+
+	ids, err := a.NextN(ctx, 25)
+	if err != nil || len(ids) != 25 {
+		t.Fatalf("NextN = %d ids, %v", len(ids), err)
+	}
+	for _, id := range ids {
+		if seen[id] {
+			t.Fatalf("duplicate id %d", id)
+		}
+		seen[id] = true
+	}
+	if _, err = a.NextN(ctx, 0); err == nil {
+		t.Error("NextN(0) accepted")
+	}
+}
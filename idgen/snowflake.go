@@ -0,0 +1,202 @@
+package idgen
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/thesky9531/lareina/cache/redis"
+	"github.com/thesky9531/lareina/log"
+)
+
+// id结构: 1位符号 + 41位毫秒时间戳 + 10位workerID + 12位序列号
+const (
+	workerBits   = 10
+	sequenceBits = 12
+	MaxWorkerID  = 1<<workerBits - 1
+	maxSequence  = 1<<sequenceBits - 1
+	// 时钟回拨在该范围内时等待,超过则返回错误
+	maxBackwards = 5 * time.Millisecond
+)
+
+var (
+	// 所有workerID都已被占用
+	ErrNoWorkerID = errors.New("idgen: no free worker id")
+	// workerID租约丢失(超过租约截止时间未续期成功),此时生成的id可能重复
+	ErrLeaseLost = errors.New("idgen: worker id lease lost")
+	// 时钟回拨超过 maxBackwards
+	ErrClockBackwards = errors.New("idgen: clock moved backwards")
+)
+
+// 默认起始时间 2020-01-01 UTC
+var DefaultEpoch = time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+
+type SnowflakeConfig struct {
+	// 业务名,不同业务的workerID独立分配
+	Name string
+	// 起始时间,默认 DefaultEpoch,上线后不可修改
+	Epoch time.Time
+	// workerID租约时间(秒),默认30,每1/3租约时间续期一次
+	LeaseTTL int
+}
+
+// 雪花算法id生成器,workerID从redis租用,进程退出或崩溃后租约过期自动释放
+type Snowflake struct {
+	mu       sync.Mutex
+	epoch    int64
+	workerID int64
+	lastTime int64
+	sequence int64
+	lost     bool
+	// 租约截止时间(纳秒),超过后拒绝生成id,0为不检查
+	deadline int64
+
+	cache  *redis.Cache
+	lock   *redis.Lock
+	ttl    int
+	closed chan struct{}
+	once   sync.Once
+}
+
+func workerKey(name string, id int64) string {
+	return fmt.Sprintf("idgen_worker_%s_%d", name, id)
+}
+
+func newSnowflake(epoch time.Time, workerID int64) *Snowflake {
+	return &Snowflake{
+		epoch:    epoch.UnixNano() / int64(time.Millisecond),
+		workerID: workerID,
+		lastTime: -1,
+		closed:   make(chan struct{}),
+	}
+}
+
+// 从redis租用一个空闲的workerID并创建生成器,使用完需调用Close释放
+func NewSnowflake(ctx context.Context, c *redis.Cache, conf *SnowflakeConfig) (*Snowflake, error) {
+	epoch := conf.Epoch
+	if epoch.IsZero() {
+		epoch = DefaultEpoch
+	}
+	ttl := conf.LeaseTTL
+	if ttl <= 0 {
+		ttl = 30
+	}
+	for id := int64(0); id <= MaxWorkerID; id++ {
+		start := time.Now()
+		lock, err := c.TryLock(ctx, workerKey(conf.Name, id), ttl)
+		if err != nil {
+			return nil, err
+		}
+		if lock == nil {
+			continue
+		}
+		s := newSnowflake(epoch, id)
+		s.cache = c
+		s.lock = lock
+		s.ttl = ttl
+		s.deadline = s.leaseDeadline(start)
+		go s.keepalive()
+		return s, nil
+	}
+	log.ErrLog(conf.Name, ErrNoWorkerID)
+	return nil, ErrNoWorkerID
+}
+
+func (s *Snowflake) renewInterval() time.Duration {
+	return time.Duration(s.ttl) * time.Second / 3
+}
+
+// 租约截止时间,从发起请求的时间算起并预留一个续期间隔的余量,
+// 避免本地时钟误差或请求延迟导致租约在redis上已过期而本地仍在生成id
+func (s *Snowflake) leaseDeadline(start time.Time) int64 {
+	return start.Add(time.Duration(s.ttl)*time.Second - s.renewInterval()).UnixNano()
+}
+
+// 定时续期租约,超过租约截止时间仍未续期成功或租约被他人持有时标记丢失
+func (s *Snowflake) keepalive() {
+	t := time.NewTicker(s.renewInterval())
+	defer t.Stop()
+	for {
+		select {
+		case <-s.closed:
+			return
+		case <-t.C:
+		}
+		start := time.Now()
+		ok, err := s.cache.ExtendLock(context.Background(), s.lock, s.ttl)
+		s.mu.Lock()
+		if err == nil && ok {
+			s.deadline = s.leaseDeadline(start)
+			s.mu.Unlock()
+			continue
+		}
+		if err == nil || time.Now().UnixNano() >= s.deadline {
+			log.ErrLog(fmt.Sprintf("worker(%d)", s.workerID), ErrLeaseLost)
+			s.lost = true
+			s.mu.Unlock()
+			return
+		}
+		s.mu.Unlock()
+	}
+}
+
+func (s *Snowflake) WorkerID() int64 {
+	return s.workerID
+}
+
+// 生成id,同一毫秒内序列号用完时等待下一毫秒
+func (s *Snowflake) NextID() (int64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.lost {
+		return 0, ErrLeaseLost
+	}
+	nano := time.Now().UnixNano()
+	if s.deadline > 0 && nano >= s.deadline {
+		return 0, ErrLeaseLost
+	}
+	now := nano / int64(time.Millisecond)
+	if now < s.lastTime {
+		if time.Duration(s.lastTime-now)*time.Millisecond > maxBackwards {
+			return 0, ErrClockBackwards
+		}
+		for now < s.lastTime {
+			time.Sleep(time.Duration(s.lastTime-now) * time.Millisecond)
+			now = time.Now().UnixNano() / int64(time.Millisecond)
+		}
+	}
+	if now == s.lastTime {
+		s.sequence = (s.sequence + 1) & maxSequence
+		if s.sequence == 0 {
+			for now <= s.lastTime {
+				time.Sleep(100 * time.Microsecond)
+				now = time.Now().UnixNano() / int64(time.Millisecond)
+			}
+		}
+	} else {
+		s.sequence = 0
+	}
+	s.lastTime = now
+	return (now-s.epoch)<<(workerBits+sequenceBits) | s.workerID<<sequenceBits | s.sequence, nil
+}
+
+// 解析id中的时间、workerID和序列号
+func (s *Snowflake) Parse(id int64) (t time.Time, workerID int64, sequence int64) {
+	ms := id>>(workerBits+sequenceBits) + s.epoch
+	t = time.Unix(0, ms*int64(time.Millisecond))
+	workerID = id >> sequenceBits & MaxWorkerID
+	sequence = id & maxSequence
+	return
+}
+
+// 停止续期并释放workerID
+func (s *Snowflake) Close(ctx context.Context) {
+	s.once.Do(func() {
+		close(s.closed)
+		if s.cache != nil {
+			s.cache.Unlock(ctx, s.lock)
+		}
+	})
+}
@@ -0,0 +1,53 @@
+package idgen
+
+import (
+	"testing"
+	"time"
+)
+
+func TestSnowflakeNextID(t *testing.T) {
+	s := newSnowflake(DefaultEpoch, 7)
+	var last int64
+	for i := 0; i < 10000; i++ {
+		id, err := s.NextID()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if id <= last {
+			t.Fatalf("id %d not greater than %d", id, last)
+		}
+		last = id
+	}
+	ts, worker, _ := s.Parse(last)
+	if worker != 7 {
+		t.Fatalf("worker = %d, want 7", worker)
+	}
+	if d := time.Since(ts); d < 0 || d > time.Second {
+		t.Fatalf("timestamp %v too far from now", ts)
+	}
+}
+
+func TestSnowflakeLeaseLost(t *testing.T) {
+	s := newSnowflake(DefaultEpoch, 1)
+	s.lost = true
+	if _, err := s.NextID(); err != ErrLeaseLost {
+		t.Fatalf("err = %v, want ErrLeaseLost", err)
+	}
+}
+
+func TestSnowflakeLeaseDeadline(t *testing.T) {
+	s := newSnowflake(DefaultEpoch, 1)
+	s.ttl = 30
+	s.deadline = s.leaseDeadline(time.Now())
+	if _, err := s.NextID(); err != nil {
+		t.Fatal(err)
+	}
+	// 截止时间预留一个续期间隔
+	if d := time.Until(time.Unix(0, s.deadline)); d > 20*time.Second {
+		t.Fatalf("deadline in %v, want <= 20s", d)
+	}
+	s.deadline = time.Now().Add(-time.Millisecond).UnixNano()
+	if _, err := s.NextID(); err != ErrLeaseLost {
+		t.Fatalf("err = %v, want ErrLeaseLost", err)
+	}
+}
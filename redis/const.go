@@ -18,4 +18,15 @@ const (
 	EXPIRE  = "EXPIRE"
 	RPUSH   = "RPUSH"
 	BRPOP   = "BRPOP"
+
+	SET        = "SET"
+	EXISTS     = "EXISTS"
+	LREM       = "LREM"
+	LLEN       = "LLEN"
+	RPOPLPUSH  = "RPOPLPUSH"
+	BRPOPLPUSH = "BRPOPLPUSH"
+	BLMOVE     = "BLMOVE"
+	SADD       = "SADD"
+	SREM       = "SREM"
+	SMEMBERS   = "SMEMBERS"
 )
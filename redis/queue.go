@@ -0,0 +1,269 @@
+package redis
+
+import (
+	"context"
+	"log"
+	"time"
+
+	"github.com/gomodule/redigo/redis"
+)
+
+// 消费者心跳已过期时将其处理中的消息全部放回队列并注销,检查和回收在同一脚本内完成,
+// 避免检查后消费者恢复心跳时消息被重复投递
+var reapConsumerScript = redis.NewScript(4, `
+if redis.call("EXISTS", KEYS[1]) == 1 then
+	return -1
+end
+local n = 0
+while redis.call("RPOPLPUSH", KEYS[2], KEYS[3]) do
+	n = n + 1
+end
+redis.call("SREM", KEYS[4], ARGV[1])
+return n`)
+
+// 将处理中的消息全部放回队列
+var reapScript = redis.NewScript(2, `
+local n = 0
+while redis.call("RPOPLPUSH", KEYS[1], KEYS[2]) do
+	n = n + 1
+end
+return n`)
+
+// 处理失败的消息从处理中列表移回队列
+var requeueScript = redis.NewScript(2, `
+if redis.call("LREM", KEYS[1], 1, ARGV[1]) > 0 then
+	redis.call("LPUSH", KEYS[2], ARGV[1])
+	return 1
+end
+return 0`)
+
+// 可靠队列,消费时消息先转移到消费者自己的处理中列表,确认后才删除
+type ReliableQueue struct {
+	session *RedisSession
+	name    string
+}
+
+// 队列消费者
+type QueueConsumer struct {
+	queue *ReliableQueue
+	id    string
+
+	// 心跳过期时间(秒),超过该时间没有心跳的消费者会被回收,默认30
+	HeartbeatTTL int
+	// 使用BLMOVE代替BRPOPLPUSH,需要redis 6.2以上
+	UseBLMove bool
+}
+
+func (r *RedisSession) NewReliableQueue(name string) *ReliableQueue {
+	return &ReliableQueue{
+		session: r,
+		name:    name,
+	}
+}
+
+func (q *ReliableQueue) queueKey() string {
+	return q.session.Getprefix() + q.name
+}
+
+func (q *ReliableQueue) consumersKey() string {
+	return q.session.Getprefix() + q.name + ":consumers"
+}
+
+func (q *ReliableQueue) processingKey(consumer string) string {
+	return q.session.Getprefix() + q.name + ":processing:" + consumer
+}
+
+func (q *ReliableQueue) heartbeatKey(consumer string) string {
+	return q.session.Getprefix() + q.name + ":heartbeat:" + consumer
+}
+
+// 写入消息
+func (q *ReliableQueue) Push(msgs ...string) error {
+	if len(msgs) == 0 {
+		return nil
+	}
+	conn := q.session.GetConn()
+	defer Close(conn)
+	args := make([]interface{}, 0, len(msgs)+1)
+	args = append(args, q.queueKey())
+	for _, m := range msgs {
+		args = append(args, m)
+	}
+	_, err := conn.Do(LPUSH, args...)
+	return err
+}
+
+// 获取队列中等待消费的消息数量
+func (q *ReliableQueue) Len() (int64, error) {
+	conn := q.session.GetConn()
+	defer Close(conn)
+	return redis.Int64(conn.Do(LLEN, q.queueKey()))
+}
+
+// 创建消费者,id在队列内需唯一,如 hostname_pid
+func (q *ReliableQueue) NewConsumer(id string) *QueueConsumer {
+	return &QueueConsumer{
+		queue:        q,
+		id:           id,
+		HeartbeatTTL: 30,
+	}
+}
+
+// 回收心跳过期的消费者,将其处理中的消息放回队列,返回放回的消息数量
+func (q *ReliableQueue) Reap() (int64, error) {
+	conn := q.session.GetConn()
+	defer Close(conn)
+	consumers, err := redis.Strings(conn.Do(SMEMBERS, q.consumersKey()))
+	if err != nil {
+		return 0, err
+	}
+	var total int64
+	for _, c := range consumers {
+		n, err := redis.Int64(reapConsumerScript.Do(conn, q.heartbeatKey(c), q.processingKey(c), q.queueKey(),
+			q.consumersKey(), c))
+		if err != nil {
+			return total, err
+		}
+		if n > 0 {
+			total += n
+		}
+	}
+	return total, nil
+}
+
+// 定时回收失效消费者,阻塞直到ctx结束
+func (q *ReliableQueue) RunReaper(ctx context.Context, interval time.Duration) {
+	t := time.NewTicker(interval)
+	defer t.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-t.C:
+			if n, err := q.Reap(); err != nil {
+				log.Printf("reliable queue(%s) reap failure: %v", q.name, err)
+			} else if n > 0 {
+				log.Printf("reliable queue(%s) requeued %d messages", q.name, n)
+			}
+		}
+	}
+}
+
+// 上报心跳并注册消费者
+func (c *QueueConsumer) Heartbeat() error {
+	conn := c.queue.session.GetConn()
+	defer Close(conn)
+	if _, err := conn.Do(SET, c.queue.heartbeatKey(c.id), time.Now().Unix(), "EX", c.HeartbeatTTL); err != nil {
+		return err
+	}
+	_, err := conn.Do(SADD, c.queue.consumersKey(), c.id)
+	return err
+}
+
+// 按心跳过期时间的1/3定时上报心跳,阻塞直到ctx结束
+func (c *QueueConsumer) keepalive(ctx context.Context) {
+	interval := time.Duration(c.HeartbeatTTL) * time.Second / 3
+	if interval < time.Second {
+		interval = time.Second
+	}
+	t := time.NewTicker(interval)
+	defer t.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-t.C:
+			if err := c.Heartbeat(); err != nil {
+				log.Printf("reliable queue(%s) consumer(%s) heartbeat failure: %v", c.queue.name, c.id, err)
+			}
+		}
+	}
+}
+
+// 阻塞获取一条消息并转移到处理中列表,超时返回 redis.ErrNil
+func (c *QueueConsumer) Pop(timeout time.Duration) (string, error) {
+	if err := c.Heartbeat(); err != nil {
+		return "", err
+	}
+	conn := c.queue.session.GetConn()
+	defer Close(conn)
+	sec := int(timeout / time.Second)
+	if sec <= 0 {
+		sec = 1
+	}
+	if c.UseBLMove {
+		return redis.String(conn.Do(BLMOVE, c.queue.queueKey(), c.queue.processingKey(c.id), "RIGHT", "LEFT", sec))
+	}
+	return redis.String(conn.Do(BRPOPLPUSH, c.queue.queueKey(), c.queue.processingKey(c.id), sec))
+}
+
+// 确认消息处理完成,从处理中列表删除
+func (c *QueueConsumer) Ack(msg string) error {
+	conn := c.queue.session.GetConn()
+	defer Close(conn)
+	_, err := conn.Do(LREM, c.queue.processingKey(c.id), 1, msg)
+	return err
+}
+
+// 处理失败,消息放回队列
+func (c *QueueConsumer) Nack(msg string) error {
+	conn := c.queue.session.GetConn()
+	defer Close(conn)
+	_, err := requeueScript.Do(conn, c.queue.processingKey(c.id), c.queue.queueKey(), msg)
+	return err
+}
+
+// 停止消费,将处理中的消息放回队列并注销消费者
+func (c *QueueConsumer) Close() error {
+	conn := c.queue.session.GetConn()
+	defer Close(conn)
+	if _, err := reapScript.Do(conn, c.queue.processingKey(c.id), c.queue.queueKey()); err != nil {
+		return err
+	}
+	if _, err := conn.Do(DEL, c.queue.heartbeatKey(c.id)); err != nil {
+		return err
+	}
+	_, err := conn.Do(SREM, c.queue.consumersKey(), c.id)
+	return err
+}
+
+// 循环消费消息,handler返回nil时确认,否则放回队列,阻塞直到ctx结束。
+// 处理消息期间后台持续上报心跳,避免耗时较长的消息被回收
+func (c *QueueConsumer) Run(ctx context.Context, handler func(msg string) error) {
+	hbCtx, cancel := context.WithCancel(ctx)
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		c.keepalive(hbCtx)
+	}()
+	defer func() {
+		// 先停止心跳再注销,避免注销后又被心跳重新注册
+		cancel()
+		<-done
+		if err := c.Close(); err != nil {
+			log.Printf("reliable queue(%s) consumer(%s) close failure: %v", c.queue.name, c.id, err)
+		}
+	}()
+	for ctx.Err() == nil {
+		msg, err := c.Pop(time.Second)
+		if err != nil {
+			if err != redis.ErrNil {
+				log.Printf("reliable queue(%s) consumer(%s) pop failure: %v", c.queue.name, c.id, err)
+				select {
+				case <-ctx.Done():
+				case <-time.After(time.Second):
+				}
+			}
+			continue
+		}
+		if err = handler(msg); err != nil {
+			log.Printf("reliable queue(%s) consumer(%s) handle failure: %v", c.queue.name, c.id, err)
+			err = c.Nack(msg)
+		} else {
+			err = c.Ack(msg)
+		}
+		if err != nil {
+			log.Printf("reliable queue(%s) consumer(%s) ack failure: %v", c.queue.name, c.id, err)
+		}
+	}
+}
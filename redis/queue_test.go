@@ -0,0 +1,121 @@
+package redis
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/gomodule/redigo/redis"
+)
+
+func newTestSession(t *testing.T) (*RedisSession, *miniredis.Miniredis) {
+	s, err := miniredis.Run()
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(s.Close)
+	r := &RedisSession{
+		pool: &redis.Pool{
+			Dial: func() (redis.Conn, error) {
+				return redis.Dial("tcp", s.Addr())
+			},
+		},
+	}
+	r.Setprefix("test")
+	t.Cleanup(func() { r.pool.Close() })
+	return r, s
+}
+
+func TestReliableQueue(t *testing.T) {
+	r, s := newTestSession(t)
+	q := r.NewReliableQueue("q")
+	if err := q.Push("a", "b", "c"); err != nil {
+		t.Fatal(err)
+	}
+	c := q.NewConsumer("c1")
+	msg, err := c.Pop(time.Second)
+	if err != nil || msg != "a" {
+		t.Fatalf("Pop = %q, %v", msg, err)
+	}
+	if err = c.Ack(msg); err != nil {
+		t.Fatal(err)
+	}
+	if msg, err = c.Pop(time.Second); err != nil || msg != "b" {
+		t.Fatalf("Pop = %q, %v", msg, err)
+	}
+	if err = c.Nack(msg); err != nil {
+		t.Fatal(err)
+	}
+	if n, err := q.Len(); err != nil || n != 2 {
+		t.Fatalf("Len after nack = %d, %v", n, err)
+	}
+
+	// 心跳有效时不回收
+	if msg, err = c.Pop(time.Second); err != nil || msg != "c" {
+		t.Fatalf("Pop = %q, %v", msg, err)
+	}
+	if n, err := q.Reap(); err != nil || n != 0 {
+		t.Fatalf("Reap alive = %d, %v", n, err)
+	}
+	// 心跳过期后处理中的消息放回队列并注销消费者
+	s.FastForward(time.Duration(c.HeartbeatTTL+1) * time.Second)
+	if n, err := q.Reap(); err != nil || n != 1 {
+		t.Fatalf("Reap dead = %d, %v", n, err)
+	}
+	if ok, _ := s.SIsMember(q.consumersKey(), c.id); ok {
+		t.Fatal("reaped consumer still registered")
+	}
+	if n, _ := q.Len(); n != 2 {
+		t.Fatalf("Len after reap = %d", n)
+	}
+}
+
+func TestReliableQueueRun(t *testing.T) {
+	r, s := newTestSession(t)
+	q := r.NewReliableQueue("q")
+	if err := q.Push("ok", "fail"); err != nil {
+		t.Fatal(err)
+	}
+	c := q.NewConsumer("c1")
+	c.HeartbeatTTL = 3
+	ctx, cancel := context.WithCancel(context.Background())
+	failed := false
+	handled := make(chan string, 4)
+	go func() {
+		c.Run(ctx, func(msg string) error {
+			handled <- msg
+			if msg == "fail" && !failed {
+				failed = true
+				return errors.New("fail")
+			}
+			if msg == "ok" {
+				// 处理期间心跳由后台刷新
+				s.FastForward(2 * time.Second)
+				time.Sleep(1500 * time.Millisecond)
+				s.FastForward(2 * time.Second)
+				if n, err := q.Reap(); err != nil || n != 0 {
+					t.Errorf("Reap while handling = %d, %v", n, err)
+				}
+			}
+			return nil
+		})
+		close(handled)
+	}()
+	want := []string{"ok", "fail", "fail"}
+	for _, w := range want {
+		if msg := <-handled; msg != w {
+			t.Fatalf("handled %q, want %q", msg, w)
+		}
+	}
+	cancel()
+	for range handled {
+	}
+	if n, _ := q.Len(); n != 0 {
+		t.Fatalf("Len after run = %d", n)
+	}
+	if s.Exists(q.heartbeatKey(c.id)) {
+		t.Fatal("heartbeat kept after close")
+	}
+}
@@ -15,7 +15,7 @@ func TestRids(t *testing.T) {
 		MaxIdle:     20,
 		IdleTimeout: 240,
 	}
-	err := LoadRedisSession(rc)
+	err := LoadRedisSession(&rc)
 	if err != nil {
 		fmt.Println(err)
 	}
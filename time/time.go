@@ -14,6 +14,7 @@ const (
 	TimeIntervalMonth
 	TimeIntervalQuarter
 	TimeIntervalYear
+	TimeIntervalWeek
 )
 
 func GetTimeInterval(str string) uint32 {
@@ -32,6 +33,8 @@ func GetTimeInterval(str string) uint32 {
 		return TimeIntervalQuarter
 	case "year":
 		return TimeIntervalYear
+	case "week":
+		return TimeIntervalWeek
 	default:
 		return 0
 	}
@@ -48,6 +51,16 @@ func UpdateContextDuration(d time.Duration, c context.Context) (time.Duration, c
 	return d, ctx, cancel
 }
 
+// 获取某分钟开始（0秒等）
+func GetOneMinuteStart(d time.Time) time.Time {
+	return time.Date(d.Year(), d.Month(), d.Day(), d.Hour(), d.Minute(), 0, 0, d.Location())
+}
+
+// 获取某小时开始（0分0秒等）
+func GetOneHourStart(d time.Time) time.Time {
+	return time.Date(d.Year(), d.Month(), d.Day(), d.Hour(), 0, 0, 0, d.Location())
+}
+
 // 获取某日开始（0分0秒等）
 func GetOnedayStart(d time.Time) time.Time {
 	return time.Date(d.Year(), d.Month(), d.Day(), 0, 0, 0, 0, d.Location())
@@ -123,6 +136,61 @@ func GetOneYearEnd(d time.Time) time.Time {
 	return rel.Add(-1)
 }
 
+// 是否为支持的时间间隔
+func IsValidInterval(interval uint32) bool {
+	return interval >= TimeIntervalMin && interval <= TimeIntervalWeek
+}
+
+// 按时间间隔获取所在周期开始,不支持的间隔返回原时间
+func GetIntervalStart(d time.Time, interval uint32) time.Time {
+	switch interval {
+	case TimeIntervalMin:
+		return GetOneMinuteStart(d)
+	case TimeIntervalHour:
+		return GetOneHourStart(d)
+	case TimeIntervalDay:
+		return GetOnedayStart(d)
+	case TimeIntervalTwoDay:
+		rel := GetOnedayStart(d)
+		return rel.AddDate(0, 0, -(rel.YearDay()-1)%2)
+	case TimeIntervalMonth:
+		return GetOneMonthStart(d)
+	case TimeIntervalQuarter:
+		return GetOneSeasonStart(d)
+	case TimeIntervalYear:
+		return GetOneYearStart(d)
+	case TimeIntervalWeek:
+		return GetOneWeekStart(d)
+	default:
+		return d
+	}
+}
+
+// 按时间间隔获取下一个周期开始
+func GetNextIntervalStart(d time.Time, interval uint32) time.Time {
+	rel := GetIntervalStart(d, interval)
+	switch interval {
+	case TimeIntervalMin:
+		return rel.Add(time.Minute)
+	case TimeIntervalHour:
+		return rel.Add(time.Hour)
+	case TimeIntervalDay:
+		return rel.AddDate(0, 0, 1)
+	case TimeIntervalTwoDay:
+		return rel.AddDate(0, 0, 2)
+	case TimeIntervalMonth:
+		return rel.AddDate(0, 1, 0)
+	case TimeIntervalQuarter:
+		return rel.AddDate(0, 3, 0)
+	case TimeIntervalYear:
+		return rel.AddDate(1, 0, 0)
+	case TimeIntervalWeek:
+		return rel.AddDate(0, 0, 7)
+	default:
+		return d
+	}
+}
+
 // 获取某日开始（0分0秒等）
 func GetOnedayStartString(d time.Time) string {
 	return GetOnedayStart(d).Local().Format(time.RFC3339)
@@ -0,0 +1,52 @@
+package time
+
+import (
+	"testing"
+	"time"
+)
+
+func TestGetIntervalStart(t *testing.T) {
+	d := time.Date(2021, 8, 18, 13, 45, 30, 500, time.UTC)
+	cases := []struct {
+		interval    uint32
+		start, next time.Time
+	}{
+		{TimeIntervalMin, time.Date(2021, 8, 18, 13, 45, 0, 0, time.UTC), time.Date(2021, 8, 18, 13, 46, 0, 0, time.UTC)},
+		{TimeIntervalHour, time.Date(2021, 8, 18, 13, 0, 0, 0, time.UTC), time.Date(2021, 8, 18, 14, 0, 0, 0, time.UTC)},
+		{TimeIntervalDay, time.Date(2021, 8, 18, 0, 0, 0, 0, time.UTC), time.Date(2021, 8, 19, 0, 0, 0, 0, time.UTC)},
+		{TimeIntervalTwoDay, time.Date(2021, 8, 17, 0, 0, 0, 0, time.UTC), time.Date(2021, 8, 19, 0, 0, 0, 0, time.UTC)},
+		{TimeIntervalMonth, time.Date(2021, 8, 1, 0, 0, 0, 0, time.UTC), time.Date(2021, 9, 1, 0, 0, 0, 0, time.UTC)},
+		{TimeIntervalQuarter, time.Date(2021, 7, 1, 0, 0, 0, 0, time.UTC), time.Date(2021, 10, 1, 0, 0, 0, 0, time.UTC)},
+		{TimeIntervalYear, time.Date(2021, 1, 1, 0, 0, 0, 0, time.UTC), time.Date(2022, 1, 1, 0, 0, 0, 0, time.UTC)},
+	}
+	for _, c := range cases {
+		if start := GetIntervalStart(d, c.interval); !start.Equal(c.start) {
+			t.Errorf("GetIntervalStart(%d) = %v, want %v", c.interval, start, c.start)
+		}
+		if next := GetNextIntervalStart(d, c.interval); !next.Equal(c.next) {
+			t.Errorf("GetNextIntervalStart(%d) = %v, want %v", c.interval, next, c.next)
+		}
+	}
+	if GetIntervalStart(d, 0) != d || IsValidInterval(0) || IsValidInterval(TimeIntervalWeek+1) {
+		t.Error("unsupported interval")
+	}
+}
+
+func TestWeekInterval(t *testing.T) {
+	monday := time.Date(2021, 8, 16, 0, 0, 0, 0, time.UTC)
+	for _, d := range []time.Time{
+		monday,
+		time.Date(2021, 8, 18, 13, 0, 0, 0, time.UTC),
+		time.Date(2021, 8, 22, 23, 59, 59, 0, time.UTC), // 周日属于上一个周一开始的周
+	} {
+		if start := GetIntervalStart(d, TimeIntervalWeek); !start.Equal(monday) {
+			t.Errorf("GetIntervalStart(%v, week) = %v, want %v", d, start, monday)
+		}
+		if next := GetNextIntervalStart(d, TimeIntervalWeek); !next.Equal(monday.AddDate(0, 0, 7)) {
+			t.Errorf("GetNextIntervalStart(%v, week) = %v", d, next)
+		}
+	}
+	if GetTimeInterval("week") != TimeIntervalWeek {
+		t.Error("GetTimeInterval(week)")
+	}
+}